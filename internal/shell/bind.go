@@ -0,0 +1,377 @@
+package shell
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/chzyer/readline"
+)
+
+// metaBase is where the runes the editor sees for Alt keys start: Alt-c is
+// metaBase+c. readline has keys of its own only for Alt-B, Alt-F and Alt-D
+// and drops the Escape of the others, so keyReader replaces their escape
+// sequences before readline reads them.
+const metaBase = '\uE100'
+
+// keySeq is a key sequence that can be bound: one key, or Ctrl-X and a
+// second key.
+type keySeq [2]rune
+
+// keyBinding is what a bound key sequence does: run an editing action, or
+// type text.
+type keyBinding struct {
+	action string
+	macro  string // text typed by the key, if action is empty
+}
+
+// editActions are the editing actions keys can be bound to, with the key
+// readline or the editor knows each by. edit-command-line has no key of
+// its own; the editor handles it.
+var editActions = []struct {
+	name string
+	key  rune
+}{
+	{"accept-line", readline.CharEnter},
+	{"backward-char", readline.CharBackward},
+	{"backward-delete-char", readline.CharBackspace},
+	{"backward-kill-word", readline.CharCtrlW},
+	{"backward-word", readline.MetaBackward},
+	{"beginning-of-line", readline.CharLineStart},
+	{"clear-screen", readline.CharCtrlL},
+	{"complete", readline.CharTab},
+	{"delete-char", readline.CharDelete},
+	{"edit-command-line", 0},
+	{"end-of-line", readline.CharLineEnd},
+	{"forward-char", readline.CharForward},
+	{"forward-word", readline.MetaForward},
+	{"history-search", readline.CharBckSearch},
+	{"kill-line", readline.CharKill},
+	{"kill-word", readline.MetaDelete},
+	{"next-history", readline.CharNext},
+	{"previous-history", readline.CharPrev},
+	{"transpose-chars", readline.CharTranspose},
+	{"unix-line-discard", readline.CharCtrlU},
+	{"yank", readline.CharCtrlY},
+}
+
+// actionKey returns the key of the editing action name.
+func actionKey(name string) (rune, bool) {
+	for _, a := range editActions {
+		if a.name == name {
+			return a.key, true
+		}
+	}
+	return 0, false
+}
+
+// runBind implements the bind builtin:
+//
+//	bind                  list the key bindings
+//	bind -l               list the editing actions
+//	bind key action       make key run an editing action
+//	bind -s key text      make key type text
+//	bind -r key           remove the binding of key
+//	bind -w               save the key bindings in the rc file
+//
+// Keys are written \C-x or ^X for Ctrl-X, \ex or \M-x for Alt-X, and may
+// be preceded by \C-x, as in \C-x\C-g.
+func (s *Shell) runBind(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		io.WriteString(stdout, s.formatBindings())
+		return 0
+	}
+
+	flag := ""
+	if strings.HasPrefix(args[0], "-") {
+		flag, args = args[0], args[1:]
+	}
+	want := map[string]int{"": 2, "-s": 2, "-r": 1, "-l": 0, "-w": 0}
+	n, ok := want[flag]
+	if !ok || len(args) != n {
+		fmt.Fprintln(stderr, "bind: usage: bind [-lw] [key action] [-s key text] [-r key]")
+		return 2
+	}
+	switch flag {
+	case "-l":
+		for _, a := range editActions {
+			fmt.Fprintln(stdout, a.name)
+		}
+		return 0
+	case "-w":
+		if err := s.saveBindings(); err != nil {
+			fmt.Fprintf(stderr, "bind: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+
+	seq, err := parseKeySeq(args[0])
+	if err != nil {
+		fmt.Fprintf(stderr, "bind: %v\n", err)
+		return 1
+	}
+	var b keyBinding
+	switch flag {
+	case "-r":
+		delete(s.bindings, seq)
+		return 0
+	case "-s":
+		b.macro = parseMacro(args[1])
+	default:
+		if _, ok := actionKey(args[1]); !ok {
+			fmt.Fprintf(stderr, "bind: %s: unknown action\n", args[1])
+			return 1
+		}
+		b.action = args[1]
+	}
+	if s.bindings == nil {
+		s.bindings = make(map[keySeq]keyBinding)
+	}
+	s.bindings[seq] = b
+	return 0
+}
+
+// formatBindings lists the key bindings as the bind commands that make
+// them, sorted by key.
+func (s *Shell) formatBindings() string {
+	seqs := make([]keySeq, 0, len(s.bindings))
+	for seq := range s.bindings {
+		seqs = append(seqs, seq)
+	}
+	slices.SortFunc(seqs, func(a, b keySeq) int { return strings.Compare(formatKeySeq(a), formatKeySeq(b)) })
+	var out strings.Builder
+	for _, seq := range seqs {
+		b := s.bindings[seq]
+		if b.action != "" {
+			fmt.Fprintf(&out, "bind %s %s\n", shellQuote(formatKeySeq(seq)), b.action)
+		} else {
+			fmt.Fprintf(&out, "bind -s %s %s\n", shellQuote(formatKeySeq(seq)), shellQuote(formatMacro(b.macro)))
+		}
+	}
+	return out.String()
+}
+
+// saveBindings replaces the bind commands in the rc file with the current
+// key bindings, keeping its other lines, and creates the file if needed.
+// Lines where bind is part of a list are left alone.
+func (s *Shell) saveBindings() error {
+	path := rcFile()
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	var out strings.Builder
+	for _, line := range strings.SplitAfter(string(data), "\n") {
+		if words := splitWords(strings.TrimSuffix(line, "\n")); len(words) > 0 && words[0] == "bind" && !slices.ContainsFunc(words, isOperator) {
+			continue
+		}
+		out.WriteString(line)
+	}
+	if out.Len() > 0 && !strings.HasSuffix(out.String(), "\n") {
+		out.WriteString("\n")
+	}
+	out.WriteString(s.formatBindings())
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(out.String()), 0644)
+}
+
+// parseKeySeq parses the key notation of bind.
+func parseKeySeq(spec string) (keySeq, error) {
+	var keys []rune
+	for i := 0; i < len(spec); {
+		var r rune
+		var ok bool
+		switch rest := spec[i:]; {
+		case strings.HasPrefix(rest, `\C-`) && len(rest) > 3:
+			r, ok = ctrlKey(rest[3])
+			i += 4
+		case rest[0] == '^' && len(rest) > 1:
+			r, ok = ctrlKey(rest[1])
+			i += 2
+		case strings.HasPrefix(rest, `\M-`) && len(rest) > 3:
+			r, ok = altKey(rest[3])
+			i += 4
+		case strings.HasPrefix(rest, `\e`) && len(rest) > 2:
+			r, ok = altKey(rest[2])
+			i += 3
+		case rest[0] == '\\' && len(rest) > 1:
+			r, ok = rune(rest[1]), true
+			i += 2
+		default:
+			var n int
+			r, n = utf8.DecodeRuneInString(rest)
+			ok = r != utf8.RuneError
+			i += n
+		}
+		if !ok {
+			return keySeq{}, fmt.Errorf("%s: invalid key", spec)
+		}
+		keys = append(keys, r)
+	}
+	switch {
+	case len(keys) == 1:
+		return keySeq{keys[0]}, nil
+	case len(keys) == 2 && keys[0] == charCtrlX:
+		return keySeq{keys[0], keys[1]}, nil
+	}
+	return keySeq{}, fmt.Errorf("%s: only single keys and Ctrl-X sequences can be bound", spec)
+}
+
+// ctrlKey returns the key typed with Ctrl and c.
+func ctrlKey(c byte) (rune, bool) {
+	if c >= 'a' && c <= 'z' {
+		c -= 'a' - 'A'
+	}
+	switch {
+	case c == '?':
+		return readline.CharBackspace, true
+	case c >= '@' && c <= '_':
+		return rune(c - '@'), true
+	}
+	return 0, false
+}
+
+// altKey returns the key typed with Alt and c. Alt-[ and Alt-O start the
+// escape sequences of other keys and cannot be bound.
+func altKey(c byte) (rune, bool) {
+	switch c {
+	case 'b':
+		return readline.MetaBackward, true
+	case 'f':
+		return readline.MetaForward, true
+	case 'd':
+		return readline.MetaDelete, true
+	}
+	return metaBase + rune(c), isMetaChar(c)
+}
+
+// isMetaChar reports whether Escape followed by c is an Alt key that
+// keyReader passes on as a rune from metaBase.
+func isMetaChar(c byte) bool {
+	return c > ' ' && c < utf8.RuneSelf-1 && !strings.ContainsRune("bdfO[", rune(c))
+}
+
+// formatKeySeq writes seq in the key notation of bind.
+func formatKeySeq(seq keySeq) string {
+	var sb strings.Builder
+	for _, r := range seq {
+		switch {
+		case r == 0:
+		case r == readline.CharBackspace:
+			sb.WriteString(`\C-?`)
+		case r >= 0 && r < ' ':
+			sb.WriteString(`\C-` + strings.ToLower(string(r+'@')))
+		case r == readline.MetaBackward:
+			sb.WriteString(`\eb`)
+		case r == readline.MetaForward:
+			sb.WriteString(`\ef`)
+		case r == readline.MetaDelete:
+			sb.WriteString(`\ed`)
+		case r > metaBase && r < metaBase+utf8.RuneSelf:
+			sb.WriteString(`\e` + string(r-metaBase))
+		case r == '\\' || r == '^':
+			sb.WriteString(`\` + string(r))
+		default:
+			sb.WriteRune(r)
+		}
+	}
+	return sb.String()
+}
+
+// macroEscapes are the backslash escapes of bind -s text.
+var macroEscapes = map[byte]byte{'n': '\n', 't': '\t', '\\': '\\'}
+
+// parseMacro replaces the backslash escapes in the text of bind -s.
+func parseMacro(text string) string {
+	var sb strings.Builder
+	for i := 0; i < len(text); i++ {
+		if text[i] == '\\' && i+1 < len(text) {
+			if c, ok := macroEscapes[text[i+1]]; ok {
+				sb.WriteByte(c)
+				i++
+				continue
+			}
+		}
+		sb.WriteByte(text[i])
+	}
+	return sb.String()
+}
+
+// formatMacro writes the text of a macro with the escapes of bind -s.
+func formatMacro(text string) string {
+	var sb strings.Builder
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case '\n':
+			sb.WriteString(`\n`)
+		case '\t':
+			sb.WriteString(`\t`)
+		case '\\':
+			sb.WriteString(`\\`)
+		default:
+			sb.WriteByte(text[i])
+		}
+	}
+	return sb.String()
+}
+
+// bound returns the binding of the key r, read after Ctrl-X if ctrlX is set.
+func (e *editor) bound(r rune, ctrlX bool) (keyBinding, bool) {
+	seq := keySeq{r}
+	if ctrlX {
+		seq = keySeq{charCtrlX, r}
+	}
+	b, ok := e.s.bindings[seq]
+	return b, ok
+}
+
+// runBinding runs a bound key for filter.
+func (e *editor) runBinding(b keyBinding) (rune, bool) {
+	if b.action == "" {
+		return e.typeMacro(b.macro)
+	}
+	if b.action == "edit-command-line" {
+		e.editRequested = true
+		return readline.CharEnter, true
+	}
+	key, _ := actionKey(b.action)
+	return e.editKey(key)
+}
+
+// typeMacro inserts text at the cursor. A newline in text enters the line
+// with the text before it. Otherwise the edit is made by OnChange, which
+// readline calls after the Ctrl-G the key is replaced with, as that is the
+// only way to move the cursor to the end of the text; in vi command mode,
+// where Ctrl-G does not get that far, the cursor goes to the end of the
+// line.
+func (e *editor) typeMacro(text string) (rune, bool) {
+	text, _, enter := strings.Cut(text, "\n")
+	pos := min(e.pos, len(e.line))
+	line := slices.Concat(e.line[:pos], []rune(text), e.line[pos:])
+	pos += utf8.RuneCountInString(text)
+	if enter || e.viCommand {
+		e.line, e.pos = line, len(line)
+		if e.view != nil {
+			e.view.SetBuffer(string(line))
+		}
+		if enter {
+			return readline.CharEnter, true
+		}
+		return 0, false
+	}
+	e.pending = &pendingEdit{line, pos}
+	return readline.CharBell, true
+}
+
+// pendingEdit is a change of the line for OnChange to hand to readline.
+type pendingEdit struct {
+	line []rune
+	pos  int
+}
@@ -0,0 +1,170 @@
+package shell
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/chzyer/readline"
+)
+
+func TestParseKeySeq(t *testing.T) {
+	tests := []struct {
+		spec string
+		want keySeq
+	}{
+		{`\C-g`, keySeq{7}},
+		{`^G`, keySeq{7}},
+		{`\C-?`, keySeq{readline.CharBackspace}},
+		{`\C-x\C-g`, keySeq{charCtrlX, 7}},
+		{`^Xs`, keySeq{charCtrlX, 's'}},
+		{`\eg`, keySeq{metaBase + 'g'}},
+		{`\M-.`, keySeq{metaBase + '.'}},
+		{`\ef`, keySeq{readline.MetaForward}},
+		{`\\`, keySeq{'\\'}},
+		{`é`, keySeq{'é'}},
+	}
+	for _, tt := range tests {
+		got, err := parseKeySeq(tt.spec)
+		if err != nil || got != tt.want {
+			t.Errorf("parseKeySeq(%q) = %q, %v; want %q", tt.spec, got, err, tt.want)
+			continue
+		}
+		if back, _ := parseKeySeq(formatKeySeq(got)); back != got {
+			t.Errorf("formatKeySeq(%q) = %q, which parses as %q", got, formatKeySeq(got), back)
+		}
+	}
+	for _, spec := range []string{"", "ab", `\C-g\C-g`, `\e[`, `\C-1`, `\C-`} {
+		if got, err := parseKeySeq(spec); err == nil {
+			t.Errorf("parseKeySeq(%q) = %q, want an error", spec, got)
+		}
+	}
+}
+
+func TestRunBind(t *testing.T) {
+	s := &Shell{}
+	var out, errs strings.Builder
+	s.runBind([]string{`\C-g`, "history-search"}, &out, &errs)
+	s.runBind([]string{"-s", `\eg`, `git status\n`}, &out, &errs)
+	s.runBind([]string{"-s", `\C-x\C-q`, "it's"}, &out, &errs)
+	s.runBind(nil, &out, &errs)
+	want := "bind '\\C-g' history-search\n" +
+		"bind -s '\\C-x\\C-q' 'it'\\''s'\n" +
+		"bind -s '\\eg' 'git status\\n'\n"
+	if out.String() != want || errs.Len() > 0 {
+		t.Errorf("bind listed %q, %q; want %q", out.String(), errs.String(), want)
+	}
+	if b := s.bindings[keySeq{metaBase + 'g'}]; b.macro != "git status\n" {
+		t.Errorf("bind -s stored %q", b.macro)
+	}
+
+	if s.runBind([]string{"-r", `^G`}, &out, &errs) != 0 || len(s.bindings) != 2 {
+		t.Errorf("bind -r left %v", s.bindings)
+	}
+	out.Reset()
+	if s.runBind([]string{"-l"}, &out, &errs) != 0 || !strings.Contains(out.String(), "\nedit-command-line\n") {
+		t.Errorf("bind -l = %q", out.String())
+	}
+
+	tests := []struct {
+		args   []string
+		status int
+		msg    string
+	}{
+		{[]string{`\C-g`}, 2, "usage"},
+		{[]string{"-z", "a", "b"}, 2, "usage"},
+		{[]string{`\C-g`, "fly"}, 1, "fly: unknown action"},
+		{[]string{"ab", "yank"}, 1, "ab: only single keys"},
+	}
+	for _, tt := range tests {
+		errs.Reset()
+		if status := s.runBind(tt.args, &out, &errs); status != tt.status || !strings.Contains(errs.String(), tt.msg) {
+			t.Errorf("bind %q = %d, %q; want %d, %q", tt.args, status, errs.String(), tt.status, tt.msg)
+		}
+	}
+}
+
+func TestBindWrite(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	rc := rcFile()
+	os.MkdirAll(filepath.Dir(rc), 0755)
+	os.WriteFile(rc, []byte("set -o vi\nbind '\\C-g' yank\n# bind notes\nbind -s '\\C-t' x; true\n"), 0644)
+
+	s := &Shell{}
+	var out, errs strings.Builder
+	s.runBind([]string{`\C-g`, "history-search"}, &out, &errs)
+	s.runBind([]string{"-s", `\eg`, "git status"}, &out, &errs)
+	if status := s.runBind([]string{"-w"}, &out, &errs); status != 0 {
+		t.Fatalf("bind -w = %d, %q", status, errs.String())
+	}
+	data, _ := os.ReadFile(rc)
+	want := "set -o vi\n# bind notes\nbind -s '\\C-t' x; true\n" +
+		"bind '\\C-g' history-search\n" +
+		"bind -s '\\eg' 'git status'\n"
+	if string(data) != want {
+		t.Errorf("rc file =\n%s\nwant\n%s", data, want)
+	}
+
+	loaded := &Shell{}
+	loaded.loadRC()
+	for seq, b := range s.bindings {
+		if loaded.bindings[seq] != b {
+			t.Errorf("binding of %s loaded back as %+v, want %+v", formatKeySeq(seq), loaded.bindings[seq], b)
+		}
+	}
+}
+
+func TestMacroEscapes(t *testing.T) {
+	text := parseMacro(`a\tb\\n\n\q`)
+	if text != "a\tb\\n\n\\q" {
+		t.Errorf("parseMacro = %q", text)
+	}
+	if got := formatMacro(text); got != `a\tb\\n\n\\q` {
+		t.Errorf("formatMacro = %q", got)
+	}
+}
+
+func TestEditorBindings(t *testing.T) {
+	e, view := newTestEditor("make test")
+	e.s.runBind([]string{`\C-g`, "end-of-line"}, nil, nil)
+	e.s.runBind([]string{`\C-x\C-s`, "history-search"}, nil, nil)
+	e.s.runBind([]string{"-s", `\eg`, "git "}, nil, nil)
+	e.s.runBind([]string{"-s", `\es`, `git status\n`}, nil, nil)
+	e.s.runBind([]string{`\ee`, "edit-command-line"}, nil, nil)
+
+	if r, ok := e.filter(7); !ok || r != readline.CharLineEnd {
+		t.Errorf("bound Ctrl-G gave %q, %v; want End", r, ok)
+	}
+
+	e.OnChange([]rune("ls -l"), 0, readline.CharLineStart)
+	if r, ok := e.filter(metaBase + 'g'); !ok || r != readline.CharBell {
+		t.Fatalf("Alt-G gave %q, %v; want Ctrl-G for OnChange", r, ok)
+	}
+	line, pos, ok := e.OnChange([]rune("ls -l"), 0, readline.CharBell)
+	if !ok || string(line) != "git ls -l" || pos != 4 {
+		t.Errorf("OnChange after the macro = %q, %d, %v", string(line), pos, ok)
+	}
+	if _, _, ok := e.OnChange([]rune("git ls -l"), 5, 'x'); ok {
+		t.Error("the macro's edit was made twice")
+	}
+
+	e.OnChange(nil, 0, 0)
+	if r, ok := e.filter(metaBase + 's'); !ok || r != readline.CharEnter || view.buffer != "git status" {
+		t.Errorf("Alt-S gave %q, %v with buffer %q; want Enter after git status", r, ok, view.buffer)
+	}
+
+	e.filter(charCtrlX)
+	e.filter(readline.CharFwdSearch)
+	if e.search == nil {
+		t.Error("Ctrl-X Ctrl-S did not start the history search")
+	}
+	e.filter(readline.CharBell)
+
+	if r, ok := e.filter(metaBase + 'e'); !ok || r != readline.CharEnter || !e.takeEditRequest() {
+		t.Errorf("Alt-E gave %q, %v without an edit request", r, ok)
+	}
+	if _, ok := e.filter(metaBase + 'q'); ok {
+		t.Error("an unbound Alt key was passed to readline")
+	}
+}
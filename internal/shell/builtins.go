@@ -4,11 +4,32 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"slices"
 	"strconv"
 	"strings"
+	"syscall"
+	"time"
 )
 
-var builtinNames = []string{"echo", "exit", "type", "pwd", "cd", "history"}
+var builtinNames = []string{
+	"echo", "exit", "type", "pwd", "cd", "history",
+	"exec", "eval", "trap", "kill", "wait", "jobs", "umask", "export", "unset",
+	"set", "shopt", "times", "ulimit", "fc",
+	"complete", "compgen", "hook", "bind",
+}
+
+// keywordNames are reserved words that prefix a pipeline rather than name
+// a command.
+var keywordNames = []string{"time", "!"}
+
+func isKeyword(name string) bool {
+	for _, k := range keywordNames {
+		if k == name {
+			return true
+		}
+	}
+	return false
+}
 
 func isBuiltin(name string) bool {
 	for _, b := range builtinNames {
@@ -23,20 +44,25 @@ func runEcho(args []string, stdout io.Writer) {
 	fmt.Fprintln(stdout, strings.Join(args, " "))
 }
 
-func runType(args []string, stdout, stderr io.Writer) {
+func runType(args []string, stdout, stderr io.Writer) int {
 	if len(args) == 0 {
-		return
+		return 0
 	}
 	cmd := args[0]
+	if isKeyword(cmd) {
+		fmt.Fprintf(stdout, "%s is a shell keyword\n", cmd)
+		return 0
+	}
 	if isBuiltin(cmd) {
 		fmt.Fprintf(stdout, "%s is a shell builtin\n", cmd)
-		return
+		return 0
 	}
 	if path := findInPath(cmd); path != "" {
 		fmt.Fprintf(stdout, "%s is %s\n", cmd, path)
-	} else {
-		fmt.Fprintf(stderr, "%s: not found\n", cmd)
+		return 0
 	}
+	fmt.Fprintf(stderr, "%s: not found\n", cmd)
+	return 1
 }
 
 func runPwd(stdout io.Writer) {
@@ -45,80 +71,320 @@ func runPwd(stdout io.Writer) {
 	}
 }
 
-func runCd(args []string, stderr io.Writer) {
+// runCd changes the working directory, to $HOME by default, and runs the
+// chpwd hooks.
+func (s *Shell) runCd(args []string, stderr io.Writer) int {
 	dir := os.Getenv("HOME")
 	if len(args) > 0 && args[0] != "~" {
 		dir = args[0]
 	}
+	old, _ := os.Getwd()
 	if err := os.Chdir(dir); err != nil {
 		fmt.Fprintf(stderr, "cd: %s: No such file or directory\n", dir)
+		return 1
+	}
+	s.chpwd(old)
+	return 0
+}
+
+// runExit asks the REPL to stop after the current line. The status
+// defaults to that of the last command. With shopt checkjobs, exiting while
+// jobs are running needs a second exit in a row.
+func (s *Shell) runExit(args []string, stderr io.Writer) int {
+	if s.shopt("checkjobs") && !s.inSubshell && s.runningJobs() > 0 && !(s.jobsWarned > 0 && s.jobsWarned == s.lineNo-1) {
+		fmt.Fprintln(stderr, "There are running jobs.")
+		s.jobsWarned = s.lineNo
+		return 1
+	}
+	code := s.lastStatus
+	if len(args) > 0 {
+		n, err := strconv.Atoi(args[0])
+		if err != nil {
+			fmt.Fprintf(stderr, "exit: %s: numeric argument required\n", args[0])
+			n = 2
+		}
+		code = n & 0xff
+	}
+	s.exiting = true
+	s.exitCode = code
+	return code
+}
+
+// runExec replaces the shell process with the given command. Without a
+// command, the redirections given to exec are applied to the shell itself.
+// A command that is not found leaves the shell's streams alone.
+func (s *Shell) runExec(args []string, stdout, stderr io.Writer) int {
+	path := ""
+	if len(args) > 0 {
+		if path = findInPath(args[0]); path == "" {
+			fmt.Fprintf(stderr, "exec: %s: not found\n", args[0])
+			return 127
+		}
+	}
+	if err := redirectShell(stdout, stderr); err != nil {
+		fmt.Fprintf(stderr, "exec: %v\n", err)
+		return 1
+	}
+	if len(args) == 0 {
+		return 0
+	}
+	// The redirection files were moved onto the shell's own descriptors.
+	stderr = os.Stderr
+	s.saveHistory()
+	err := syscall.Exec(path, args, os.Environ())
+	fmt.Fprintf(stderr, "exec: %s: %v\n", args[0], err)
+	return 126
+}
+
+// redirectShell points the shell's own stdout and stderr at the files
+// opened for a command's redirections, then closes those files. With 2>&1
+// both are the same file, which is closed once.
+func redirectShell(stdout, stderr io.Writer) error {
+	var files []*os.File
+	for fd, w := range []io.Writer{1: stdout, 2: stderr} {
+		f, ok := w.(*os.File)
+		if !ok || f == os.Stdout || f == os.Stderr {
+			continue
+		}
+		if err := dup2(int(f.Fd()), fd); err != nil {
+			return err
+		}
+		if !slices.Contains(files, f) {
+			files = append(files, f)
+		}
+	}
+	for _, f := range files {
+		f.Close()
+	}
+	return nil
+}
+
+// runEval joins its arguments and runs the result as a command line, with
+// eval's own streams.
+func (s *Shell) runEval(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		return 0
+	}
+	return s.execList(strings.Join(args, " "), stdin, stdout, stderr)
+}
+
+// runUmask prints or sets the file mode creation mask, in octal or, with
+// -S, in symbolic form.
+func runUmask(args []string, stdout, stderr io.Writer) int {
+	symbolic := false
+	if len(args) > 0 && args[0] == "-S" {
+		symbolic = true
+		args = args[1:]
+	}
+
+	mask := syscall.Umask(0)
+	syscall.Umask(mask)
+	if len(args) == 0 {
+		if symbolic {
+			fmt.Fprintln(stdout, symbolicMode(^mask&0777))
+		} else {
+			fmt.Fprintf(stdout, "%04o\n", mask)
+		}
+		return 0
+	}
+
+	newMask, err := parseUmask(args[0], mask)
+	if err != nil {
+		fmt.Fprintf(stderr, "umask: %s: invalid mode\n", args[0])
+		return 1
+	}
+	syscall.Umask(newMask)
+	return 0
+}
+
+// symbolicMode formats permission bits as "u=rwx,g=rx,o=rx".
+func symbolicMode(perm int) string {
+	var parts []string
+	for i, who := range []string{"u", "g", "o"} {
+		bits := perm >> (6 - 3*i) & 7
+		var sb strings.Builder
+		sb.WriteString(who + "=")
+		for j, c := range "rwx" {
+			if bits&(4>>j) != 0 {
+				sb.WriteRune(c)
+			}
+		}
+		parts = append(parts, sb.String())
+	}
+	return strings.Join(parts, ",")
+}
+
+// parseUmask parses an octal mask or a comma-separated list of symbolic
+// clauses such as "u=rwx,g-w,o=" applied to the current mask.
+func parseUmask(spec string, mask int) (int, error) {
+	if n, err := strconv.ParseUint(spec, 8, 32); err == nil {
+		if n > 0777 {
+			return 0, fmt.Errorf("out of range")
+		}
+		return int(n), nil
+	}
+
+	perm := ^mask & 0777
+	for _, clause := range strings.Split(spec, ",") {
+		i := strings.IndexAny(clause, "=+-")
+		if i < 0 {
+			return 0, fmt.Errorf("missing operator")
+		}
+		who := 0
+		for _, c := range clause[:i] {
+			switch c {
+			case 'u':
+				who |= 0700
+			case 'g':
+				who |= 0070
+			case 'o':
+				who |= 0007
+			case 'a':
+				who |= 0777
+			default:
+				return 0, fmt.Errorf("invalid who")
+			}
+		}
+		if who == 0 {
+			who = 0777
+		}
+		bits := 0
+		for _, c := range clause[i+1:] {
+			switch c {
+			case 'r':
+				bits |= 0444
+			case 'w':
+				bits |= 0222
+			case 'x':
+				bits |= 0111
+			default:
+				return 0, fmt.Errorf("invalid permission")
+			}
+		}
+		bits &= who
+		switch clause[i] {
+		case '=':
+			perm = perm&^who | bits
+		case '+':
+			perm |= bits
+		case '-':
+			perm &^= bits
+		}
 	}
+	return ^perm & 0777, nil
 }
 
-func (s *Shell) runHistory(args []string, stdout io.Writer) {
+// runHistory implements the history builtin: listing, optionally filtered
+// with --since, --cwd and --failed or as JSON with --json, -c to clear, -d
+// to delete entries, -s to add one, -r, -w and -a for history files, and
+// --scrub to redact secrets in existing files.
+func (s *Shell) runHistory(args []string, stdout io.Writer) int {
+	if len(args) > 0 {
+		switch args[0] {
+		case "-c":
+			s.clearHistory()
+			return 0
+		case "-d":
+			if len(args) < 2 {
+				fmt.Fprintln(os.Stderr, "history: -d: option requires an argument")
+				return 2
+			}
+			from, to, err := s.parseHistoryRange(args[1])
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "history: %v\n", err)
+				return 1
+			}
+			s.deleteHistory(from, to)
+			return 0
+		case "--scrub":
+			if len(args) < 2 {
+				fmt.Fprintln(os.Stderr, "history: --scrub: option requires an argument")
+				return 2
+			}
+			status := 0
+			for _, path := range args[1:] {
+				if err := s.scrubHistoryFile(path); err != nil {
+					fmt.Fprintf(os.Stderr, "history: %s: %v\n", path, err)
+					status = 1
+				}
+			}
+			return status
+		case "-s":
+			// Like bash, replace the history -s command itself.
+			if s.lineNo > 0 && len(s.history) > 0 {
+				s.deleteHistory(len(s.history)-1, len(s.history))
+			}
+			if len(args) > 1 {
+				s.addHistory(strings.Join(args[1:], " "))
+			}
+			return 0
+		}
+	}
 	if len(args) >= 2 {
 		switch args[0] {
 		case "-r":
 			s.historyRead(args[1])
-			return
+			return 0
 		case "-w":
 			s.historyWrite(args[1])
-			return
+			return 0
 		case "-a":
 			s.historyAppend(args[1])
-			return
+			return 0
 		}
 	}
 
-	history := s.history
-	if len(args) > 0 {
-		n, err := strconv.Atoi(args[0])
-		if err == nil && n > 0 && n < len(history) {
-			history = history[len(history)-n:]
-		}
+	q, args, err := parseHistoryQuery(args, time.Now())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "history: %v\n", err)
+		return 2
 	}
-
-	offset := len(s.history) - len(history) + 1
-	for i, cmd := range history {
-		fmt.Fprintf(stdout, "    %d  %s\n", offset+i, cmd)
+	n := 0
+	if len(args) > 0 {
+		n, _ = strconv.Atoi(args[0])
 	}
+	s.listHistory(stdout, q, n)
+	return 0
 }
 
+// historyRead appends the entries of the history file at path to the
+// history list.
 func (s *Shell) historyRead(path string) {
-	data, err := os.ReadFile(path)
+	data, _, err := readLockedHistory(path)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "history: %s: %v\n", path, err)
 		return
 	}
-	for _, line := range strings.Split(strings.TrimRight(string(data), "\n"), "\n") {
-		line = strings.TrimSpace(line)
-		if line != "" {
-			s.history = append(s.history, line)
-		}
-	}
-	s.historyOffset = len(s.history)
+	s.readHistory(data)
 }
 
+// historyWrite replaces the history file at path with the history list.
 func (s *Shell) historyWrite(path string) {
-	var sb strings.Builder
-	for _, cmd := range s.history {
-		sb.WriteString(cmd)
-		sb.WriteByte('\n')
+	f, err := openLockedHistory(path, syscall.LOCK_EX)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "history: %s: %v\n", path, err)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err == nil {
+		data := s.formatHistory(s.fileHistoryStart(), len(s.history))
+		err = rewriteHistoryFile(path, data, info.Mode())
 	}
-	if err := os.WriteFile(path, []byte(sb.String()), 0644); err != nil {
+	if err != nil {
 		fmt.Fprintf(os.Stderr, "history: %s: %v\n", path, err)
 	}
 }
 
+// historyAppend appends the entries not yet saved to the history file at
+// path.
 func (s *Shell) historyAppend(path string) {
-	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	f, err := openLockedHistory(path, syscall.LOCK_EX)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "history: %s: %v\n", path, err)
 		return
 	}
 	defer f.Close()
-	for _, cmd := range s.history[s.historyOffset:] {
-		fmt.Fprintln(f, cmd)
-	}
+	f.WriteString(s.formatHistory(s.historyOffset, len(s.history)))
 	s.historyOffset = len(s.history)
 }
@@ -2,9 +2,12 @@ package shell
 
 import (
 	"bytes"
+	"fmt"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"strings"
+	"syscall"
 	"testing"
 )
 
@@ -89,7 +92,7 @@ func TestRunCd(t *testing.T) {
 		// Resolve symlinks (macOS /var -> /private/var)
 		dir, _ = filepath.EvalSymlinks(dir)
 		var stderr bytes.Buffer
-		runCd([]string{dir}, &stderr)
+		(&Shell{}).runCd([]string{dir}, &stderr)
 		if stderr.String() != "" {
 			t.Errorf("unexpected stderr: %s", stderr.String())
 		}
@@ -102,7 +105,7 @@ func TestRunCd(t *testing.T) {
 
 	t.Run("nonexistent dir", func(t *testing.T) {
 		var stderr bytes.Buffer
-		runCd([]string{"/nonexistent_dir_xyz"}, &stderr)
+		(&Shell{}).runCd([]string{"/nonexistent_dir_xyz"}, &stderr)
 		if !strings.Contains(stderr.String(), "No such file or directory") {
 			t.Errorf("got stderr %q, want to contain 'No such file or directory'", stderr.String())
 		}
@@ -114,7 +117,7 @@ func TestRunCd(t *testing.T) {
 			t.Skip("HOME not set")
 		}
 		var stderr bytes.Buffer
-		runCd([]string{"~"}, &stderr)
+		(&Shell{}).runCd([]string{"~"}, &stderr)
 		wd, _ := os.Getwd()
 		if wd != home {
 			t.Errorf("cwd = %q, want %q", wd, home)
@@ -127,7 +130,7 @@ func TestRunCd(t *testing.T) {
 			t.Skip("HOME not set")
 		}
 		var stderr bytes.Buffer
-		runCd(nil, &stderr)
+		(&Shell{}).runCd(nil, &stderr)
 		wd, _ := os.Getwd()
 		if wd != home {
 			t.Errorf("cwd = %q, want %q", wd, home)
@@ -197,3 +200,114 @@ func TestRunHistory(t *testing.T) {
 		}
 	})
 }
+
+func TestRunEval(t *testing.T) {
+	t.Setenv("GOSH_CMD", "exit 4")
+	s := &Shell{}
+	if got := s.runEval([]string{"$GOSH_CMD"}, os.Stdin, os.Stdout, os.Stderr); got != 4 {
+		t.Errorf("eval status = %d, want 4", got)
+	}
+	if !s.exiting {
+		t.Error("eval did not run the re-parsed exit")
+	}
+}
+
+func TestRunEvalStreams(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		want string
+	}{
+		{"redirected", "eval echo hi > %s", "hi"},
+		{"left of a pipe", "eval echo piped | wc -l > %s", "1"},
+		{"right of a pipe", "echo hi | eval cat > %s", "hi"},
+		{"redirection inside", "eval 'echo inner > %s'", "inner"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := filepath.Join(t.TempDir(), "out")
+			s := &Shell{}
+			if status := s.execLine(fmt.Sprintf(tt.line, out)); status != 0 {
+				t.Fatalf("status = %d", status)
+			}
+			data, _ := os.ReadFile(out)
+			if got := strings.TrimSpace(string(data)); got != tt.want {
+				t.Errorf("file = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunExecNotFound(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	s := &Shell{}
+	if got := s.runExec([]string{"gosh-no-such-command"}, &stdout, &stderr); got != 127 {
+		t.Errorf("exec status = %d, want 127", got)
+	}
+	if !strings.Contains(stderr.String(), "gosh-no-such-command: not found") {
+		t.Errorf("stderr = %q, want the not found error", stderr.String())
+	}
+}
+
+func TestRunExecRedirectsShell(t *testing.T) {
+	// exec without a command redirects the whole process, so it runs in a
+	// child copy of the test binary, whose output ends up in the file.
+	out := os.Getenv("GOSH_TEST_EXEC_OUT")
+	if out == "" {
+		out = filepath.Join(t.TempDir(), "out")
+		cmd := exec.Command(os.Args[0], "-test.run=^TestRunExecRedirectsShell$")
+		cmd.Env = append(os.Environ(), "GOSH_TEST_EXEC_OUT="+out)
+		err := cmd.Run()
+		data, _ := os.ReadFile(out)
+		if err != nil {
+			t.Fatalf("child test: %v\n%s", err, data)
+		}
+		if !strings.Contains(string(data), "to stdout\nto stderr\n") {
+			t.Errorf("file = %q, want both streams", data)
+		}
+		return
+	}
+
+	s := &Shell{}
+	if status := s.execLine("exec > " + out + " 2>&1"); status != 0 {
+		t.Fatalf("exec status = %d", status)
+	}
+	if _, err := fmt.Fprintln(os.Stdout, "to stdout"); err != nil {
+		t.Errorf("write to stdout: %v", err)
+	}
+	if _, err := fmt.Fprintln(os.Stderr, "to stderr"); err != nil {
+		t.Errorf("write to stderr: %v", err)
+	}
+}
+
+func TestRunUmask(t *testing.T) {
+	orig := syscall.Umask(022)
+	defer syscall.Umask(orig)
+
+	var stdout, stderr bytes.Buffer
+	runUmask(nil, &stdout, &stderr)
+	runUmask([]string{"-S"}, &stdout, &stderr)
+	if got, want := stdout.String(), "0022\nu=rwx,g=rx,o=rx\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	tests := []struct {
+		spec string
+		want int
+	}{
+		{"077", 077},
+		{"u=rwx,g=rx,o=", 027},
+		{"g+w", 002},
+		{"o-r", 026},
+		{"a=r", 0333},
+	}
+	for _, tt := range tests {
+		got, err := parseUmask(tt.spec, 022)
+		if err != nil || got != tt.want {
+			t.Errorf("parseUmask(%q) = %04o, %v, want %04o", tt.spec, got, err, tt.want)
+		}
+	}
+	if status := runUmask([]string{"u=z"}, &stdout, &stderr); status != 1 {
+		t.Errorf("invalid mode returned %d, want 1", status)
+	}
+}
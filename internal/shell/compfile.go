@@ -0,0 +1,236 @@
+package shell
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// commandSpec describes the arguments of a command, or of one of its
+// subcommands, in a completion spec file.
+type commandSpec struct {
+	Description string                  `json:"description" yaml:"description"`
+	Subcommands map[string]*commandSpec `json:"subcommands" yaml:"subcommands"`
+	Flags       []*flagSpec             `json:"flags" yaml:"flags"`
+	Args        *argSpec                `json:"args" yaml:"args"`
+}
+
+// flagSpec describes a flag and, if it takes one, its argument.
+type flagSpec struct {
+	Names       []string `json:"names" yaml:"names"`
+	Description string   `json:"description" yaml:"description"`
+	Arg         *argSpec `json:"arg" yaml:"arg"`
+}
+
+// argSpec says where the values of an argument come from: a fixed list,
+// the lines a command prints, files or directories.
+type argSpec struct {
+	Values      []string `json:"values" yaml:"values"`
+	Command     string   `json:"command" yaml:"command"`
+	Files       bool     `json:"files" yaml:"files"`
+	Directories bool     `json:"directories" yaml:"directories"`
+}
+
+// specFile is a loaded spec file. spec is nil if the file is invalid.
+type specFile struct {
+	path    string
+	modTime time.Time
+	spec    *commandSpec
+}
+
+// specFileExts are the extensions of spec files, in the order they are
+// looked for.
+var specFileExts = []string{".yaml", ".yml", ".json"}
+
+// configDir returns the directory of gosh's configuration files:
+// $XDG_CONFIG_HOME/gosh, or ~/.config/gosh.
+func configDir() string {
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		return filepath.Join(dir, "gosh")
+	}
+	return filepath.Join(os.Getenv("HOME"), ".config", "gosh")
+}
+
+// commandSpecFor returns the spec in the completions directory for the
+// command named by the raw word cmd, or nil. Spec files are read the first
+// time their command is completed, and again whenever they change.
+func (s *Shell) commandSpecFor(cmd string) *commandSpec {
+	name := filepath.Base(strings.Join(expandWord(cmd, nil), ""))
+	if name == "." || name == "/" || strings.HasPrefix(name, ".") {
+		return nil
+	}
+	dir := filepath.Join(configDir(), "completions")
+	for _, ext := range specFileExts {
+		path := filepath.Join(dir, name+ext)
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if f := s.specFiles[name]; f != nil && f.path == path && f.modTime.Equal(info.ModTime()) {
+			return f.spec
+		}
+		spec, err := readCommandSpec(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "gosh: %s: %v\n", path, err)
+		}
+		if s.specFiles == nil {
+			s.specFiles = make(map[string]*specFile)
+		}
+		s.specFiles[name] = &specFile{path: path, modTime: info.ModTime(), spec: spec}
+		return spec
+	}
+	return nil
+}
+
+// readCommandSpec parses a spec file as JSON or YAML, by its extension.
+// Unknown fields are errors, so that typos do not go unnoticed.
+func readCommandSpec(path string) (*commandSpec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var spec commandSpec
+	if filepath.Ext(path) == ".json" {
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(&spec)
+	} else {
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		err = dec.Decode(&spec)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &spec, nil
+}
+
+// flag returns the flag of cmd with the given name, or nil.
+func (cmd *commandSpec) flag(name string) *flagSpec {
+	for _, f := range cmd.Flags {
+		for _, n := range f.Names {
+			if n == name {
+				return f
+			}
+		}
+	}
+	return nil
+}
+
+// commandSpecCompletions completes the word described by ctx from the spec
+// file of its command. It follows the words before it through subcommands
+// and flags to find what the word is: a flag, a flag's argument, a
+// subcommand or a positional argument. ok is false if the spec does not
+// describe the word.
+func (s *Shell) commandSpecCompletions(spec *commandSpec, ctx completionContext) (matches []completion, ok bool) {
+	cmd := spec
+	var pending *flagSpec // the flag whose argument is the next word
+	positional := false
+	for _, raw := range ctx.args[1:] {
+		w := strings.Join(expandWord(raw, nil), "")
+		switch {
+		case pending != nil:
+			pending = nil
+		case len(w) > 1 && w[0] == '-':
+			name, _, hasValue := strings.Cut(w, "=")
+			if f := cmd.flag(name); f != nil && f.Arg != nil && !hasValue {
+				pending = f
+			}
+		case !positional && cmd.Subcommands[w] != nil:
+			cmd = cmd.Subcommands[w]
+		default:
+			positional = true
+		}
+	}
+
+	switch {
+	case pending != nil:
+		return s.argCompletions(pending.Arg, ctx, ""), true
+	case strings.HasPrefix(ctx.value, "-"):
+		name, _, hasValue := strings.Cut(ctx.value, "=")
+		if !hasValue {
+			return cmd.flagCompletions(ctx), true
+		}
+		if f := cmd.flag(name); f != nil && f.Arg != nil {
+			return s.argCompletions(f.Arg, ctx, name+"="), true
+		}
+		return nil, false
+	}
+	if !positional {
+		for name, sub := range cmd.Subcommands {
+			if strings.HasPrefix(name, ctx.value) {
+				matches = append(matches, describedCompletion(name, sub.Description, ctx))
+			}
+		}
+	}
+	if cmd.Args != nil {
+		matches = append(matches, s.argCompletions(cmd.Args, ctx, "")...)
+	}
+	return matches, cmd.Args != nil || !positional && len(cmd.Subcommands) > 0
+}
+
+// flagCompletions matches the names of cmd's flags.
+func (cmd *commandSpec) flagCompletions(ctx completionContext) []completion {
+	var matches []completion
+	for _, f := range cmd.Flags {
+		for _, name := range f.Names {
+			if strings.HasPrefix(name, ctx.value) {
+				matches = append(matches, describedCompletion(name, f.Description, ctx))
+			}
+		}
+	}
+	return matches
+}
+
+// argCompletions matches the values of an argument. head is a prefix of
+// the word that is not part of the value, such as "--output=".
+func (s *Shell) argCompletions(arg *argSpec, ctx completionContext, head string) []completion {
+	if !strings.HasPrefix(ctx.raw, head) {
+		return nil
+	}
+	sub := ctx
+	sub.raw, sub.value = ctx.raw[len(head):], ctx.value[len(head):]
+	sub.quoteAt -= len(head)
+	sub.slashAt = max(0, ctx.slashAt-len(head))
+
+	var matches []completion
+	switch {
+	case arg.Directories:
+		matches = completeFiles(sub, fs.FileInfo.IsDir)
+	case arg.Files:
+		matches = completeFiles(sub, nil)
+	}
+	values := arg.Values
+	if arg.Command != "" {
+		if parts, err := s.expandWords(splitWords(arg.Command)); err == nil && len(parts) > 0 {
+			values = append(values[:len(values):len(values)], s.commandOutput(parts)...)
+		}
+	}
+	for _, v := range values {
+		if strings.HasPrefix(v, sub.value) {
+			matches = append(matches, describedCompletion(v, "", sub))
+		}
+	}
+	for i := range matches {
+		matches[i].word = head + matches[i].word
+		matches[i].value = head + matches[i].value
+	}
+	return matches
+}
+
+// describedCompletion is a final candidate for the whole word, quoted for
+// the word as typed, and shown with its description in the menu.
+func describedCompletion(v, description string, ctx completionContext) completion {
+	word := quoteCompletion(v, ctx.quote, true)
+	if ctx.quote != 0 && ctx.quoteAt == 0 {
+		word = string(ctx.quote) + word
+	}
+	return completion{word: word, value: v, display: v, description: description, final: true}
+}
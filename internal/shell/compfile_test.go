@@ -0,0 +1,147 @@
+package shell
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+const deploySpec = `
+description: Deploy services
+flags:
+  - names: [--verbose, -v]
+    description: Print more
+  - names: [--env, -e]
+    description: Target environment
+    arg:
+      values: [staging, production]
+  - names: [--config]
+    arg:
+      files: true
+subcommands:
+  rollout:
+    description: Roll out a release
+    args:
+      command: printf 'api\nweb\nworker\n'
+    flags:
+      - names: [--wait]
+  status:
+    description: Show status
+`
+
+// writeSpecFile writes a spec file to the completions directory under a
+// temporary XDG_CONFIG_HOME.
+func writeSpecFile(t *testing.T, name, data string) string {
+	t.Helper()
+	dir := filepath.Join(t.TempDir(), "config")
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	path := filepath.Join(dir, "gosh", "completions", name)
+	os.MkdirAll(filepath.Dir(path), 0755)
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadCommandSpec(t *testing.T) {
+	completionDir(t)
+	path := writeSpecFile(t, "deploy.yaml", deploySpec)
+	spec, err := readCommandSpec(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f := spec.flag("-e"); f == nil || !slices.Equal(f.Arg.Values, []string{"staging", "production"}) {
+		t.Errorf("flag -e = %+v", f)
+	}
+	if sub := spec.Subcommands["rollout"]; sub == nil || sub.Args == nil || sub.Args.Command == "" {
+		t.Errorf("rollout = %+v", sub)
+	}
+
+	json := filepath.Join(filepath.Dir(path), "tool.json")
+	os.WriteFile(json, []byte(`{"flags": [{"names": ["--all"]}], "args": {"directories": true}}`), 0644)
+	if spec, err := readCommandSpec(json); err != nil || spec.flag("--all") == nil || !spec.Args.Directories {
+		t.Errorf("readCommandSpec(%s) = %+v, %v", json, spec, err)
+	}
+
+	for name, data := range map[string]string{
+		"bad.yaml": "flags:\n  - nmes: [--x]\n",
+		"bad.json": `{"flag": []}`,
+		"syn.yaml": "flags: [",
+	} {
+		bad := filepath.Join(filepath.Dir(path), name)
+		os.WriteFile(bad, []byte(data), 0644)
+		if _, err := readCommandSpec(bad); err == nil {
+			t.Errorf("readCommandSpec(%s) succeeded", name)
+		}
+	}
+}
+
+func TestCommandSpecFor(t *testing.T) {
+	path := writeSpecFile(t, "deploy.yaml", deploySpec)
+	s := &Shell{}
+	spec := s.commandSpecFor("/usr/local/bin/deploy")
+	if spec == nil || spec.Description != "Deploy services" {
+		t.Fatalf("commandSpecFor = %+v", spec)
+	}
+	if s.commandSpecFor("deploy") != spec {
+		t.Errorf("an unchanged spec file was read again")
+	}
+	if s.commandSpecFor("other") != nil || s.commandSpecFor("..") != nil {
+		t.Errorf("found a spec for a command without a file")
+	}
+
+	os.WriteFile(path, []byte("description: Changed\n"), 0644)
+	later := time.Now().Add(time.Second)
+	os.Chtimes(path, later, later)
+	if spec := s.commandSpecFor("deploy"); spec == nil || spec.Description != "Changed" {
+		t.Errorf("commandSpecFor after a change = %+v", spec)
+	}
+}
+
+func TestCompleteFromSpecFile(t *testing.T) {
+	completionDir(t)
+	writeSpecFile(t, "deploy.yaml", deploySpec)
+	c := &completer{s: &Shell{}}
+
+	tests := []struct{ input, want string }{
+		{"deploy ro", "llout "},
+		{"deploy --ver", "bose "},
+		{"deploy --env pro", "duction "},
+		{"deploy -e st", "aging "},
+		{"deploy --env=pro", "duction "},
+		{"deploy --config al", "pha.txt "},
+		{"deploy --config=al", "pha.txt "},
+		{"deploy -v rollout w", ""},
+		{"deploy -v rollout wo", "rker "},
+		{"deploy rollout --w", "ait "},
+		{"deploy status al", "pha.txt "},
+		{"deploy rollout api al", ""},
+		{"deploy > al", "pha.txt "},
+	}
+	for _, tt := range tests {
+		if got := completeOnce(c, tt.input); got != tt.want {
+			t.Errorf("completing %q inserted %q, want %q", tt.input, got, tt.want)
+		}
+	}
+
+	var displays []string
+	for _, m := range c.complete(parseCompletion("deploy ")) {
+		displays = append(displays, m.display+" -- "+m.description)
+	}
+	if want := []string{"rollout -- Roll out a release", "status -- Show status"}; !slices.Equal(displays, want) {
+		t.Errorf("subcommands listed as %q, want %q", displays, want)
+	}
+}
+
+func TestCompleteSpecPrecedence(t *testing.T) {
+	completionDir(t)
+	writeSpecFile(t, "deploy.yaml", deploySpec)
+	s := &Shell{}
+	s.runComplete([]string{"-W", "release", "deploy"}, &strings.Builder{}, &strings.Builder{})
+	if got := completeOnce(&completer{s: s}, "deploy r"); got != "elease " {
+		t.Errorf("completing with both a complete spec and a spec file inserted %q, want the complete spec", got)
+	}
+}
@@ -2,26 +2,62 @@ package shell
 
 import (
 	"fmt"
+	"io/fs"
 	"os"
+	"path/filepath"
 	"sort"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/chzyer/readline"
 )
 
 type completer struct {
+	s         *Shell
 	lastInput string
 	tabCount  int
 }
 
-func newCompleter() readline.AutoCompleter {
-	return &completer{}
+func newCompleter(s *Shell) readline.AutoCompleter {
+	return &completer{s: s}
+}
+
+// completionKind says what the word being completed names.
+type completionKind int
+
+const (
+	completeCommand completionKind = iota
+	completeFile
+	completeDirectory
+	completeVariable
+	completeJob
+)
+
+// completionContext describes the word under the cursor.
+type completionContext struct {
+	kind     completionKind
+	line     string   // the input up to the cursor
+	raw      string   // the word as typed, up to the cursor
+	value    string   // raw without quotes and escapes
+	quote    byte     // the quote still open at the cursor, or 0
+	quoteAt  int      // index in raw of the open quote
+	slashAt  int      // index in raw just after the last '/', or 0
+	args     []string // the words of the command before this one
+	redirect bool     // the word is the target of a redirection
+}
+
+// completion is one candidate for the word under the cursor.
+type completion struct {
+	word        string // replaces the whole word; quoted, but without a closing quote
+	value       string // the word unquoted, as compgen prints it
+	display     string // shown when candidates are listed
+	description string // shown next to display in the menu
+	final       bool   // a unique match ends the word: close the quote and add a space
 }
 
 func (c *completer) Do(line []rune, pos int) ([][]rune, int) {
 	input := string(line[:pos])
-
-	if strings.ContainsAny(input, " \t") || input == "" {
+	if strings.TrimSpace(input) == "" {
 		return nil, 0
 	}
 
@@ -31,39 +67,50 @@ func (c *completer) Do(line []rune, pos int) ([][]rune, int) {
 	}
 	c.tabCount++
 
-	seen := map[string]bool{}
-	var matches []string
-
-	for _, b := range builtinNames {
-		if strings.HasPrefix(b, input) && !seen[b] {
-			matches = append(matches, b)
-			seen[b] = true
+	ctx := parseCompletion(input)
+	var matches []completion
+	if ed := c.editor(); ed != nil {
+		if m := ed.takeAccepted(); m != nil {
+			matches = []completion{*m}
 		}
 	}
-	for _, name := range executablesInPath(input) {
-		if !seen[name] {
-			matches = append(matches, name)
-			seen[name] = true
-		}
+	if matches == nil {
+		matches = c.complete(ctx)
 	}
+	offset := utf8.RuneCountInString(ctx.raw)
 
 	if len(matches) == 0 {
 		fmt.Fprint(os.Stderr, "\x07")
 		return nil, 0
 	}
 
-	sort.Strings(matches)
-
 	if len(matches) == 1 {
 		c.tabCount = 0
-		return [][]rune{[]rune(matches[0][len(input):] + " ")}, len(input)
+		text := matches[0].word
+		if matches[0].final {
+			if ctx.quote != 0 {
+				text += string(ctx.quote)
+			}
+			text += " "
+		}
+		if !strings.HasPrefix(text, ctx.raw) {
+			return nil, 0
+		}
+		return [][]rune{[]rune(text[len(ctx.raw):])}, offset
 	}
 
-	lcp := longestCommonPrefix(matches)
-	if len(lcp) > len(input) {
-		c.lastInput = lcp
+	words := make([]string, len(matches))
+	for i, m := range matches {
+		words[i] = m.word
+	}
+	lcp := longestCommonPrefix(words)
+	for !utf8.ValidString(lcp) {
+		lcp = lcp[:len(lcp)-1]
+	}
+	if len(lcp) > len(ctx.raw) && strings.HasPrefix(lcp, ctx.raw) {
+		c.lastInput = input + lcp[len(ctx.raw):]
 		c.tabCount = 0
-		return [][]rune{[]rune(lcp[len(input):])}, len(input)
+		return [][]rune{[]rune(lcp[len(ctx.raw):])}, offset
 	}
 
 	if c.tabCount == 1 {
@@ -71,11 +118,302 @@ func (c *completer) Do(line []rune, pos int) ([][]rune, int) {
 		return nil, 0
 	}
 
-	fmt.Fprintf(os.Stdout, "\n%s\n$ %s", strings.Join(matches, "  "), input)
 	c.tabCount = 0
+	if ed := c.editor(); ed != nil {
+		ed.openMenu(matches)
+	}
 	return nil, 0
 }
 
+// editor returns the line editor the completer shows its menu in, or nil.
+func (c *completer) editor() *editor {
+	if c.s == nil {
+		return nil
+	}
+	return c.s.editor
+}
+
+// parseCompletion finds the word that ends at the end of input and works out
+// from the words before it what kind of word it is.
+func parseCompletion(input string) completionContext {
+	ctx := completionContext{line: input}
+	start := 0
+	for i := 0; i < len(input); i++ {
+		c := input[i]
+		switch {
+		case ctx.quote == '\'':
+			if c == '\'' {
+				ctx.quote = 0
+			}
+		case ctx.quote == '"':
+			if c == '"' {
+				ctx.quote = 0
+			} else if c == '\\' {
+				i++
+			}
+		case c == '\'' || c == '"':
+			ctx.quote, ctx.quoteAt = c, i
+		case c == '\\':
+			i++
+		case c == ' ' || c == '\t' || c == ';' || c == '|' || c == '&' && !strings.HasSuffix(input[:i], ">"):
+			start = i + 1
+		}
+	}
+	start = min(start, len(input))
+	ctx.raw = input[start:]
+	ctx.quoteAt -= start
+	ctx.slashAt = strings.LastIndexByte(ctx.raw, '/') + 1
+	closed := ctx.raw
+	if ctx.quote != 0 {
+		closed += string(ctx.quote)
+	}
+	ctx.value = strings.Join(expandWord(closed, nil), "")
+
+	afterRedirect := false
+	prev := ""
+	for _, w := range splitWords(input[:start]) {
+		switch {
+		case w == ";" || w == "&" || w == "&&" || w == "|" || w == "||":
+			ctx.args = nil
+			afterRedirect = false
+		case isRedirectOp(w):
+			afterRedirect = true
+		case afterRedirect:
+			afterRedirect = false
+		case len(ctx.args) == 0 && (isKeyword(w) || isAssignment(w) || w == "-p" && prev == "time"):
+			// Not the command name yet.
+		default:
+			ctx.args = append(ctx.args, w)
+		}
+		prev = w
+	}
+	ctx.redirect = afterRedirect
+
+	switch {
+	case ctx.isVariable():
+		ctx.kind = completeVariable
+	case afterRedirect:
+		ctx.kind = completeFile
+	case len(ctx.args) == 0:
+		ctx.kind = completeCommand
+	case strings.HasPrefix(ctx.raw, "%"):
+		ctx.kind = completeJob
+	case ctx.args[0] == "cd" || ctx.args[0] == "pushd":
+		ctx.kind = completeDirectory
+	default:
+		ctx.kind = completeFile
+	}
+	return ctx
+}
+
+// isVariable reports whether the word ends in a parameter name being typed,
+// as in "$HO" or "${HO".
+func (ctx completionContext) isVariable() bool {
+	dollar := strings.LastIndexByte(ctx.raw, '$')
+	if dollar < 0 || ctx.quote == '\'' || dollar > 0 && ctx.raw[dollar-1] == '\\' {
+		return false
+	}
+	name := strings.TrimPrefix(ctx.raw[dollar+1:], "{")
+	return name == "" || isName(name)
+}
+
+// complete returns the candidates for the word described by ctx, sorted and
+// without duplicates.
+func (c *completer) complete(ctx completionContext) []completion {
+	var matches []completion
+	handled := false
+	if c.s != nil && len(ctx.args) > 0 && !ctx.redirect && ctx.kind != completeVariable {
+		matches, handled = c.s.argumentCompletions(ctx)
+	}
+	switch {
+	case handled:
+	case ctx.kind == completeCommand:
+		if strings.Contains(ctx.value, "/") {
+			matches = completeFiles(ctx, func(info fs.FileInfo) bool {
+				return info.IsDir() || info.Mode()&0111 != 0
+			})
+		} else {
+			matches = completeCommands(ctx)
+		}
+	case ctx.kind == completeVariable:
+		matches = completeVariables(ctx)
+	case ctx.kind == completeJob:
+		if c.s != nil {
+			matches = c.s.completeJobs(ctx)
+		}
+	case ctx.kind == completeDirectory:
+		matches = completeFiles(ctx, fs.FileInfo.IsDir)
+	default:
+		matches = completeFiles(ctx, nil)
+	}
+
+	sort.Slice(matches, func(i, j int) bool { return matches[i].word < matches[j].word })
+	var unique []completion
+	for i, m := range matches {
+		if i == 0 || m.word != matches[i-1].word {
+			unique = append(unique, m)
+		}
+	}
+	return unique
+}
+
+// argumentCompletions completes an argument of a command from the
+// completion registered for it with complete or, failing that, from its
+// spec file. A word starting with "-" that neither describes is completed
+// from the flags in the command's --help output. ok is false if none of
+// them has anything to say about the word.
+func (s *Shell) argumentCompletions(ctx completionContext) (matches []completion, ok bool) {
+	if spec := s.completionSpecFor(ctx.args[0]); spec != nil {
+		return s.specCompletions(spec, ctx), true
+	}
+	if spec := s.commandSpecFor(ctx.args[0]); spec != nil {
+		if matches, ok = s.commandSpecCompletions(spec, ctx); ok {
+			return matches, ok
+		}
+	}
+	if strings.HasPrefix(ctx.value, "-") && ctx.quote == 0 {
+		matches = s.helpFlagCompletions(ctx)
+		return matches, len(matches) > 0
+	}
+	return nil, false
+}
+
+// completeCommands matches builtins and executables in PATH.
+func completeCommands(ctx completionContext) []completion {
+	var matches []completion
+	add := func(name string) {
+		matches = append(matches, completion{word: quoteCompletion(name, 0, true), value: name, display: name, final: true})
+	}
+	for _, b := range builtinNames {
+		if strings.HasPrefix(b, ctx.value) {
+			add(b)
+		}
+	}
+	for _, name := range executablesInPath(ctx.value) {
+		add(name)
+	}
+	return matches
+}
+
+// completeFiles matches the entries of the directory named by the word.
+// Directories get a trailing slash and are never final, so completion can
+// continue inside them. keep, if not nil, filters the entries; a leading
+// "~/" in an unquoted word is looked up in $HOME but kept in the result.
+func completeFiles(ctx completionContext, keep func(fs.FileInfo) bool) []completion {
+	if ctx.quote == 0 && ctx.value == "~" {
+		return []completion{{word: "~/", value: "~/", display: "~/"}}
+	}
+	dirValue, base := "", ctx.value
+	if i := strings.LastIndexByte(ctx.value, '/'); i >= 0 {
+		dirValue, base = ctx.value[:i+1], ctx.value[i+1:]
+	}
+	dir := dirValue
+	if ctx.quote == 0 && strings.HasPrefix(dir, "~/") && ctx.raw[0] == '~' {
+		dir = os.Getenv("HOME") + dir[1:]
+	}
+	if dir == "" {
+		dir = "."
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+
+	head := ctx.raw[:ctx.slashAt]
+	if ctx.quote != 0 && ctx.quoteAt >= ctx.slashAt {
+		head += string(ctx.quote)
+	}
+	var matches []completion
+	for _, e := range entries {
+		name := e.Name()
+		if !strings.HasPrefix(name, base) || name[0] == '.' && !strings.HasPrefix(base, ".") {
+			continue
+		}
+		info, err := os.Stat(filepath.Join(dir, name))
+		if err != nil {
+			continue
+		}
+		if keep != nil && !keep(info) {
+			continue
+		}
+		text := name
+		if info.IsDir() {
+			text += "/"
+		}
+		matches = append(matches, completion{
+			word:    head + quoteCompletion(text, ctx.quote, head == ""),
+			value:   dirValue + name,
+			display: text,
+			final:   !info.IsDir(),
+		})
+	}
+	return matches
+}
+
+// completeVariables matches the names of environment variables, after a
+// "$" or "${" if the word has one.
+func completeVariables(ctx completionContext) []completion {
+	dollar := strings.LastIndexByte(ctx.raw, '$')
+	head, prefix := ctx.raw[:dollar+1], ctx.raw[dollar+1:]
+	braced := strings.HasPrefix(prefix, "{")
+	if braced {
+		head, prefix = head+"{", prefix[1:]
+	}
+	var matches []completion
+	for _, kv := range os.Environ() {
+		name, _, _ := strings.Cut(kv, "=")
+		if name == "" || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		word := head + name
+		if braced {
+			word += "}"
+		}
+		matches = append(matches, completion{word: word, value: name, display: name, final: true})
+	}
+	return matches
+}
+
+// completeJobs matches %n job specs of the shell's jobs.
+func (s *Shell) completeJobs(ctx completionContext) []completion {
+	var matches []completion
+	for _, j := range s.jobs {
+		spec := fmt.Sprintf("%%%d", j.id)
+		if strings.HasPrefix(spec, ctx.raw) {
+			matches = append(matches, completion{word: spec, value: spec, display: spec, description: j.line, final: true})
+		}
+	}
+	return matches
+}
+
+// quoteCompletion escapes s for insertion into a word: for the inside of an
+// open quote when quote is ' or ", or with backslashes when it is 0. atStart
+// says s begins the word, where "~" and "#" are special too.
+func quoteCompletion(s string, quote byte, atStart bool) string {
+	switch quote {
+	case '\'':
+		return strings.ReplaceAll(s, "'", `'\''`)
+	case '"':
+		var sb strings.Builder
+		for i := 0; i < len(s); i++ {
+			if strings.IndexByte("\"\\$", s[i]) >= 0 {
+				sb.WriteByte('\\')
+			}
+			sb.WriteByte(s[i])
+		}
+		return sb.String()
+	}
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if strings.IndexByte(" \t\n'\"\\$|&;<>(){}*?[]`!", c) >= 0 || i == 0 && atStart && (c == '~' || c == '#') {
+			sb.WriteByte('\\')
+		}
+		sb.WriteByte(c)
+	}
+	return sb.String()
+}
+
 func longestCommonPrefix(strs []string) string {
 	if len(strs) == 0 {
 		return ""
@@ -1,6 +1,11 @@
 package shell
 
-import "testing"
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
 
 func TestLongestCommonPrefix(t *testing.T) {
 	tests := []struct {
@@ -25,3 +30,172 @@ func TestLongestCommonPrefix(t *testing.T) {
 		})
 	}
 }
+
+// completionDir creates a directory of files to complete and changes into
+// it.
+func completionDir(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	for _, name := range []string{"alpha.txt", "my file.txt", "it's.txt", ".hidden", "dir/inner.go", "dir two/x"} {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		os.WriteFile(path, nil, 0644)
+	}
+	os.WriteFile(filepath.Join(dir, "run.sh"), nil, 0755)
+	t.Chdir(dir)
+	return dir
+}
+
+// completeOnce returns the text a single Tab would insert after input.
+func completeOnce(c *completer, input string) string {
+	c.lastInput, c.tabCount = "", 0
+	out, _ := c.Do([]rune(input), len([]rune(input)))
+	if len(out) != 1 {
+		return ""
+	}
+	return string(out[0])
+}
+
+func TestParseCompletion(t *testing.T) {
+	tests := []struct {
+		input string
+		kind  completionKind
+		raw   string
+		value string
+		quote byte
+	}{
+		{"ec", completeCommand, "ec", "ec", 0},
+		{"cat al", completeFile, "al", "al", 0},
+		{"cat my\\ fi", completeFile, "my\\ fi", "my fi", 0},
+		{"cat 'my fi", completeFile, "'my fi", "my fi", '\''},
+		{`cat "dir two/`, completeFile, `"dir two/`, "dir two/", '"'},
+		{"cd d", completeDirectory, "d", "d", 0},
+		{"ls; cd ", completeDirectory, "", "", 0},
+		{"echo $HO", completeVariable, "$HO", "$HO", 0},
+		{`echo "${PA`, completeVariable, `"${PA`, "${PA", '"'},
+		{"echo '$HO", completeFile, "'$HO", "$HO", '\''},
+		{"kill %", completeJob, "%", "%", 0},
+		{"> ou", completeFile, "ou", "ou", 0},
+		{"ls 2> ou", completeFile, "ou", "ou", 0},
+		{"ls | gr", completeCommand, "gr", "gr", 0},
+		{"time -p ma", completeCommand, "ma", "ma", 0},
+		{"FOO=1 ma", completeCommand, "ma", "ma", 0},
+		{"ls >out ", completeFile, "", "", 0},
+		{"true && ", completeCommand, "", "", 0},
+	}
+	for _, tt := range tests {
+		ctx := parseCompletion(tt.input)
+		if ctx.kind != tt.kind || ctx.raw != tt.raw || ctx.value != tt.value || ctx.quote != tt.quote {
+			t.Errorf("parseCompletion(%q) = kind %d raw %q value %q quote %q, want %d %q %q %q",
+				tt.input, ctx.kind, ctx.raw, ctx.value, ctx.quote, tt.kind, tt.raw, tt.value, tt.quote)
+		}
+	}
+}
+
+func TestCompleteFiles(t *testing.T) {
+	completionDir(t)
+	c := &completer{s: &Shell{}}
+	tests := []struct {
+		input, want string
+	}{
+		{"cat al", "pha.txt "},
+		{"cat my", `\ file.txt `},
+		{"cat 'my", ` file.txt' `},
+		{`cat "my`, ` file.txt" `},
+		{"cat it", `\'s.txt `},
+		{"cat 'it", `'\''s.txt' `},
+		{"cat dir/", "inner.go "},
+		{"cat dir\\ t", "wo/"},
+		{"cat .h", "idden "},
+		{"cd di", "r"},
+		{"cd dir\\ ", "two/"},
+		{"cat a", "lpha.txt "},
+		{"ls > al", "pha.txt "},
+		{"./r", "un.sh "},
+		{"cat nosuch", ""},
+	}
+	for _, tt := range tests {
+		if got := completeOnce(c, tt.input); got != tt.want {
+			t.Errorf("complete %q = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestCompleteDirectoriesOnly(t *testing.T) {
+	completionDir(t)
+	ctx := parseCompletion("cd ")
+	for _, m := range (&completer{}).complete(ctx) {
+		if !strings.HasSuffix(m.display, "/") {
+			t.Errorf("cd completion offered %q", m.display)
+		}
+	}
+}
+
+func TestCompleteTilde(t *testing.T) {
+	home := completionDir(t)
+	t.Setenv("HOME", home)
+	c := &completer{s: &Shell{}}
+	if got := completeOnce(c, "cat ~"); got != "/" {
+		t.Errorf("complete ~ = %q, want /", got)
+	}
+	if got := completeOnce(c, "cat ~/al"); got != "pha.txt " {
+		t.Errorf("complete ~/al = %q", got)
+	}
+}
+
+func TestCompleteVariables(t *testing.T) {
+	t.Setenv("GOSH_COMPLETE_TEST", "1")
+	c := &completer{s: &Shell{}}
+	if got := completeOnce(c, "echo $GOSH_COMPLETE_T"); got != "EST " {
+		t.Errorf("complete $GOSH_COMPLETE_T = %q", got)
+	}
+	if got := completeOnce(c, "echo ${GOSH_COMPLETE_T"); got != "EST} " {
+		t.Errorf("complete ${GOSH_COMPLETE_T = %q", got)
+	}
+	if got := completeOnce(c, `echo "x$GOSH_COMPLETE_T`); got != `EST" ` {
+		t.Errorf(`complete "x$GOSH_COMPLETE_T = %q`, got)
+	}
+}
+
+func TestCompleteJobs(t *testing.T) {
+	s := &Shell{jobs: []*job{{id: 1, line: "sleep 10"}, {id: 12, line: "sleep 20"}}}
+	c := &completer{s: s}
+	ctx := parseCompletion("kill %")
+	if got := c.complete(ctx); len(got) != 2 || got[0].word != "%1" || got[1].word != "%12" {
+		t.Errorf("job candidates = %+v", got)
+	}
+	if got := completeOnce(c, "fg %12"); got != " " {
+		t.Errorf("complete %%12 = %q", got)
+	}
+}
+
+func TestCompleteCommands(t *testing.T) {
+	c := &completer{s: &Shell{}}
+	if got := completeOnce(c, "ulim"); got != "it " {
+		t.Errorf("complete ulim = %q", got)
+	}
+	if got := completeOnce(c, "ls | ulim"); got != "it " {
+		t.Errorf("complete in pipeline = %q", got)
+	}
+}
+
+func TestQuoteCompletion(t *testing.T) {
+	tests := []struct {
+		s     string
+		quote byte
+		start bool
+		want  string
+	}{
+		{"a b", 0, false, `a\ b`},
+		{"~x", 0, true, `\~x`},
+		{"a~x", 0, true, "a~x"},
+		{"$x\"", '"', false, `\$x\"`},
+		{"it's", '\'', false, `it'\''s`},
+		{"(a)&", 0, false, `\(a\)\&`},
+	}
+	for _, tt := range tests {
+		if got := quoteCompletion(tt.s, tt.quote, tt.start); got != tt.want {
+			t.Errorf("quoteCompletion(%q, %q) = %q, want %q", tt.s, tt.quote, got, tt.want)
+		}
+	}
+}
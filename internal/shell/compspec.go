@@ -0,0 +1,407 @@
+package shell
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// completionSpec is a programmable completion, registered for commands with
+// the complete builtin or given to compgen.
+type completionSpec struct {
+	actions  []string // -A and its shorthands, such as file or command
+	options  []string // -o
+	words    string   // -W, expanded when completing
+	function string   // -F
+	command  string   // -C
+	filter   string   // -X
+	reply    string   // -V, compgen only
+}
+
+type completionAction struct {
+	name   string
+	letter byte
+}
+
+// completionActions are the names accepted by -A, with the option letters
+// that stand for them.
+var completionActions = []completionAction{
+	{"builtin", 'b'},
+	{"command", 'c'},
+	{"directory", 'd'},
+	{"file", 'f'},
+	{"job", 'j'},
+	{"variable", 'v'},
+}
+
+// completionOptions are the names accepted by -o.
+var completionOptions = []string{"default", "dirnames", "filenames", "nospace"}
+
+// parseCompleteArgs parses the options shared by complete and compgen.
+// flags holds the letters of the options that take no argument and are not
+// actions, such as p and r; only those in allowed are accepted.
+func parseCompleteArgs(args []string, allowed string) (spec *completionSpec, flags string, operands []string, err error) {
+	spec = &completionSpec{}
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		if a == "--" {
+			return spec, flags, args[i+1:], nil
+		}
+		if len(a) < 2 || a[0] != '-' {
+			return spec, flags, args[i:], nil
+		}
+	letters:
+		for j := 1; j < len(a); j++ {
+			c := a[j]
+			if strings.IndexByte(allowed, c) >= 0 {
+				flags += string(c)
+				continue
+			}
+			for _, act := range completionActions {
+				if act.letter == c {
+					spec.actions = append(spec.actions, act.name)
+					continue letters
+				}
+			}
+			if strings.IndexByte("AoWFCXV", c) < 0 {
+				return nil, "", nil, fmt.Errorf("-%c: invalid option", c)
+			}
+			var arg string
+			switch {
+			case j+1 < len(a):
+				arg = a[j+1:]
+			case i+1 < len(args):
+				i++
+				arg = args[i]
+			default:
+				return nil, "", nil, fmt.Errorf("-%c: option requires an argument", c)
+			}
+			switch c {
+			case 'A':
+				if !slices.ContainsFunc(completionActions, func(act completionAction) bool { return act.name == arg }) {
+					return nil, "", nil, fmt.Errorf("%s: invalid action name", arg)
+				}
+				spec.actions = append(spec.actions, arg)
+			case 'o':
+				if !slices.Contains(completionOptions, arg) {
+					return nil, "", nil, fmt.Errorf("%s: invalid option name", arg)
+				}
+				spec.options = append(spec.options, arg)
+			case 'W':
+				spec.words = arg
+			case 'F':
+				spec.function = arg
+			case 'C':
+				spec.command = arg
+			case 'X':
+				spec.filter = arg
+			case 'V':
+				if !isName(arg) {
+					return nil, "", nil, fmt.Errorf("`%s': not a valid identifier", arg)
+				}
+				spec.reply = arg
+			}
+			break
+		}
+	}
+	return spec, flags, nil, nil
+}
+
+func (spec *completionSpec) hasOption(name string) bool {
+	return slices.Contains(spec.options, name)
+}
+
+// String formats spec as the complete command that registers it for name.
+func (spec *completionSpec) String(name string) string {
+	parts := []string{"complete"}
+	for _, o := range spec.options {
+		parts = append(parts, "-o", o)
+	}
+	for _, a := range spec.actions {
+		parts = append(parts, "-A", a)
+	}
+	for _, opt := range []struct{ flag, value string }{
+		{"-W", spec.words}, {"-X", spec.filter}, {"-F", spec.function}, {"-C", spec.command},
+	} {
+		if opt.value != "" {
+			parts = append(parts, opt.flag, shellQuote(opt.value))
+		}
+	}
+	return strings.Join(append(parts, shellQuote(name)), " ")
+}
+
+// runComplete implements the complete builtin: complete [options] name...
+// registers a completion for the named commands, complete -p lists the
+// registered ones, and complete -r removes them.
+func (s *Shell) runComplete(args []string, stdout, stderr io.Writer) int {
+	spec, flags, names, err := parseCompleteArgs(args, "pr")
+	if err == nil && spec.reply != "" {
+		err = fmt.Errorf("-V: invalid option")
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "complete: %v\n", err)
+		return 2
+	}
+	switch {
+	case strings.Contains(flags, "r"):
+		if len(names) == 0 {
+			s.completions = nil
+			return 0
+		}
+		status := 0
+		for _, name := range names {
+			if _, ok := s.completions[name]; !ok {
+				fmt.Fprintf(stderr, "complete: %s: no completion specification\n", name)
+				status = 1
+			}
+			delete(s.completions, name)
+		}
+		return status
+	case strings.Contains(flags, "p") || len(args) == len(names):
+		if len(names) == 0 {
+			for name := range s.completions {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+		}
+		status := 0
+		for _, name := range names {
+			spec, ok := s.completions[name]
+			if !ok {
+				fmt.Fprintf(stderr, "complete: %s: no completion specification\n", name)
+				status = 1
+				continue
+			}
+			fmt.Fprintln(stdout, spec.String(name))
+		}
+		return status
+	case len(names) == 0:
+		fmt.Fprintln(stderr, "complete: usage: complete [-pr] [-bcdfjv] [-A action] [-o option] [-W wordlist] [-F command] [-C command] [-X filterpat] [name ...]")
+		return 2
+	}
+	if s.completions == nil {
+		s.completions = make(map[string]*completionSpec)
+	}
+	for _, name := range names {
+		s.completions[name] = spec
+	}
+	return 0
+}
+
+// runCompgen implements the compgen builtin, which prints the candidates
+// that its options generate for word, one per line. With -V name it
+// assigns them to the variable name instead, so a -F command can set
+// COMPREPLY with compgen -V COMPREPLY.
+func (s *Shell) runCompgen(args []string, stdout, stderr io.Writer) int {
+	spec, _, operands, err := parseCompleteArgs(args, "")
+	if err == nil && len(operands) > 1 {
+		err = fmt.Errorf("too many arguments")
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "compgen: %v\n", err)
+		return 2
+	}
+	word := ""
+	if len(operands) == 1 {
+		word = operands[0]
+	}
+	ctx := completionContext{
+		line:    word,
+		raw:     word,
+		value:   word,
+		slashAt: strings.LastIndexByte(word, '/') + 1,
+	}
+	matches := s.specCompletions(spec, ctx)
+	if spec.reply != "" {
+		values := make([]string, len(matches))
+		for i, m := range matches {
+			values[i] = m.value
+		}
+		os.Setenv(spec.reply, strings.Join(values, "\n"))
+	} else {
+		for _, m := range matches {
+			fmt.Fprintln(stdout, m.value)
+		}
+	}
+	if len(matches) == 0 {
+		return 1
+	}
+	return 0
+}
+
+// completionSpecFor returns the completion registered for the command named
+// by the raw word cmd, looking it up by its full name and then by its base
+// name, or nil.
+func (s *Shell) completionSpecFor(cmd string) *completionSpec {
+	name := strings.Join(expandWord(cmd, nil), "")
+	if spec, ok := s.completions[name]; ok {
+		return spec
+	}
+	return s.completions[filepath.Base(name)]
+}
+
+// specCompletions generates the candidates spec gives for the word
+// described by ctx. Like bash, words from -W are matched against the word,
+// but the results of -F and -C are taken as they are.
+func (s *Shell) specCompletions(spec *completionSpec, ctx completionContext) []completion {
+	var matches []completion
+	for _, action := range spec.actions {
+		matches = append(matches, s.actionCompletions(action, ctx)...)
+	}
+	var values []string
+	if spec.words != "" {
+		for _, w := range splitWords(spec.words) {
+			for _, v := range expandWord(w, s.lookupVar) {
+				if strings.HasPrefix(v, ctx.value) {
+					values = append(values, v)
+				}
+			}
+		}
+	}
+	if spec.function != "" {
+		values = append(values, s.runCompletion(spec.function, ctx, true)...)
+	}
+	if spec.command != "" {
+		values = append(values, s.runCompletion(spec.command, ctx, false)...)
+	}
+	for _, v := range values {
+		matches = append(matches, spec.valueCompletion(v, ctx))
+	}
+
+	if spec.filter != "" {
+		pattern, keep := spec.filter, false
+		if strings.HasPrefix(pattern, "!") {
+			pattern, keep = pattern[1:], true
+		}
+		matches = slices.DeleteFunc(matches, func(m completion) bool {
+			return globMatch(pattern, m.value) != keep
+		})
+	}
+	if len(matches) == 0 {
+		switch {
+		case spec.hasOption("dirnames"):
+			matches = completeFiles(ctx, fs.FileInfo.IsDir)
+		case spec.hasOption("default"):
+			matches = completeFiles(ctx, nil)
+		}
+	}
+	if spec.hasOption("nospace") {
+		for i := range matches {
+			matches[i].final = false
+		}
+	}
+	return matches
+}
+
+// actionCompletions generates the candidates of a -A action.
+func (s *Shell) actionCompletions(action string, ctx completionContext) []completion {
+	switch action {
+	case "builtin":
+		var matches []completion
+		for _, m := range completeCommands(ctx) {
+			if isBuiltin(m.value) {
+				matches = append(matches, m)
+			}
+		}
+		return matches
+	case "command":
+		return completeCommands(ctx)
+	case "directory":
+		return completeFiles(ctx, fs.FileInfo.IsDir)
+	case "file":
+		return completeFiles(ctx, nil)
+	case "job":
+		return s.completeJobs(ctx)
+	case "variable":
+		return completeVariables(ctx)
+	}
+	return nil
+}
+
+// valueCompletion turns a word from -W, -F or -C into a candidate. Like
+// bash, it is inserted as it is unless spec has -o filenames or the word
+// is inside quotes, and with -o filenames directories get a slash.
+func (spec *completionSpec) valueCompletion(v string, ctx completionContext) completion {
+	m := completion{word: v, value: v, display: v, final: true}
+	if spec.hasOption("filenames") {
+		if info, err := os.Stat(v); err == nil && info.IsDir() {
+			m.display += "/"
+			m.final = false
+		}
+		m.word = quoteCompletion(m.display, ctx.quote, true)
+	} else if ctx.quote != 0 {
+		m.word = quoteCompletion(v, ctx.quote, true)
+	}
+	if ctx.quote != 0 && ctx.quoteAt == 0 {
+		m.word = string(ctx.quote) + m.word
+	}
+	return m
+}
+
+// runCompletion runs the -F or -C command line of a spec and returns the
+// candidates it gives. A -F command is run by gosh itself, on a subshell
+// copy so it cannot change the shell's options or traps, with $1, $2 and $3
+// set to the name of the command being completed, the word being completed
+// and the word before it; its candidates are the lines it assigns to
+// COMPREPLY. A -C command must be a program, which gets those three words
+// as arguments and whose output lines are the candidates. gosh has no
+// arrays, so COMP_WORDS holds the words of the command line separated by
+// spaces; COMP_CWORD, COMP_LINE and COMP_POINT are set as in bash. All of
+// them are exported, so programs can read them too. Any variable the
+// command sets is discarded afterwards.
+func (s *Shell) runCompletion(command string, ctx completionContext, function bool) []string {
+	words := append(slices.Clone(ctx.args), ctx.raw)
+	cmd, prev := "", ""
+	if len(ctx.args) > 0 {
+		cmd = strings.Join(expandWord(ctx.args[0], nil), "")
+		prev = strings.Join(expandWord(ctx.args[len(ctx.args)-1], nil), "")
+	}
+	defer restoreEnv(os.Environ())
+	s.assign([]string{
+		"COMP_WORDS=" + shellQuote(strings.Join(words, " ")),
+		"COMP_CWORD=" + strconv.Itoa(len(ctx.args)),
+		"COMP_LINE=" + shellQuote(ctx.line),
+		"COMP_POINT=" + strconv.Itoa(len(ctx.line)),
+	})
+	os.Unsetenv("COMPREPLY")
+
+	args := []string{cmd, ctx.value, prev}
+	if function {
+		sub := s.subshell()
+		sub.params = args
+		sub.execLine(command)
+		return nonEmptyLines(os.Getenv("COMPREPLY"))
+	}
+	parts, err := s.expandWords(splitWords(command))
+	if err != nil || len(parts) == 0 {
+		return nil
+	}
+	return s.commandOutput(append(parts, args...))
+}
+
+// commandOutput runs the program parts without input and returns the
+// non-empty lines it prints. Builtins are not run, so completing cannot
+// change the shell's state, and what the program writes to stderr is
+// discarded so it does not disturb the line being edited.
+func (s *Shell) commandOutput(parts []string) []string {
+	var out bytes.Buffer
+	runExternal(parts, nil, &out, io.Discard)
+	return nonEmptyLines(out.String())
+}
+
+func nonEmptyLines(text string) []string {
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
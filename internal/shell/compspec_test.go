@@ -0,0 +1,181 @@
+package shell
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseCompleteArgs(t *testing.T) {
+	spec, flags, names, err := parseCompleteArgs([]string{"-pfo", "nospace", "-Wa b", "-A", "variable", "--", "-x"}, "pr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flags != "p" || !slices.Equal(names, []string{"-x"}) {
+		t.Errorf("flags = %q, names = %q", flags, names)
+	}
+	if !slices.Equal(spec.actions, []string{"file", "variable"}) || !spec.hasOption("nospace") || spec.words != "a b" {
+		t.Errorf("spec = %+v", spec)
+	}
+
+	for _, args := range [][]string{{"-p"}, {"-A", "alias"}, {"-o", "bashdefault"}, {"-W"}, {"-z"}} {
+		if _, _, _, err := parseCompleteArgs(args, ""); err == nil {
+			t.Errorf("parseCompleteArgs(%q) succeeded", args)
+		}
+	}
+}
+
+func TestCompleteBuiltin(t *testing.T) {
+	s := &Shell{}
+	var stdout, stderr strings.Builder
+	if status := s.runComplete([]string{"-o", "nospace", "-W", "start stop", "svc", "service"}, &stdout, &stderr); status != 0 {
+		t.Fatalf("complete = %d: %s", status, stderr.String())
+	}
+	s.runComplete([]string{"-d", "-X", "!*x", "mk"}, &stdout, &stderr)
+	s.runComplete([]string{"-p"}, &stdout, &stderr)
+	want := "complete -A directory -X '!*x' mk\n" +
+		"complete -o nospace -W 'start stop' service\n" +
+		"complete -o nospace -W 'start stop' svc\n"
+	if stdout.String() != want {
+		t.Errorf("complete -p printed %q, want %q", stdout.String(), want)
+	}
+
+	if status := s.runComplete([]string{"-r", "svc"}, &stdout, &stderr); status != 0 || s.completions["svc"] != nil {
+		t.Errorf("complete -r svc = %d, spec %v", status, s.completions["svc"])
+	}
+	stderr.Reset()
+	if status := s.runComplete([]string{"-p", "svc"}, &stdout, &stderr); status != 1 || !strings.Contains(stderr.String(), "no completion specification") {
+		t.Errorf("complete -p svc = %d, stderr %q", status, stderr.String())
+	}
+	if status := s.runComplete([]string{"-W", "x"}, &stdout, &stderr); status != 2 {
+		t.Errorf("complete without a name = %d, want 2", status)
+	}
+	if status := s.runComplete([]string{"-V", "COMPREPLY", "svc"}, &stdout, &stderr); status != 2 {
+		t.Errorf("complete -V = %d, want 2", status)
+	}
+}
+
+func TestCompgen(t *testing.T) {
+	completionDir(t)
+	t.Setenv("GOSH_COMPGEN", "gamma")
+	tests := []struct {
+		args   []string
+		want   string
+		status int
+	}{
+		{[]string{"-W", "alpha beta $GOSH_COMPGEN", "a"}, "alpha\n", 0},
+		{[]string{"-W", "alpha beta $GOSH_COMPGEN"}, "alpha\nbeta\ngamma\n", 0},
+		{[]string{"-d"}, "dir\ndir two\n", 0},
+		{[]string{"-f", "-X", "*.txt", "a"}, "", 1},
+		{[]string{"-f", "-X", "!*.txt", "a"}, "alpha.txt\n", 0},
+		{[]string{"-v", "GOSH_COMP"}, "GOSH_COMPGEN\n", 0},
+		{[]string{"-b", "compg"}, "compgen\n", 0},
+		{[]string{"-W", "x", "a", "b"}, "", 2},
+		{[]string{"-W", "x", "-V", "1x"}, "", 2},
+	}
+	for _, tt := range tests {
+		var stdout strings.Builder
+		s := &Shell{}
+		status := s.runCompgen(tt.args, &stdout, &strings.Builder{})
+		if status != tt.status || stdout.String() != tt.want {
+			t.Errorf("compgen %q = %d, %q; want %d, %q", tt.args, status, stdout.String(), tt.status, tt.want)
+		}
+	}
+
+	t.Setenv("GOSH_REPLY", "")
+	var stdout strings.Builder
+	status := (&Shell{}).runCompgen([]string{"-W", "alpha beta", "-V", "GOSH_REPLY"}, &stdout, &strings.Builder{})
+	if got := os.Getenv("GOSH_REPLY"); status != 0 || stdout.Len() != 0 || got != "alpha\nbeta" {
+		t.Errorf("compgen -V = %d, printed %q, set %q; want 0, nothing, %q", status, stdout.String(), got, "alpha\nbeta")
+	}
+}
+
+func TestCompleteWithSpec(t *testing.T) {
+	completionDir(t)
+	s := &Shell{}
+	c := &completer{s: s}
+	s.runComplete([]string{"-W", "start stop status", "svc"}, &strings.Builder{}, &strings.Builder{})
+	s.runComplete([]string{"-o", "nospace", "-W", "--name=", "tag"}, &strings.Builder{}, &strings.Builder{})
+	s.runComplete([]string{"-o", "default", "-W", "build", "mk"}, &strings.Builder{}, &strings.Builder{})
+
+	tests := []struct{ input, want string }{
+		{"svc star", "t "},
+		{"svc sto", "p "},
+		{"/usr/bin/svc sto", "p "},
+		{"svc start 'sto", "p' "},
+		{"svc sta", ""},
+		{"svc > al", "pha.txt "},
+		{"tag --n", "ame="},
+		{"mk b", "uild "},
+		{"mk al", "pha.txt "},
+		{"svc al", ""},
+	}
+	for _, tt := range tests {
+		if got := completeOnce(c, tt.input); got != tt.want {
+			t.Errorf("completing %q inserted %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestCompleteCommandSpec(t *testing.T) {
+	dir := completionDir(t)
+	script := filepath.Join(dir, "bin", "_svc")
+	os.MkdirAll(filepath.Dir(script), 0755)
+	os.WriteFile(script, []byte("#!/bin/sh\n"+
+		`echo "$1|$2|$3|$COMP_CWORD|$COMP_WORDS|$COMP_LINE|$COMP_POINT"`+"\n"), 0755)
+	t.Setenv("PATH", filepath.Dir(script)+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	s := &Shell{}
+	s.runComplete([]string{"-C", "_svc", "svc"}, &strings.Builder{}, &strings.Builder{})
+	var values []string
+	for _, m := range (&completer{s: s}).complete(parseCompletion("ls; svc 'a b' --v")) {
+		values = append(values, m.value)
+	}
+	want := []string{"svc|--v|a b|2|svc 'a b' --v|ls; svc 'a b' --v|17"}
+	if !slices.Equal(values, want) {
+		t.Errorf("complete -C gave %q, want %q", values, want)
+	}
+	if _, ok := os.LookupEnv("COMP_LINE"); ok {
+		t.Errorf("COMP_LINE is still set after completion")
+	}
+}
+
+func TestCompleteFunctionSpec(t *testing.T) {
+	completionDir(t)
+	s := &Shell{}
+	s.runComplete([]string{"-F", `compgen -W "start stop status $1" -V COMPREPLY -- "$2"; X=1; set -e; exit 3`, "svc"},
+		&strings.Builder{}, &strings.Builder{})
+	var values []string
+	for _, m := range (&completer{s: s}).complete(parseCompletion("svc st")) {
+		values = append(values, m.value)
+	}
+	if want := []string{"start", "status", "stop"}; !slices.Equal(values, want) {
+		t.Errorf("complete -F gave %q, want %q", values, want)
+	}
+	if got := (&completer{s: s}).complete(parseCompletion("svc s")); len(got) != 4 {
+		t.Errorf("complete -F with $1 gave %d candidates, want 4", len(got))
+	}
+	for _, name := range []string{"COMPREPLY", "X"} {
+		if _, ok := os.LookupEnv(name); ok {
+			t.Errorf("%s is still set after completion", name)
+		}
+	}
+	if s.exiting || s.option("errexit") {
+		t.Errorf("the -F command changed the shell: exiting = %v, errexit = %v", s.exiting, s.option("errexit"))
+	}
+}
+
+func TestCompletionCommandIsExternal(t *testing.T) {
+	dir, _ := os.Getwd()
+	s := &Shell{}
+	for _, command := range []string{"exit", "cd /"} {
+		if got := s.runCompletion(command, completionContext{}, false); got != nil {
+			t.Errorf("runCompletion(%q) = %q, want nil", command, got)
+		}
+	}
+	if cwd, _ := os.Getwd(); s.exiting || cwd != dir {
+		t.Errorf("-C builtins changed the shell: exiting = %v, cwd = %s", s.exiting, cwd)
+	}
+}
@@ -0,0 +1,9 @@
+package shell
+
+import "syscall"
+
+// dup2 duplicates oldfd onto newfd. Some Linux ports lack dup2, so this
+// uses dup3, which behaves the same when the descriptors differ.
+func dup2(oldfd, newfd int) error {
+	return syscall.Dup3(oldfd, newfd, 0)
+}
@@ -0,0 +1,422 @@
+package shell
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/chzyer/readline"
+)
+
+// lineView is the part of the line editor that widgets redraw. It is
+// satisfied by the Operation of a *readline.Instance.
+type lineView interface {
+	Clean()
+	Refresh()
+	SetPrompt(string)
+	SetBuffer(string)
+}
+
+// editor hooks gosh's own widgets into readline. readline passes every
+// input rune through filter before acting on it, reports each change of the
+// line to OnChange, and draws the line through Paint. All three run on
+// readline's input goroutine while Run waits in Readline.
+type editor struct {
+	s       *Shell
+	view    lineView
+	prompt  string // the prompt to restore when a widget ends
+	base    string // prompt without the vi mode indicator
+	rprompt string // shown at the right edge while no widget is active
+
+	viCommand bool // vi mode is in command mode
+
+	line []rune // the edit line as of the last change
+	pos  int
+
+	search *historySearch // the active Ctrl-R search, if any
+	saved  string         // the line before the search started
+
+	ctrlX         bool // Ctrl-X was pressed, waiting for the next key
+	editRequested bool // Ctrl-X Ctrl-E submitted the line for editing
+
+	menu     *completionMenu // the open completion menu, if any
+	accepted *completion     // the menu item for the completer to insert
+
+	suggestion autosuggest  // the suggestion shown after the line
+	pending    *pendingEdit // a bound key's edit for OnChange to make
+
+	termSize func() (width, height int)
+}
+
+// charCtrlX starts two-key bindings; readline has no name for it.
+const charCtrlX = 24
+
+// charNewline stands for a newline in the edit line. readline measures a
+// newline as taking no room and draws the line as a single row that
+// wraps, so a command of several lines is edited as one row, with each
+// newline shown as a dim ↵.
+const charNewline = '\uE0FF'
+
+// styleNewline is the SGR attribute of the ↵ shown for charNewline.
+const styleNewline = "90"
+
+// editLine returns a command as the editor holds it.
+func editLine(cmd string) string {
+	return strings.ReplaceAll(cmd, "\n", string(charNewline))
+}
+
+// enteredLine returns the command held in an edit line.
+func enteredLine(line string) string {
+	return strings.ReplaceAll(line, string(charNewline), "\n")
+}
+
+// showNewlines returns line with each charNewline drawn as ↵.
+func showNewlines(line []rune) []rune {
+	if !slices.Contains(line, charNewline) {
+		return line
+	}
+	var out []rune
+	for _, r := range line {
+		if r == charNewline {
+			out = append(out, []rune("\x1b["+styleNewline+"m↵\x1b[0m")...)
+		} else {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+func newEditor(s *Shell, prompt string) *editor {
+	return &editor{s: s, prompt: prompt, termSize: terminalSize}
+}
+
+// terminalSize returns the size of the terminal on stdout, or 80x24 if it
+// is not a terminal.
+func terminalSize() (width, height int) {
+	width, height, err := readline.GetSize(int(os.Stdout.Fd()))
+	if err != nil || width <= 0 || height <= 0 {
+		return 80, 24
+	}
+	return width, height
+}
+
+// filter intercepts the keys that start gosh widgets, every key while a
+// widget is active, and bound keys. It returns false for keys readline
+// should not see. In vi mode it follows the keys that switch between
+// insert and command mode, which readline does not report.
+func (e *editor) filter(r rune) (rune, bool) {
+	r, ok := e.filterKey(r)
+	if ok && e.s.option("vi") {
+		e.viKey(r)
+	}
+	return r, ok
+}
+
+func (e *editor) filterKey(r rune) (rune, bool) {
+	if e.menu != nil {
+		switch e.menu.key(r) {
+		case menuContinue:
+			return r, false
+		case menuAccept:
+			// readline can only insert text through the completer, so
+			// hand it a Tab and the item for Do to return.
+			e.accepted = &e.menu.items[e.menu.selected]
+			e.menu = nil
+			return readline.CharTab, true
+		case menuClose:
+			e.menu = nil
+			return r, false
+		case menuPass:
+			e.closeMenu()
+		}
+	}
+	if e.search != nil {
+		if r == 0 { // end of input
+			e.searchKey(readline.CharInterrupt)
+			return r, true
+		}
+		e.searchKey(r)
+		return r, false
+	}
+	if b, ok := e.bound(r, e.ctrlX); ok {
+		e.ctrlX = false
+		return e.runBinding(b)
+	}
+	if e.ctrlX {
+		e.ctrlX = false
+		if r == readline.CharLineEnd {
+			// Submit the line; Run sees the request and opens the
+			// editor once readline has restored the terminal.
+			e.editRequested = true
+			return readline.CharEnter, true
+		}
+		return r, true
+	}
+	return e.editKey(r)
+}
+
+// editKey handles a key that no widget has taken.
+func (e *editor) editKey(r rune) (rune, bool) {
+	if e.acceptSuggestion(r) {
+		return r, false
+	}
+	switch r {
+	case readline.CharBckSearch:
+		e.startSearch()
+		return r, false
+	case charCtrlX:
+		e.ctrlX = true
+		return r, false
+	case charBackTab:
+		return r, false
+	}
+	if r > metaBase && r < metaBase+utf8.RuneSelf {
+		return r, false // an Alt key that is not bound
+	}
+	return r, true
+}
+
+// viKey follows vi mode into command mode on Escape and back into insert
+// mode on the keys that start inserting text, or end the line, and shows
+// the mode in the prompt. The keys are the ones readline's vi mode knows:
+// i, I, a, A, s and S insert at once, and c inserts after the motion that
+// follows it, whatever that is. readline reads that motion, like the
+// argument of d, r, f or t, without passing it through the filter, so the
+// insert prompt shows as soon as c is typed and an argument is never taken
+// for a command here.
+func (e *editor) viKey(r rune) {
+	command := e.viCommand
+	switch {
+	case !command:
+		command = r == readline.CharEsc
+	case strings.ContainsRune("iIaAsSc", r), r == readline.CharEnter, r == readline.CharInterrupt:
+		command = false
+	}
+	if command == e.viCommand {
+		return
+	}
+	e.viCommand = command
+	e.prompt = e.modePrompt() + e.base
+	if e.view != nil {
+		e.view.Clean()
+		e.view.SetPrompt(e.prompt)
+		e.view.Refresh()
+	}
+}
+
+// modePrompt returns the vi mode indicator shown before the prompt:
+// GOSH_VI_INSERT_MODE or GOSH_VI_COMMAND_MODE, expanded like PS1. It is
+// empty in emacs mode.
+func (e *editor) modePrompt() string {
+	if !e.s.option("vi") {
+		return ""
+	}
+	name := "GOSH_VI_INSERT_MODE"
+	if e.viCommand {
+		name = "GOSH_VI_COMMAND_MODE"
+	}
+	prompt, _ := e.s.prompt(name)
+	return prompt
+}
+
+// takeEditRequest reports whether the line Readline just returned was
+// submitted with Ctrl-X Ctrl-E, and clears the request.
+func (e *editor) takeEditRequest() bool {
+	requested := e.editRequested
+	e.editRequested = false
+	return requested
+}
+
+// OnChange implements readline.Listener. It makes the edit of a bound key,
+// if one is pending.
+func (e *editor) OnChange(line []rune, pos int, key rune) ([]rune, int, bool) {
+	if p := e.pending; p != nil {
+		e.pending = nil
+		e.line, e.pos = slices.Clone(p.line), p.pos
+		return p.line, p.pos, true
+	}
+	e.line = append(e.line[:0], line...)
+	e.pos = pos
+	return nil, 0, false
+}
+
+// Paint implements readline.Painter. The line is highlighted as it is
+// typed, or to show the matches of a search. The right prompt is drawn
+// before the line, the suggestion after it while the cursor is at its end,
+// and an open completion menu below it.
+func (e *editor) Paint(line []rune, pos int) []rune {
+	painted := showNewlines(line)
+	if e.search != nil {
+		if m := e.search.selected(); m != nil && string(line) == editLine(m.line) {
+			painted = showNewlines(highlight(line, m.positions))
+		}
+	} else {
+		if e.s.shopt("highlight") {
+			painted = e.s.highlightSyntax(line)
+		}
+		ghost := ""
+		if e.menu == nil && pos == len(line) && e.s.shopt("autosuggest") {
+			ghost = e.s.suggest(&e.suggestion, string(line), e.refresh)
+		}
+		width, _ := e.termSize()
+		if ghost != "" {
+			start := textWidth(e.prompt) + textWidth(string(line))
+			painted = append(painted[:len(painted):len(painted)], []rune(ghostText(ghost, start, width))...)
+		}
+		if e.rprompt != "" {
+			right := rightPrompt(e.rprompt, e.prompt, append([]rune(string(line)), []rune(ghost)...), width)
+			painted = append([]rune(right), painted...)
+		}
+	}
+	if e.menu != nil {
+		width, height := e.termSize()
+		lineRows := (textWidth(e.prompt)+textWidth(string(line)))/width + 1
+		lines := e.menu.render(width, max(height-lineRows-1, 1))
+		painted = append(painted[:len(painted):len(painted)], []rune(menuOverlay(lines, e.prompt, line, width))...)
+	}
+	return painted
+}
+
+// openMenu shows items in the completion menu. It is called by the
+// completer, which readline does not follow with a refresh.
+func (e *editor) openMenu(items []completion) {
+	e.menu = newCompletionMenu(items)
+	e.refresh()
+}
+
+// closeMenu closes the menu and redraws the line without it.
+func (e *editor) closeMenu() {
+	e.menu = nil
+	e.refresh()
+}
+
+// refresh redraws the line.
+func (e *editor) refresh() {
+	if e.view != nil {
+		e.view.Refresh()
+	}
+}
+
+// takeAccepted returns the menu item accepted with Enter, if any, and
+// clears it.
+func (e *editor) takeAccepted() *completion {
+	m := e.accepted
+	e.accepted = nil
+	return m
+}
+
+// setPrompt sets the prompt and right prompt of the next line, which
+// starts in vi insert mode. Widgets restore the prompt when they end.
+func (e *editor) setPrompt(prompt, rprompt string) {
+	e.base, e.rprompt = prompt, rprompt
+	e.viCommand = false
+	e.prompt = e.modePrompt() + prompt
+	e.view.SetPrompt(e.prompt)
+}
+
+// redraw replaces the prompt and the edit line.
+func (e *editor) redraw(prompt, line string) {
+	line = editLine(line)
+	e.line = []rune(line)
+	e.pos = len(e.line)
+	if e.view == nil {
+		return
+	}
+	e.view.Clean()
+	e.view.SetPrompt(prompt)
+	e.view.SetBuffer(line)
+}
+
+// startSearch opens the history search widget, using the current line as
+// the initial query.
+func (e *editor) startSearch() {
+	cwd, _ := os.Getwd()
+	e.saved = string(e.line)
+	e.search = newHistorySearch(e.s.searchEntries(), cwd, e.saved)
+	e.showSearch()
+}
+
+func (e *editor) showSearch() {
+	line := string(e.search.query)
+	if m := e.search.selected(); m != nil {
+		line = m.line
+	}
+	e.redraw(e.search.prompt(), line)
+}
+
+// searchKey passes a key to the search widget. Accepting puts the selected
+// line in the buffer for editing; it is not run until Enter is pressed
+// again.
+func (e *editor) searchKey(r rune) {
+	switch e.search.key(r) {
+	case searchContinue:
+		e.showSearch()
+	case searchAccept:
+		line := e.saved
+		if m := e.search.selected(); m != nil {
+			line = m.line
+		}
+		e.search = nil
+		e.redraw(e.prompt, line)
+	case searchCancel:
+		e.search = nil
+		e.redraw(e.prompt, e.saved)
+	}
+}
+
+// keyReader reads the terminal for readline, replacing the escape sequence
+// of Shift-Tab, which readline would drop, with charBackTab, and those of
+// Alt keys readline has no key for with runes from metaBase. In vi mode,
+// where readline passes Escape on at once and so cannot read escape
+// sequences, the cursor keys are replaced with their control keys.
+type keyReader struct {
+	io.ReadCloser
+	vi func() bool // reports whether vi mode is on; nil for never
+}
+
+// viCursorKeys are the control keys for the last byte of the escape
+// sequences of the cursor keys, Home and End.
+var viCursorKeys = map[byte]byte{
+	'A': readline.CharPrev,
+	'B': readline.CharNext,
+	'C': readline.CharForward,
+	'D': readline.CharBackward,
+	'H': readline.CharLineStart,
+	'F': readline.CharLineEnd,
+}
+
+func (k keyReader) Read(p []byte) (int, error) {
+	vi := k.vi != nil && k.vi()
+	if vi || len(p) < 3 {
+		n, err := k.ReadCloser.Read(p)
+		return copy(p, translateKeys(p[:n], vi)), err
+	}
+	// Alt keys grow from two bytes to three, so leave room for them.
+	n, err := k.ReadCloser.Read(p[:len(p)*2/3])
+	return copy(p, translateKeys(p[:n], vi)), err
+}
+
+// translateKeys makes the replacements of keyReader in the bytes read.
+func translateKeys(in []byte, vi bool) []byte {
+	out := make([]byte, 0, len(in))
+	for i := 0; i < len(in); i++ {
+		rest := in[i:]
+		switch {
+		case bytes.HasPrefix(rest, []byte("\x1b[Z")):
+			out = utf8.AppendRune(out, charBackTab)
+			i += 2
+		case vi && len(rest) >= 3 && rest[0] == '\x1b' && (rest[1] == '[' || rest[1] == 'O') && viCursorKeys[rest[2]] != 0:
+			out = append(out, viCursorKeys[rest[2]])
+			i += 2
+		case !vi && len(rest) >= 2 && rest[0] == '\x1b' && isMetaChar(rest[1]):
+			out = utf8.AppendRune(out, metaBase+rune(rest[1]))
+			i++
+		default:
+			out = append(out, rest[0])
+		}
+	}
+	return out
+}
@@ -0,0 +1,173 @@
+package shell
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/chzyer/readline"
+)
+
+// fakeView records what the editor draws.
+type fakeView struct {
+	prompt, buffer string
+}
+
+func (v *fakeView) Clean()             {}
+func (v *fakeView) Refresh()           {}
+func (v *fakeView) SetPrompt(p string) { v.prompt = p }
+func (v *fakeView) SetBuffer(b string) { v.buffer = b }
+
+func newTestEditor(history ...string) (*editor, *fakeView) {
+	view := &fakeView{}
+	e := newEditor(&Shell{history: history}, "$ ")
+	e.view = view
+	return e, view
+}
+
+func typeKeys(e *editor, keys string) {
+	for _, r := range keys {
+		e.filter(r)
+	}
+}
+
+func TestEditorSearchAccept(t *testing.T) {
+	e, view := newTestEditor("make test", "ls -l", "git push")
+	e.OnChange([]rune("x"), 1, 'x')
+
+	if _, ok := e.filter(readline.CharBckSearch); ok {
+		t.Fatal("Ctrl-R was passed to readline")
+	}
+	typeKeys(e, "\bmkt")
+	if view.buffer != "make test" {
+		t.Errorf("buffer while searching = %q, want make test", view.buffer)
+	}
+	painted := string(e.Paint([]rune("make test"), 0))
+	if painted == "make test" {
+		t.Errorf("match was not highlighted")
+	}
+
+	if _, ok := e.filter(readline.CharEnter); ok {
+		t.Fatal("Enter was passed to readline while searching")
+	}
+	if e.search != nil || view.prompt != "$ " || view.buffer != "make test" {
+		t.Errorf("after accept: search %v, prompt %q, buffer %q", e.search, view.prompt, view.buffer)
+	}
+	if _, ok := e.filter(readline.CharEnter); !ok {
+		t.Error("Enter after the search was not passed to readline")
+	}
+}
+
+func TestEditorSearchMultiLine(t *testing.T) {
+	e, view := newTestEditor("ls |\nwc -l")
+	e.filter(readline.CharBckSearch)
+	typeKeys(e, "wc")
+	line := editLine("ls |\nwc -l")
+	if view.buffer != line {
+		t.Fatalf("buffer while searching = %q, want %q", view.buffer, line)
+	}
+	if painted := string(e.Paint([]rune(line), 0)); strings.ContainsRune(painted, '\n') || !strings.Contains(painted, "↵") {
+		t.Errorf("the match was painted as %q", painted)
+	}
+	e.filter(readline.CharEnter)
+	if view.buffer != line || enteredLine(string(e.line)) != "ls |\nwc -l" {
+		t.Errorf("after accept: buffer %q, line %q", view.buffer, string(e.line))
+	}
+}
+
+func TestEditorSearchCancel(t *testing.T) {
+	e, view := newTestEditor("make test")
+	e.OnChange([]rune("echo hi"), 7, 'i')
+	e.filter(readline.CharBckSearch)
+	if e.search == nil || string(e.search.query) != "echo hi" {
+		t.Fatalf("search did not start with the current line as query")
+	}
+	e.filter(readline.CharBell)
+	if e.search != nil || view.buffer != "echo hi" || view.prompt != "$ " {
+		t.Errorf("after cancel: search %v, prompt %q, buffer %q", e.search, view.prompt, view.buffer)
+	}
+}
+
+func TestEditorSearchEndOfInput(t *testing.T) {
+	e, _ := newTestEditor("ls")
+	e.filter(readline.CharBckSearch)
+	if _, ok := e.filter(0); !ok || e.search != nil {
+		t.Errorf("end of input did not end the search")
+	}
+}
+
+func TestEditorCtrlXCtrlE(t *testing.T) {
+	e, _ := newTestEditor()
+	if _, ok := e.filter(charCtrlX); ok {
+		t.Fatal("Ctrl-X was passed to readline")
+	}
+	if r, ok := e.filter(readline.CharLineEnd); !ok || r != readline.CharEnter {
+		t.Errorf("Ctrl-X Ctrl-E = %q %v, want Enter", r, ok)
+	}
+	if !e.takeEditRequest() || e.takeEditRequest() {
+		t.Errorf("edit request not reported exactly once")
+	}
+
+	e.filter(charCtrlX)
+	if r, ok := e.filter('a'); !ok || r != 'a' || e.takeEditRequest() {
+		t.Errorf("Ctrl-X a = %q %v", r, ok)
+	}
+}
+
+func TestEditorViMode(t *testing.T) {
+	e, view := newTestEditor()
+	e.s.setOption("vi", true)
+	t.Setenv("GOSH_VI_COMMAND_MODE", `[\u] `)
+	t.Setenv("USER", "ann")
+	e.setPrompt("$ ", "")
+	if view.prompt != "(ins) $ " {
+		t.Errorf("prompt in insert mode = %q", view.prompt)
+	}
+	typeKeys(e, "ls\x1b")
+	if view.prompt != "[ann] $ " || !e.viCommand {
+		t.Errorf("prompt after Escape = %q", view.prompt)
+	}
+	typeKeys(e, "hxC")
+	if view.prompt != "[ann] $ " {
+		t.Errorf("prompt after a command = %q", view.prompt)
+	}
+	typeKeys(e, "A")
+	if view.prompt != "(ins) $ " || e.viCommand {
+		t.Errorf("prompt after A = %q", view.prompt)
+	}
+	typeKeys(e, "\x1bc")
+	if view.prompt != "(ins) $ " || e.viCommand {
+		t.Errorf("prompt after c = %q", view.prompt)
+	}
+
+	typeKeys(e, "\x1b")
+	e.setPrompt("$ ", "")
+	if view.prompt != "(ins) $ " || e.viCommand {
+		t.Errorf("a new line starts with prompt %q", view.prompt)
+	}
+
+	e.s.setOption("emacs", true)
+	typeKeys(e, "\x1b")
+	e.setPrompt("$ ", "")
+	if view.prompt != "$ " || e.viCommand {
+		t.Errorf("prompt in emacs mode = %q", view.prompt)
+	}
+}
+
+func TestKeyReaderModes(t *testing.T) {
+	vi := false
+	k := keyReader{stringReader{strings.NewReader("\x1bg\x1b.\x1bb\x1b[A\x1bOF")}, func() bool { return vi }}
+	data, _ := io.ReadAll(k)
+	want := string(metaBase+'g') + string(metaBase+'.') + "\x1bb\x1b[A\x1bOF"
+	if string(data) != want {
+		t.Errorf("read in emacs mode %q, want %q", data, want)
+	}
+
+	vi = true
+	k.ReadCloser = stringReader{strings.NewReader("\x1bk\x1b[A\x1b[C\x1bOF\x1b[Z")}
+	data, _ = io.ReadAll(k)
+	want = "\x1bk\x10\x06\x05" + string(charBackTab)
+	if string(data) != want {
+		t.Errorf("read in vi mode %q, want %q", data, want)
+	}
+}
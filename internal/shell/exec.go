@@ -1,56 +1,327 @@
 package shell
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
+	"strings"
+	"syscall"
 )
 
-// dispatch routes a single command to the appropriate handler.
-func (s *Shell) dispatch(parts []string, stdin io.Reader, stdout, stderr io.Writer) {
+// execLine parses and runs one line of input, returning its exit status.
+func (s *Shell) execLine(line string) int {
+	return s.execList(line, os.Stdin, os.Stdout, os.Stderr)
+}
+
+// execList runs a line like execLine, with stdin, stdout and stderr in place
+// of the shell's own streams, so that the redirections and pipes given to
+// eval apply to the commands it runs.
+func (s *Shell) execList(line string, stdin io.Reader, stdout, stderr io.Writer) int {
+	items := splitList(splitWords(line))
+	for i, item := range items {
+		if i > 0 {
+			prev := items[i-1].op
+			if prev == "&&" && s.lastStatus != 0 || prev == "||" && s.lastStatus == 0 {
+				continue
+			}
+		}
+		s.fireTrap("DEBUG")
+		if item.op == "&" {
+			s.startJob(item.words, stdout, stderr)
+			s.lastStatus = 0
+		} else {
+			var negated bool
+			s.lastStatus, negated = s.runWords(item.words, stdin, stdout, stderr)
+			// As in POSIX, a failure on the left of && or || or in a
+			// negated pipeline is not an error.
+			if s.lastStatus != 0 && !negated && item.op != "&&" && item.op != "||" {
+				s.fireTrap("ERR")
+				if s.option("errexit") && !s.inTrap && !s.exiting {
+					s.exiting = true
+					s.exitCode = s.lastStatus
+				}
+			}
+		}
+		s.runPendingTraps()
+		if s.exiting {
+			break
+		}
+	}
+	return s.lastStatus
+}
+
+// runWords runs a single pipeline given as unexpanded words and reports
+// whether it was negated with "!".
+func (s *Shell) runWords(words []string, stdin io.Reader, stdout, stderr io.Writer) (status int, negated bool) {
+	if len(words) > 0 && words[0] == "time" {
+		return s.runTimed(words[1:], stdin, stdout, stderr)
+	}
+	if len(words) > 0 && words[0] == "!" {
+		status, _ := s.runWords(words[1:], stdin, stdout, stderr)
+		if status == 0 {
+			return 1, true
+		}
+		return 0, true
+	}
+
+	segments := splitPipeline(words)
+	if len(segments) == 0 {
+		return 0, false
+	}
+
+	if len(segments) > 1 {
+		segments, out, errOut, err := s.expandPipeline(segments, stdout, stderr)
+		if err != nil {
+			fmt.Fprintf(stderr, "gosh: %v\n", err)
+			return 1, false
+		}
+		return s.runPipeline(segments, stdin, out, errOut), false
+	}
+
+	assigns, words := splitAssignments(segments[0])
+	expanded, err := s.expandWords(words)
+	if err != nil {
+		fmt.Fprintf(stderr, "gosh: %v\n", err)
+		return 1, false
+	}
+	parts, redir := extractRedirect(expanded)
 	if len(parts) == 0 {
-		return
+		s.trace(assigns, nil)
+		if err := s.assign(assigns); err != nil {
+			fmt.Fprintf(stderr, "gosh: %v\n", err)
+			return 1, false
+		}
+		return 0, false
+	}
+	out, errOut, err := resolveStreams(redir, s.option("noclobber"), stdout, stderr)
+	if err != nil {
+		fmt.Fprintf(stderr, "gosh: %v\n", err)
+		return 1, false
+	}
+
+	restore, err := s.assignTemporary(assigns)
+	defer restore()
+	if err != nil {
+		fmt.Fprintf(stderr, "gosh: %v\n", err)
+		return 1, false
+	}
+	s.trace(assigns, parts)
+	return s.dispatch(parts, stdin, out, errOut), false
+}
+
+// expandPipeline expands the words of each pipeline stage, traces them for
+// set -x, and resolves the redirections on the last stage against stdout
+// and stderr.
+func (s *Shell) expandPipeline(segments [][]string, stdout, stderr io.Writer) ([][]string, io.Writer, io.Writer, error) {
+	expanded := make([][]string, len(segments))
+	for i, seg := range segments {
+		parts, err := s.expandWords(seg)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		expanded[i] = parts
+	}
+	last, redir := extractRedirect(expanded[len(expanded)-1])
+	expanded[len(expanded)-1] = last
+	for _, parts := range expanded {
+		s.trace(nil, parts)
+	}
+	out, errOut, err := resolveStreams(redir, s.option("noclobber"), stdout, stderr)
+	return expanded, out, errOut, err
+}
+
+// dispatch routes a single command to the appropriate handler and returns
+// its exit status.
+func (s *Shell) dispatch(parts []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(parts) == 0 {
+		return 0
+	}
+	if s.inSubshell && changesProcess(parts) {
+		fmt.Fprintf(stderr, "%s: cannot change the shell's process in a pipeline or background job\n", parts[0])
+		return 1
 	}
 	switch parts[0] {
 	case "echo":
 		runEcho(parts[1:], stdout)
 	case "type":
-		runType(parts[1:], stdout, stderr)
+		return runType(parts[1:], stdout, stderr)
 	case "pwd":
 		runPwd(stdout)
 	case "cd":
-		runCd(parts[1:], stderr)
+		return s.runCd(parts[1:], stderr)
 	case "history":
-		s.runHistory(parts[1:], stdout)
+		return s.runHistory(parts[1:], stdout)
+	case "fc":
+		return s.runFc(parts[1:], stdout, stderr)
+	case "complete":
+		return s.runComplete(parts[1:], stdout, stderr)
+	case "compgen":
+		return s.runCompgen(parts[1:], stdout, stderr)
 	case "exit":
-		os.Exit(0)
+		return s.runExit(parts[1:], stderr)
+	case "exec":
+		return s.runExec(parts[1:], stdout, stderr)
+	case "eval":
+		return s.runEval(parts[1:], stdin, stdout, stderr)
+	case "hook":
+		return s.runHook(parts[1:], stdout, stderr)
+	case "bind":
+		return s.runBind(parts[1:], stdout, stderr)
+	case "trap":
+		return s.runTrap(parts[1:], stdout, stderr)
+	case "kill":
+		return s.runKill(parts[1:], stdout, stderr)
+	case "wait":
+		return s.runWait(parts[1:], stderr)
+	case "jobs":
+		return s.runJobs(parts[1:], stdout, stderr)
+	case "umask":
+		return runUmask(parts[1:], stdout, stderr)
+	case "ulimit":
+		return runUlimit(parts[1:], stdout, stderr)
+	case "set":
+		return s.runSet(parts[1:], stdout, stderr)
+	case "shopt":
+		return s.runShopt(parts[1:], stdout, stderr)
+	case "export":
+		return runExport(parts[1:], stdout, stderr)
+	case "unset":
+		return runUnset(parts[1:], stderr)
+	case "times":
+		return runTimes(stdout, stderr)
 	default:
-		runExternal(parts, stdin, stdout, stderr)
+		status, ps := runExternal(parts, stdin, stdout, stderr)
+		s.recordUsage(parts, ps)
+		return status
+	}
+	return 0
+}
+
+// changesProcess reports whether a builtin would change state that belongs
+// to the whole process, such as the working directory or the environment,
+// which a subshell cannot keep to itself.
+func changesProcess(parts []string) bool {
+	switch parts[0] {
+	case "cd", "exec":
+		return true
+	case "export", "unset", "umask", "ulimit":
+		for _, arg := range parts[1:] {
+			if !strings.HasPrefix(arg, "-") {
+				return true
+			}
+		}
 	}
+	return false
 }
 
-func runExternal(parts []string, stdin io.Reader, stdout, stderr io.Writer) {
+// runExternal runs a command found in PATH and returns its exit status and
+// process state, which is nil if the command could not be started.
+func runExternal(parts []string, stdin io.Reader, stdout, stderr io.Writer) (int, *os.ProcessState) {
 	path := findInPath(parts[0])
 	if path == "" {
 		fmt.Fprintf(stderr, "%s: command not found\n", parts[0])
-		return
+		return 127, nil
 	}
 	cmd := exec.Command(path, parts[1:]...)
 	cmd.Args = parts
 	cmd.Stdin = stdin
 	cmd.Stdout = stdout
 	cmd.Stderr = stderr
-	_ = cmd.Run()
+	err := cmd.Run()
+	return exitStatus(err), cmd.ProcessState
 }
 
-// runPipeline executes a sequence of piped commands.
-func (s *Shell) runPipeline(segments [][]string, stdout, stderr io.Writer) {
+// exitStatus converts the error from running a command into a shell exit
+// status, using 128+n for commands killed by signal n.
+func exitStatus(err error) int {
+	if err == nil {
+		return 0
+	}
+	var ee *exec.ExitError
+	if errors.As(err, &ee) {
+		if ws, ok := ee.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+			return 128 + int(ws.Signal())
+		}
+		return ee.ExitCode()
+	}
+	return 126
+}
+
+// runPipeline executes a sequence of piped commands and returns the status
+// of the last one, or with set -o pipefail, of the rightmost failing one.
+func (s *Shell) runPipeline(segments [][]string, stdin io.Reader, stdout, stderr io.Writer) int {
 	if len(segments) == 1 {
-		s.dispatch(segments[0], os.Stdin, stdout, stderr)
-		return
+		return s.dispatch(segments[0], stdin, stdout, stderr)
+	}
+	p := s.startPipeline(segments, stdin, stdout, stderr, false)
+	if p == nil {
+		return 1
 	}
+	p.wait()
+	for i, cmd := range p.cmds {
+		if cmd != nil {
+			s.recordUsage(segments[i], cmd.ProcessState)
+		}
+	}
+	return pipelineStatus(p.statuses, s.option("pipefail"))
+}
 
+// pipelineStatus picks the exit status of a pipeline from its per-stage
+// statuses: the last stage's, or with pipefail, the rightmost non-zero one.
+func pipelineStatus(statuses []int, pipefail bool) int {
+	if pipefail {
+		for i := len(statuses) - 1; i >= 0; i-- {
+			if statuses[i] != 0 {
+				return statuses[i]
+			}
+		}
+	}
+	return statuses[len(statuses)-1]
+}
+
+// pipeline tracks the stages of a started pipeline until they finish.
+type pipeline struct {
+	cmds     []*exec.Cmd // per stage; nil for builtins and failed stages
+	builtins []chan int  // per stage; nil unless the stage is a builtin
+	statuses []int
+	readers  []*os.File
+}
+
+// pids returns the process IDs of the external stages.
+func (p *pipeline) pids() []int {
+	var pids []int
+	for _, cmd := range p.cmds {
+		if cmd != nil {
+			pids = append(pids, cmd.Process.Pid)
+		}
+	}
+	return pids
+}
+
+// wait blocks until every stage has finished and records the per-stage
+// statuses.
+func (p *pipeline) wait() {
+	for i, ch := range p.builtins {
+		if ch != nil {
+			p.statuses[i] = <-ch
+		}
+	}
+	for i, cmd := range p.cmds {
+		if cmd != nil {
+			p.statuses[i] = exitStatus(cmd.Wait())
+		}
+	}
+	for _, r := range p.readers {
+		r.Close()
+	}
+}
+
+// startPipeline connects the stages with OS pipes and starts them without
+// waiting. When pgrp is set the external stages are placed in a new process
+// group led by the first of them, so the pipeline can be signalled as a job.
+func (s *Shell) startPipeline(segments [][]string, stdin io.Reader, stdout, stderr io.Writer, pgrp bool) *pipeline {
 	n := len(segments)
 
 	readers := make([]*os.File, n-1)
@@ -59,15 +330,22 @@ func (s *Shell) runPipeline(segments [][]string, stdout, stderr io.Writer) {
 		r, w, err := os.Pipe()
 		if err != nil {
 			fmt.Fprintf(stderr, "pipe error: %v\n", err)
-			return
+			return nil
 		}
 		readers[i] = r
 		writers[i] = w
 	}
 
+	p := &pipeline{
+		cmds:     make([]*exec.Cmd, n),
+		builtins: make([]chan int, n),
+		statuses: make([]int, n),
+		readers:  readers,
+	}
+
 	stdinFor := func(i int) io.Reader {
 		if i == 0 {
-			return os.Stdin
+			return stdin
 		}
 		return readers[i-1]
 	}
@@ -79,11 +357,11 @@ func (s *Shell) runPipeline(segments [][]string, stdout, stderr io.Writer) {
 	}
 
 	segIsBuiltin := func(parts []string) bool {
-		return len(parts) > 0 && isBuiltin(parts[0]) && parts[0] != "exit"
+		return len(parts) > 0 && isBuiltin(parts[0])
 	}
 
 	// start external commands
-	var cmds []*exec.Cmd
+	pgid := 0
 	for i, seg := range segments {
 		if segIsBuiltin(seg) {
 			continue
@@ -91,34 +369,45 @@ func (s *Shell) runPipeline(segments [][]string, stdout, stderr io.Writer) {
 		path := findInPath(seg[0])
 		if path == "" {
 			fmt.Fprintf(stderr, "%s: command not found\n", seg[0])
-			return
+			p.statuses[i] = 127
+			continue
 		}
 		cmd := exec.Command(path, seg[1:]...)
 		cmd.Args = seg
 		cmd.Stdin = stdinFor(i)
 		cmd.Stdout = stdoutFor(i)
 		cmd.Stderr = stderr
+		if pgrp {
+			cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true, Pgid: pgid}
+		}
 		if err := cmd.Start(); err != nil {
 			fmt.Fprintf(stderr, "start error: %v\n", err)
-			return
+			p.statuses[i] = 126
+			continue
+		}
+		if pgrp && pgid == 0 {
+			pgid = cmd.Process.Pid
 		}
-		cmds = append(cmds, cmd)
+		p.cmds[i] = cmd
 	}
 
 	// run builtins in goroutines so they can write to pipes concurrently
-	done := make(chan struct{})
-	builtinCount := 0
 	for i, seg := range segments {
 		if !segIsBuiltin(seg) {
 			continue
 		}
-		builtinCount++
+		done := make(chan int, 1)
+		p.builtins[i] = done
+		sub := s.subshell()
 		go func(idx int, parts []string) {
-			s.dispatch(parts, stdinFor(idx), stdoutFor(idx), stderr)
+			status := sub.dispatch(parts, stdinFor(idx), stdoutFor(idx), stderr)
 			if idx < n-1 {
 				writers[idx].Close()
 			}
-			done <- struct{}{}
+			if idx > 0 {
+				readers[idx-1].Close()
+			}
+			done <- status
 		}(i, seg)
 	}
 
@@ -132,13 +421,5 @@ func (s *Shell) runPipeline(segments [][]string, stdout, stderr io.Writer) {
 		}
 	}
 
-	for range builtinCount {
-		<-done
-	}
-	for _, cmd := range cmds {
-		_ = cmd.Wait()
-	}
-	for _, r := range readers {
-		r.Close()
-	}
+	return p
 }
@@ -2,6 +2,8 @@ package shell
 
 import (
 	"bytes"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 )
@@ -71,8 +73,88 @@ func TestRunExternal(t *testing.T) {
 func TestRunPipelineSingleSegment(t *testing.T) {
 	s := &Shell{}
 	var stdout, stderr bytes.Buffer
-	s.runPipeline([][]string{{"echo", "piped"}}, &stdout, &stderr)
+	s.runPipeline([][]string{{"echo", "piped"}}, os.Stdin, &stdout, &stderr)
 	if strings.TrimSpace(stdout.String()) != "piped" {
 		t.Errorf("got %q, want %q", strings.TrimSpace(stdout.String()), "piped")
 	}
 }
+
+func TestExecLine(t *testing.T) {
+	tests := []struct {
+		name       string
+		line       string
+		wantStatus int
+	}{
+		{"true", "true", 0},
+		{"false", "false", 1},
+		{"not found", "nonexistent_cmd_xyz 2> /dev/null", 127},
+		{"exit code", `sh -c "exit 3"`, 3},
+		{"and stops on failure", "false && true", 1},
+		{"or recovers", "false || true", 0},
+		{"sequence takes last", "false; true", 0},
+		{"pipeline takes last stage", "false | true", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Shell{}
+			if got := s.execLine(tt.line); got != tt.wantStatus {
+				t.Errorf("execLine(%q) = %d, want %d", tt.line, got, tt.wantStatus)
+			}
+		})
+	}
+
+	t.Run("status variable", func(t *testing.T) {
+		out := filepath.Join(t.TempDir(), "out")
+		s := &Shell{}
+		s.execLine("false; echo $? > " + out)
+		data, _ := os.ReadFile(out)
+		if string(data) != "1\n" {
+			t.Errorf("got %q, want %q", data, "1\n")
+		}
+	})
+
+	t.Run("exit stops the list", func(t *testing.T) {
+		out := filepath.Join(t.TempDir(), "out")
+		s := &Shell{}
+		s.execLine("exit 5; echo after > " + out)
+		if !s.exiting || s.exitCode != 5 {
+			t.Errorf("exiting = %v, exitCode = %d, want true, 5", s.exiting, s.exitCode)
+		}
+		if _, err := os.Stat(out); err == nil {
+			t.Error("command after exit was run")
+		}
+	})
+}
+
+func TestExitStatusSignaled(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	got, _ := runExternal([]string{"sh", "-c", "kill -TERM $$"}, nil, &stdout, &stderr)
+	if got != 128+15 {
+		t.Errorf("status = %d, want %d", got, 128+15)
+	}
+}
+
+func TestPipelineBuiltinsRunInSubshell(t *testing.T) {
+	dir, _ := os.Getwd()
+	s := &Shell{}
+	if got := s.execLine("true | exit 3"); got != 3 || s.exiting {
+		t.Errorf("true | exit 3 = %d, exiting = %v, want 3, false", got, s.exiting)
+	}
+	s.execLine("set -o pipefail | true")
+	s.execLine("trap 'echo x' ERR &")
+	s.execLine("wait")
+	if s.option("pipefail") || s.traps["ERR"] != "" {
+		t.Errorf("pipeline stages changed the shell: pipefail = %v, traps = %v", s.option("pipefail"), s.traps)
+	}
+
+	var stdout, stderr bytes.Buffer
+	if got := s.runPipeline([][]string{{"cd", "/"}, {"pwd"}}, os.Stdin, &stdout, &stderr); got != 0 {
+		t.Errorf("pipeline status = %d, want 0", got)
+	}
+	if cwd, _ := os.Getwd(); cwd != dir || stdout.String() != dir+"\n" {
+		t.Errorf("cd in a pipeline moved the shell to %s (pwd printed %q)", cwd, stdout.String())
+	}
+	if !strings.Contains(stderr.String(), "cd: cannot change") {
+		t.Errorf("stderr = %q, want a cd error", stderr.String())
+	}
+}
@@ -0,0 +1,298 @@
+package shell
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// fcOptions are the parsed arguments of the fc builtin.
+type fcOptions struct {
+	list       bool   // -l
+	unnumbered bool   // -n
+	reverse    bool   // -r
+	substitute bool   // -s, or -e -
+	editor     string // -e
+	operands   []string
+}
+
+// parseFcArgs parses fc's options. Options may be grouped, and an argument
+// such as -3 is an operand, not an option.
+func parseFcArgs(args []string) (fcOptions, error) {
+	var opts fcOptions
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		if a == "--" {
+			opts.operands = args[i+1:]
+			break
+		}
+		if len(a) < 2 || a[0] != '-' || a[1] >= '0' && a[1] <= '9' {
+			opts.operands = args[i:]
+			break
+		}
+		for j := 1; j < len(a); j++ {
+			switch a[j] {
+			case 'l':
+				opts.list = true
+			case 'n':
+				opts.unnumbered = true
+			case 'r':
+				opts.reverse = true
+			case 's':
+				opts.substitute = true
+			case 'e':
+				switch {
+				case j+1 < len(a):
+					opts.editor = a[j+1:]
+				case i+1 < len(args):
+					i++
+					opts.editor = args[i]
+				default:
+					return opts, fmt.Errorf("-e: option requires an argument")
+				}
+				j = len(a)
+			default:
+				return opts, fmt.Errorf("-%c: invalid option", a[j])
+			}
+		}
+	}
+	if opts.editor == "-" {
+		opts.substitute = true
+	}
+	return opts, nil
+}
+
+// runFc implements the POSIX fc builtin: fc -l lists a range of history
+// entries, fc [-e editor] edits a range in a temporary file and runs the
+// result, and fc -s [old=new] [first] runs an entry again with a
+// substitution.
+func (s *Shell) runFc(args []string, stdout, stderr io.Writer) int {
+	opts, err := parseFcArgs(args)
+	if err != nil {
+		fmt.Fprintf(stderr, "fc: %v\n", err)
+		return 2
+	}
+	n := s.fcHistoryLen()
+	if n == 0 {
+		fmt.Fprintln(stderr, "fc: history is empty")
+		return 1
+	}
+	if opts.substitute {
+		return s.fcSubstitute(opts.operands, n, stdout, stderr)
+	}
+	if len(opts.operands) > 2 {
+		fmt.Fprintln(stderr, "fc: too many arguments")
+		return 2
+	}
+
+	first, last := "-1", ""
+	if opts.list {
+		first, last = "-16", "-1"
+	}
+	if len(opts.operands) > 0 {
+		first = opts.operands[0]
+	}
+	if len(opts.operands) > 1 {
+		last = opts.operands[1]
+	} else if !opts.list {
+		last = first
+	}
+	from, err := s.fcEntry(first, n)
+	if err == nil {
+		var to int
+		if to, err = s.fcEntry(last, n); err == nil {
+			ids := fcRange(from, to, opts.reverse)
+			if opts.list {
+				s.printHistory(stdout, ids, !opts.unnumbered)
+				return 0
+			}
+			return s.fcEdit(ids, opts.editor, stdout, stderr)
+		}
+	}
+	fmt.Fprintf(stderr, "fc: %v\n", err)
+	return 1
+}
+
+// fcHistoryLen returns the number of history entries fc works on: all of
+// them except the fc command itself, if it was recorded.
+func (s *Shell) fcHistoryLen() int {
+	n := len(s.history)
+	if s.running != nil && n > 0 && s.metaAt(n-1) == s.running {
+		n--
+	}
+	return n
+}
+
+// fcEntry resolves an fc operand to an index into the first n history
+// entries. A positive number is an entry number, a negative one counts back
+// from the most recent entry, and anything else selects the most recent
+// entry that starts with it. Numbers out of range select the oldest or
+// newest entry.
+func (s *Shell) fcEntry(arg string, n int) (int, error) {
+	if num, err := strconv.Atoi(arg); err == nil {
+		switch {
+		case num < 0:
+			num += n
+		case num > 0:
+			num--
+		default:
+			num = n - 1
+		}
+		return max(0, min(num, n-1)), nil
+	}
+	for i := n - 1; i >= 0; i-- {
+		if strings.HasPrefix(s.history[i], arg) {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("%s: no command found", arg)
+}
+
+// fcRange returns the indexes from first to last, which are listed newest
+// first if first comes after last, and the other way round with reverse.
+func fcRange(first, last int, reverse bool) []int {
+	if first > last {
+		first, last = last, first
+		reverse = !reverse
+	}
+	var ids []int
+	for i := first; i <= last; i++ {
+		ids = append(ids, i)
+	}
+	if reverse {
+		slices.Reverse(ids)
+	}
+	return ids
+}
+
+// fcSubstitute runs an entry again after replacing the first occurrence of
+// old with new for each old=new operand.
+func (s *Shell) fcSubstitute(operands []string, n int, stdout, stderr io.Writer) int {
+	var subs []string
+	for len(operands) > 0 && strings.Contains(operands[0], "=") {
+		subs = append(subs, operands[0])
+		operands = operands[1:]
+	}
+	if len(operands) > 1 {
+		fmt.Fprintln(stderr, "fc: too many arguments")
+		return 2
+	}
+	first := "-1"
+	if len(operands) == 1 {
+		first = operands[0]
+	}
+	i, err := s.fcEntry(first, n)
+	if err != nil {
+		fmt.Fprintf(stderr, "fc: %v\n", err)
+		return 1
+	}
+	line := s.history[i]
+	for _, sub := range subs {
+		if old, repl, _ := strings.Cut(sub, "="); old != "" {
+			line = strings.Replace(line, old, repl, 1)
+		}
+	}
+	return s.runCommands(line, stdout)
+}
+
+// fcEdit opens the entries ids in an editor and runs the edited commands.
+// The editor is editor, $FCEDIT, $EDITOR or vi, in that order. Nothing is
+// run if the editor fails.
+func (s *Shell) fcEdit(ids []int, editor string, stdout, stderr io.Writer) int {
+	if editor == "" {
+		editor = editorFromEnv("FCEDIT", "EDITOR")
+	}
+	var sb strings.Builder
+	for _, i := range ids {
+		sb.WriteString(s.history[i] + "\n")
+	}
+	text, err := editText(editor, sb.String())
+	if err != nil {
+		fmt.Fprintf(stderr, "fc: %v\n", err)
+		return 1
+	}
+	return s.runCommands(text, stdout)
+}
+
+// editCommandLine opens line in $VISUAL or $EDITOR and runs the result. It
+// backs the Ctrl-X Ctrl-E binding.
+func (s *Shell) editCommandLine(line string) {
+	text, err := editText(editorFromEnv("VISUAL", "EDITOR"), line+"\n")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "gosh: %v\n", err)
+		return
+	}
+	s.lineNo++
+	s.runCommands(text, os.Stdout)
+}
+
+// editorFromEnv returns the first of the named variables that is set, or
+// vi.
+func editorFromEnv(names ...string) string {
+	for _, name := range names {
+		if v := os.Getenv(name); v != "" {
+			return v
+		}
+	}
+	return "vi"
+}
+
+// editText writes text to a temporary file, opens it in editor, which may
+// include arguments, and returns what the file holds when the editor exits.
+func editText(editor, text string) (string, error) {
+	f, err := os.CreateTemp("", "gosh-edit-*.sh")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(text)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", err
+	}
+
+	parts := append(strings.Fields(editor), f.Name())
+	if status, _ := runExternal(parts, os.Stdin, os.Stdout, os.Stderr); status != 0 {
+		return "", fmt.Errorf("%s: editor exited with status %d", parts[0], status)
+	}
+	data, err := os.ReadFile(f.Name())
+	return string(data), err
+}
+
+// runCommands echoes and runs each line of text, and returns the status of
+// the last one. A command continued over several lines is run as one, and
+// one left incomplete at the end is reported and not run. Like bash, it
+// replaces the fc command in the history with the commands it runs.
+func (s *Shell) runCommands(text string, stdout io.Writer) int {
+	if n := len(s.history); s.running != nil && n > 0 && s.metaAt(n-1) == s.running {
+		s.deleteHistory(n-1, n)
+	}
+	outer := s.running
+	defer func() { s.running = outer }()
+
+	status := 0
+	lines, rest := joinLines(text)
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		fmt.Fprintln(stdout, line)
+		s.running = s.recordHistory(line, false)
+		status = s.runLine(line)
+		s.running.finish(status)
+		if s.exiting {
+			return status
+		}
+	}
+	if strings.TrimSpace(rest) != "" {
+		fmt.Fprintln(os.Stderr, "gosh: unexpected end of input")
+		status = 2
+	}
+	return status
+}
@@ -0,0 +1,135 @@
+package shell
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseFcArgs(t *testing.T) {
+	tests := []struct {
+		args []string
+		want fcOptions
+		err  bool
+	}{
+		{nil, fcOptions{}, false},
+		{[]string{"-lnr", "-5"}, fcOptions{list: true, unnumbered: true, reverse: true, operands: []string{"-5"}}, false},
+		{[]string{"-e", "vim -u NONE", "3", "5"}, fcOptions{editor: "vim -u NONE", operands: []string{"3", "5"}}, false},
+		{[]string{"-evi"}, fcOptions{editor: "vi"}, false},
+		{[]string{"-e", "-"}, fcOptions{editor: "-", substitute: true}, false},
+		{[]string{"-s", "a=b", "--", "-x"}, fcOptions{substitute: true, operands: []string{"a=b", "--", "-x"}}, false},
+		{[]string{"-l", "--", "-x"}, fcOptions{list: true, operands: []string{"-x"}}, false},
+		{[]string{"-e"}, fcOptions{}, true},
+		{[]string{"-q"}, fcOptions{}, true},
+	}
+	for _, tt := range tests {
+		got, err := parseFcArgs(tt.args)
+		if (err != nil) != tt.err {
+			t.Errorf("parseFcArgs(%q) error = %v, want error %v", tt.args, err, tt.err)
+			continue
+		}
+		if !tt.err && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseFcArgs(%q) = %+v, want %+v", tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestFcList(t *testing.T) {
+	s := &Shell{history: []string{"ls", "make", "make test", "git status", "pwd"}}
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"-l"}, "    1  ls\n    2  make\n    3  make test\n    4  git status\n    5  pwd\n"},
+		{[]string{"-l", "2", "3"}, "    2  make\n    3  make test\n"},
+		{[]string{"-l", "-2"}, "    4  git status\n    5  pwd\n"},
+		{[]string{"-lr", "-2"}, "    5  pwd\n    4  git status\n"},
+		{[]string{"-l", "3", "2"}, "    3  make test\n    2  make\n"},
+		{[]string{"-ln", "make", "git"}, "make test\ngit status\n"},
+		{[]string{"-l", "0", "99"}, "    5  pwd\n"},
+	}
+	for _, tt := range tests {
+		var stdout, stderr bytes.Buffer
+		if status := s.runFc(tt.args, &stdout, &stderr); status != 0 {
+			t.Errorf("fc %q = %d, stderr %q", tt.args, status, stderr.String())
+		}
+		if stdout.String() != tt.want {
+			t.Errorf("fc %q printed %q, want %q", tt.args, stdout.String(), tt.want)
+		}
+	}
+
+	var stdout, stderr bytes.Buffer
+	if status := s.runFc([]string{"-l", "nosuch"}, &stdout, &stderr); status != 1 {
+		t.Errorf("fc -l nosuch = %d, want 1", status)
+	}
+}
+
+func TestFcSkipsItself(t *testing.T) {
+	s := &Shell{history: []string{"ls", "pwd"}}
+	s.running = s.addHistory("fc -l -1")
+	var stdout, stderr bytes.Buffer
+	s.runFc([]string{"-l", "-1"}, &stdout, &stderr)
+	if stdout.String() != "    2  pwd\n" {
+		t.Errorf("fc -l -1 printed %q, want the entry before fc", stdout.String())
+	}
+}
+
+func TestFcSubstitute(t *testing.T) {
+	s := &Shell{history: []string{"false", "cd /"}}
+	s.running = s.addHistory("fc -s false=true f")
+
+	var stdout, stderr bytes.Buffer
+	if status := s.runFc([]string{"-s", "false=true", "f"}, &stdout, &stderr); status != 0 {
+		t.Fatalf("fc -s = %d, stderr %q", status, stderr.String())
+	}
+	if stdout.String() != "true\n" {
+		t.Errorf("fc -s echoed %q", stdout.String())
+	}
+	if want := []string{"false", "cd /", "true"}; !reflect.DeepEqual(s.history, want) {
+		t.Errorf("history = %q, want %q (fc replaced by the command it ran)", s.history, want)
+	}
+	if m := s.metaAt(2); m == nil || !m.finished || m.status != 0 {
+		t.Errorf("meta of the rerun command = %+v", m)
+	}
+}
+
+func TestFcEdit(t *testing.T) {
+	s := &Shell{history: []string{"false", "false"}}
+	var stdout, stderr bytes.Buffer
+	status := s.runFc([]string{"-e", "sed -i s/false/true/", "1", "2"}, &stdout, &stderr)
+	if status != 0 || stdout.String() != "true\ntrue\n" {
+		t.Errorf("fc -e = %d, printed %q, stderr %q", status, stdout.String(), stderr.String())
+	}
+	if len(s.history) != 4 || s.history[3] != "true" {
+		t.Errorf("history = %q", s.history)
+	}
+
+	stdout.Reset()
+	t.Setenv("FCEDIT", "false")
+	if status := s.runFc(nil, &stdout, &stderr); status != 1 || stdout.Len() != 0 {
+		t.Errorf("fc with a failing editor = %d, printed %q", status, stdout.String())
+	}
+}
+
+func TestEditCommandLine(t *testing.T) {
+	t.Setenv("VISUAL", "sed -i s/false/true/")
+	s := &Shell{}
+	s.editCommandLine("false")
+	if s.lastStatus != 0 || len(s.history) != 1 || s.history[0] != "true" {
+		t.Errorf("status %d, history %q", s.lastStatus, s.history)
+	}
+}
+
+func TestRunCommandsContinued(t *testing.T) {
+	s := &Shell{}
+	var stdout bytes.Buffer
+	status := s.runCommands("true &&\n  false\ntrue \\\n  x\necho 'open\n", &stdout)
+	want := []string{"true &&\n  false", "true \\\n  x"}
+	if status != 2 || !reflect.DeepEqual(s.history, want) {
+		t.Errorf("runCommands = %d with history %q, want 2 and %q", status, s.history, want)
+	}
+	if !strings.HasPrefix(stdout.String(), "true &&\n  false\n") {
+		t.Errorf("echoed %q", stdout.String())
+	}
+}
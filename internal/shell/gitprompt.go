@@ -0,0 +1,219 @@
+package shell
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// gitDirtyBudget is how long a prompt waits for git status before it shows
+// the last known dirty flags instead.
+const gitDirtyBudget = 100 * time.Millisecond
+
+// gitDirtyTimeout bounds a git status left running in the background.
+const gitDirtyTimeout = 10 * time.Second
+
+// gitDirty holds the dirty flags of one repository, updated by git status
+// runs that may finish after the prompt stopped waiting for them.
+type gitDirty struct {
+	mu      sync.Mutex
+	flags   string
+	running chan struct{} // closed when the current run ends; nil if none
+}
+
+// gitPrompt returns the \g segment of the prompt: the branch of the
+// repository containing the working directory and any operation in
+// progress, formatted by GOSH_GIT_FORMAT (default " (%s)"). With
+// GOSH_GIT_DIRTY set, it adds * for unstaged and + for staged changes.
+// Outside a repository it is empty.
+func (s *Shell) gitPrompt() string {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return ""
+	}
+	work, gitDir := findGitDir(cwd)
+	if gitDir == "" {
+		return ""
+	}
+	head, state := gitHead(gitDir)
+	if head == "" {
+		return ""
+	}
+	if os.Getenv("GOSH_GIT_DIRTY") != "" {
+		if flags := s.gitDirtyFlags(work, gitDir); flags != "" {
+			head += " " + flags
+		}
+	}
+	if state != "" {
+		head += "|" + state
+	}
+	format, ok := os.LookupEnv("GOSH_GIT_FORMAT")
+	if !ok {
+		format = " (%s)"
+	}
+	return strings.ReplaceAll(format, "%s", head)
+}
+
+// findGitDir returns the work tree containing dir and its git directory,
+// or empty strings outside a repository. A .git file, as in linked work
+// trees and submodules, points to the git directory.
+func findGitDir(dir string) (work, gitDir string) {
+	for {
+		path := filepath.Join(dir, ".git")
+		if info, err := os.Stat(path); err == nil {
+			if info.IsDir() {
+				return dir, path
+			}
+			data, err := os.ReadFile(path)
+			if target, ok := strings.CutPrefix(strings.TrimSpace(string(data)), "gitdir: "); err == nil && ok {
+				if !filepath.IsAbs(target) {
+					target = filepath.Join(dir, target)
+				}
+				return dir, target
+			}
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", ""
+		}
+		dir = parent
+	}
+}
+
+// gitHead reads the checked-out branch from gitDir, or the abbreviated
+// commit with a "detached:" prefix, and the operation in progress, with
+// the step for rebases, as in git's own prompt.
+func gitHead(gitDir string) (head, state string) {
+	read := func(name string) string {
+		data, _ := os.ReadFile(filepath.Join(gitDir, name))
+		return strings.TrimSpace(string(data))
+	}
+	exists := func(name string) bool {
+		_, err := os.Stat(filepath.Join(gitDir, name))
+		return err == nil
+	}
+
+	ref := read("HEAD")
+	switch {
+	case exists("rebase-merge"):
+		state = "REBASE-m"
+		if exists("rebase-merge/interactive") {
+			state = "REBASE-i"
+		}
+		state += rebaseStep(read("rebase-merge/msgnum"), read("rebase-merge/end"))
+		// HEAD is detached while rebasing; show the branch being rebased.
+		if name := read("rebase-merge/head-name"); name != "" {
+			ref = "ref: " + name
+		}
+	case exists("rebase-apply"):
+		switch {
+		case exists("rebase-apply/rebasing"):
+			state = "REBASE"
+			if name := read("rebase-apply/head-name"); name != "" {
+				ref = "ref: " + name
+			}
+		case exists("rebase-apply/applying"):
+			state = "AM"
+		default:
+			state = "AM/REBASE"
+		}
+		state += rebaseStep(read("rebase-apply/next"), read("rebase-apply/last"))
+	case exists("MERGE_HEAD"):
+		state = "MERGING"
+	case exists("CHERRY_PICK_HEAD"):
+		state = "CHERRY-PICKING"
+	case exists("REVERT_HEAD"):
+		state = "REVERTING"
+	case exists("BISECT_LOG"):
+		state = "BISECTING"
+	}
+
+	if name, ok := strings.CutPrefix(ref, "ref: "); ok {
+		return strings.TrimPrefix(name, "refs/heads/"), state
+	}
+	if len(ref) >= 7 {
+		return "detached:" + ref[:7], state
+	}
+	return "", state
+}
+
+// rebaseStep formats the progress of a rebase, or nothing if it is unknown.
+func rebaseStep(n, total string) string {
+	if n == "" || total == "" {
+		return ""
+	}
+	return " " + n + "/" + total
+}
+
+// gitDirtyFlags returns the dirty flags of the work tree. It starts git
+// status unless a run is still going, and waits for it for at most
+// gitDirtyBudget. A run that takes longer keeps going in the background,
+// and the prompt shows the flags the previous run found; the next prompt
+// shows the new ones.
+func (s *Shell) gitDirtyFlags(work, gitDir string) string {
+	if s.gitDirty == nil {
+		s.gitDirty = make(map[string]*gitDirty)
+	}
+	d := s.gitDirty[gitDir]
+	if d == nil {
+		d = &gitDirty{}
+		s.gitDirty[gitDir] = d
+	}
+
+	d.mu.Lock()
+	if d.running == nil {
+		done := make(chan struct{})
+		d.running = done
+		go func() {
+			flags := gitStatusFlags(work)
+			d.mu.Lock()
+			d.flags, d.running = flags, nil
+			d.mu.Unlock()
+			close(done)
+		}()
+	}
+	done := d.running
+	d.mu.Unlock()
+
+	select {
+	case <-done:
+	case <-time.After(gitDirtyBudget):
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.flags
+}
+
+// gitStatusFlags runs git status in work and returns * if it has unstaged
+// changes to tracked files and + if it has staged ones.
+func gitStatusFlags(work string) string {
+	ctx, cancel := context.WithTimeout(context.Background(), gitDirtyTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "git", "-C", work, "status", "--porcelain", "--untracked-files=no", "--ignore-submodules=dirty")
+	cmd.Env = append(os.Environ(), "GIT_OPTIONAL_LOCKS=0")
+	out, err := cmd.Output()
+	if err != nil {
+		return ""
+	}
+	unstaged, staged := false, false
+	for _, line := range bytes.Split(out, []byte("\n")) {
+		if len(line) < 2 {
+			continue
+		}
+		staged = staged || line[0] != ' '
+		unstaged = unstaged || line[1] != ' '
+	}
+	var flags string
+	if unstaged {
+		flags += "*"
+	}
+	if staged {
+		flags += "+"
+	}
+	return flags
+}
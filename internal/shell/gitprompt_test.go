@@ -0,0 +1,160 @@
+package shell
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// gitRepo makes a directory that looks like a repository to gitHead, with
+// the given files in its .git directory, and changes to a subdirectory.
+func gitRepo(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, data := range files {
+		path := filepath.Join(dir, ".git", name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	os.MkdirAll(filepath.Join(dir, "sub"), 0755)
+	t.Chdir(filepath.Join(dir, "sub"))
+	return dir
+}
+
+func TestGitPrompt(t *testing.T) {
+	t.Setenv("GOSH_GIT_DIRTY", "")
+	os.Unsetenv("GOSH_GIT_FORMAT")
+	s := &Shell{}
+	tests := []struct {
+		files map[string]string
+		want  string
+	}{
+		{map[string]string{"HEAD": "ref: refs/heads/main\n"}, " (main)"},
+		{map[string]string{"HEAD": "0123456789abcdef0123456789abcdef01234567\n"}, " (detached:0123456)"},
+		{map[string]string{"HEAD": "ref: refs/heads/fix\n", "MERGE_HEAD": "x"}, " (fix|MERGING)"},
+		{map[string]string{"HEAD": "ref: refs/heads/b\n", "CHERRY_PICK_HEAD": "x"}, " (b|CHERRY-PICKING)"},
+		{map[string]string{"HEAD": "ref: refs/heads/b\n", "BISECT_LOG": "x"}, " (b|BISECTING)"},
+		{map[string]string{
+			"HEAD":                     "0123456789abcdef\n",
+			"rebase-merge/head-name":   "refs/heads/topic\n",
+			"rebase-merge/interactive": "",
+			"rebase-merge/msgnum":      "2\n",
+			"rebase-merge/end":         "5\n",
+		}, " (topic|REBASE-i 2/5)"},
+		{map[string]string{"HEAD": "0123456789abcdef\n", "rebase-apply/applying": "", "rebase-apply/next": "1", "rebase-apply/last": "3"}, " (detached:0123456|AM 1/3)"},
+	}
+	for _, tt := range tests {
+		gitRepo(t, tt.files)
+		if got := s.gitPrompt(); got != tt.want {
+			t.Errorf("gitPrompt with %v = %q, want %q", tt.files, got, tt.want)
+		}
+	}
+
+	t.Setenv("GOSH_GIT_FORMAT", "[%s]")
+	if got, _ := s.expandPrompt(`\g$ `, time.Now()); got != "[detached:0123456|AM 1/3]$ " {
+		t.Errorf("expandPrompt(\\g) = %q", got)
+	}
+	t.Chdir(t.TempDir())
+	if got := s.gitPrompt(); got != "" {
+		t.Errorf("gitPrompt outside a repository = %q", got)
+	}
+}
+
+func TestFindGitDirFile(t *testing.T) {
+	dir := t.TempDir()
+	work := filepath.Join(dir, "wt")
+	os.MkdirAll(filepath.Join(work, "a"), 0755)
+	os.WriteFile(filepath.Join(work, ".git"), []byte("gitdir: ../main/.git/worktrees/wt\n"), 0644)
+	gotWork, gitDir := findGitDir(filepath.Join(work, "a"))
+	if gotWork != work || gitDir != filepath.Join(dir, "main", ".git", "worktrees", "wt") {
+		t.Errorf("findGitDir = %q, %q", gotWork, gitDir)
+	}
+}
+
+func TestGitDirtyFlags(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	dir := t.TempDir()
+	t.Chdir(dir)
+	t.Setenv("HOME", dir)
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+	git := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-c", "user.name=t", "-c", "user.email=t@t"}, args...)...)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	git("init", "-q", "-b", "main")
+	os.WriteFile("a", []byte("1"), 0644)
+	os.WriteFile("b", []byte("1"), 0644)
+	git("add", "a", "b")
+	git("commit", "-qm", "init")
+
+	t.Setenv("GOSH_GIT_DIRTY", "1")
+	os.Unsetenv("GOSH_GIT_FORMAT")
+	s := &Shell{}
+	// Wait for the result rather than the budget, which a loaded machine
+	// may exceed.
+	flags := func() string {
+		s.gitPrompt()
+		for _, d := range s.gitDirty {
+			for {
+				d.mu.Lock()
+				running := d.running
+				d.mu.Unlock()
+				if running == nil {
+					break
+				}
+				<-running
+			}
+		}
+		return s.gitPrompt()
+	}
+	if got := flags(); got != " (main)" {
+		t.Errorf("clean = %q", got)
+	}
+	os.WriteFile("a", []byte("2"), 0644)
+	os.WriteFile("b", []byte("2"), 0644)
+	git("add", "b")
+	if got := flags(); got != " (main *+)" {
+		t.Errorf("dirty = %q", got)
+	}
+}
+
+func TestGitDirtyFlagsAsync(t *testing.T) {
+	sleep, err := exec.LookPath("sleep")
+	if err != nil {
+		t.Skip("sleep not installed")
+	}
+	dir := gitRepo(t, map[string]string{"HEAD": "ref: refs/heads/main\n"})
+	bin := filepath.Join(dir, "bin")
+	os.MkdirAll(bin, 0755)
+	os.WriteFile(filepath.Join(bin, "git"), []byte("#!/bin/sh\n"+sleep+" 0.3\necho ' M a'\n"), 0755)
+	t.Setenv("PATH", bin)
+
+	s := &Shell{}
+	start := time.Now()
+	if got := s.gitDirtyFlags(dir, filepath.Join(dir, ".git")); got != "" {
+		t.Errorf("flags before git status finished = %q", got)
+	}
+	if elapsed := time.Since(start); elapsed > 250*time.Millisecond {
+		t.Errorf("the prompt waited %v for a slow git status", elapsed)
+	}
+	d := s.gitDirty[filepath.Join(dir, ".git")]
+	d.mu.Lock()
+	running := d.running
+	d.mu.Unlock()
+	if running == nil {
+		t.Fatal("git status is not running in the background")
+	}
+	<-running
+	if got := s.gitDirtyFlags(dir, filepath.Join(dir, ".git")); got != "*" {
+		t.Errorf("flags after the background run = %q, want *", got)
+	}
+}
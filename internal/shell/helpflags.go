@@ -0,0 +1,222 @@
+package shell
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// helpFlag is a flag found in a command's --help output or man page. A
+// name ending in "=" takes its argument after the "=".
+type helpFlag struct {
+	Names       []string `json:"names"`
+	Description string   `json:"description,omitempty"`
+}
+
+// helpCache is what is known about the flags of the binary at Path, as of
+// its modification time ModTime. It is kept in memory and on disk.
+type helpCache struct {
+	Path    string     `json:"path"`
+	ModTime time.Time  `json:"mtime"`
+	Flags   []helpFlag `json:"flags"`
+}
+
+// helpTimeout bounds how long a command's --help or man page may take.
+const helpTimeout = 2 * time.Second
+
+var (
+	overstrike = regexp.MustCompile(".\x08")
+	ansiEscape = regexp.MustCompile("\x1b\\[[0-9;]*[A-Za-z]")
+	flagName   = regexp.MustCompile(`^--?[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+)
+
+// cacheDir returns the directory of gosh's cache files: $XDG_CACHE_HOME/gosh,
+// or ~/.cache/gosh.
+func cacheDir() string {
+	if dir := os.Getenv("XDG_CACHE_HOME"); dir != "" {
+		return filepath.Join(dir, "gosh")
+	}
+	return filepath.Join(os.Getenv("HOME"), ".cache", "gosh")
+}
+
+// helpFlagsFor returns the flags of the command named by the raw word cmd,
+// as found in its --help output or, failing that, its man page. Builtins
+// and commands not in PATH have none. The result is cached in memory and
+// on disk for as long as the binary is unchanged, so each binary is asked
+// at most once.
+func (s *Shell) helpFlagsFor(cmd string) []helpFlag {
+	name := strings.Join(expandWord(cmd, nil), "")
+	if isBuiltin(name) {
+		return nil
+	}
+	path := findInPath(name)
+	if path == "" {
+		return nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil
+	}
+	if c := s.helpCache[path]; c != nil && c.ModTime.Equal(info.ModTime()) {
+		return c.Flags
+	}
+
+	file := helpCacheFile(path)
+	var c helpCache
+	if data, err := os.ReadFile(file); err != nil || json.Unmarshal(data, &c) != nil ||
+		c.Path != path || !c.ModTime.Equal(info.ModTime()) {
+		c = helpCache{Path: path, ModTime: info.ModTime(), Flags: readHelpFlags(path, filepath.Base(name))}
+		if data, err := json.Marshal(c); err == nil && os.MkdirAll(filepath.Dir(file), 0700) == nil {
+			os.WriteFile(file, data, 0600)
+		}
+	}
+	if s.helpCache == nil {
+		s.helpCache = make(map[string]*helpCache)
+	}
+	s.helpCache[path] = &c
+	return c.Flags
+}
+
+// helpCacheFile returns the disk cache file for the binary at path.
+func helpCacheFile(path string) string {
+	sum := sha256.Sum256([]byte(path))
+	return filepath.Join(cacheDir(), "help", hex.EncodeToString(sum[:8])+".json")
+}
+
+// readHelpFlags runs "path --help" and, if that shows no flags, "man name",
+// and parses the flags out of the text.
+func readHelpFlags(path, name string) []helpFlag {
+	if flags := parseHelpFlags(helpOutput(path, "--help")); len(flags) > 0 {
+		return flags
+	}
+	man := findInPath("man")
+	if man == "" {
+		return nil
+	}
+	return parseHelpFlags(manOptions(helpOutput(man, name)))
+}
+
+// helpOutput runs a command without input and with pagers disabled, and
+// returns what it writes to stdout and stderr, where some commands print
+// their help. It is killed after helpTimeout.
+func helpOutput(path string, args ...string) string {
+	ctx, cancel := context.WithTimeout(context.Background(), helpTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, path, args...)
+	cmd.Env = append(os.Environ(), "PAGER=cat", "MANPAGER=cat", "MANWIDTH=100", "NO_COLOR=1")
+	cmd.WaitDelay = helpTimeout
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	cmd.Run()
+	return out.String()
+}
+
+// manOptions returns the OPTIONS section of a formatted man page, or its
+// DESCRIPTION section if it has none, since many pages list their options
+// there. Overstrike and escape sequences are removed first.
+func manOptions(page string) string {
+	page = ansiEscape.ReplaceAllString(overstrike.ReplaceAllString(page, ""), "")
+	sections := make(map[string]string)
+	var name string
+	var body strings.Builder
+	for _, line := range strings.Split(page+"\nEND\n", "\n") {
+		if line != "" && line[0] != ' ' && line[0] != '\t' && line == strings.ToUpper(line) {
+			if name != "" {
+				sections[name] = body.String()
+			}
+			name = strings.TrimSpace(line)
+			body.Reset()
+			continue
+		}
+		body.WriteString(line + "\n")
+	}
+	if options, ok := sections["OPTIONS"]; ok {
+		return options
+	}
+	return sections["DESCRIPTION"]
+}
+
+// parseHelpFlags finds the lines of help text that start, after some
+// indentation, with one or more flags, as in
+//
+//	-a, --all                  do not ignore entries starting with .
+//	    --color[=WHEN]         color the output
+//	-o FILE, --output=FILE
+//	        write to FILE
+//
+// The description follows the flags after two spaces, or is on the next
+// line if it is indented further.
+func parseHelpFlags(text string) []helpFlag {
+	lines := strings.Split(ansiEscape.ReplaceAllString(text, ""), "\n")
+	seen := make(map[string]bool)
+	var flags []helpFlag
+	for i, line := range lines {
+		trimmed := strings.TrimLeft(line, " \t")
+		indent := len(line) - len(trimmed)
+		if !strings.HasPrefix(trimmed, "-") || indent > 24 {
+			continue
+		}
+		head, desc := trimmed, ""
+		if j := strings.Index(trimmed, "  "); j >= 0 {
+			head, desc = trimmed[:j], trimmed[j:]
+		}
+		if j := strings.IndexByte(head, '\t'); j >= 0 {
+			head, desc = head[:j], head[j:]+desc
+		}
+		var f helpFlag
+		for _, field := range strings.FieldsFunc(head, func(r rune) bool {
+			return r == ',' || r == '|' || r == ' ' || r == '/'
+		}) {
+			name, arg := field, ""
+			if j := strings.IndexAny(field, "=["); j >= 0 {
+				name, arg = field[:j], field[j:]
+			}
+			if !flagName.MatchString(name) || seen[name] {
+				continue
+			}
+			seen[name] = true
+			if strings.HasPrefix(arg, "=") && strings.HasPrefix(name, "--") {
+				name += "="
+			}
+			f.Names = append(f.Names, name)
+		}
+		if len(f.Names) == 0 {
+			continue
+		}
+		f.Description = strings.TrimSpace(desc)
+		if f.Description == "" && i+1 < len(lines) {
+			next := strings.TrimLeft(lines[i+1], " \t")
+			if len(lines[i+1])-len(next) > indent && !strings.HasPrefix(next, "-") {
+				f.Description = strings.TrimSpace(next)
+			}
+		}
+		flags = append(flags, f)
+	}
+	return flags
+}
+
+// helpFlagCompletions matches the flags of the command named by ctx's first
+// word. A flag that takes its argument after "=" is not final, so the
+// argument can be typed right after it.
+func (s *Shell) helpFlagCompletions(ctx completionContext) []completion {
+	var matches []completion
+	for _, f := range s.helpFlagsFor(ctx.args[0]) {
+		for _, name := range f.Names {
+			if strings.HasPrefix(name, ctx.value) {
+				m := describedCompletion(name, f.Description, ctx)
+				m.final = !strings.HasSuffix(name, "=")
+				matches = append(matches, m)
+			}
+		}
+	}
+	return matches
+}
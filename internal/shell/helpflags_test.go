@@ -0,0 +1,132 @@
+package shell
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseHelpFlags(t *testing.T) {
+	text := `Usage: tool [OPTION]... [FILE]...
+List things.
+
+  -a, --all                  do not ignore entries starting with .
+      --color[=WHEN]         color the output
+  -I, --ignore=PATTERN       do not list entries matching PATTERN
+  -o FILE, --output FILE
+                             write to FILE
+  -h | --help                show help
+  -a                         listed twice
+Flags:
+  -name string
+    	the name to use
+  -v	verbose
+Not a flag: - or -- alone, or a line far to the right
+                                 -x is not at the start of a line
+`
+	want := []helpFlag{
+		{Names: []string{"-a", "--all"}, Description: "do not ignore entries starting with ."},
+		{Names: []string{"--color"}, Description: "color the output"},
+		{Names: []string{"-I", "--ignore="}, Description: "do not list entries matching PATTERN"},
+		{Names: []string{"-o", "--output"}, Description: "write to FILE"},
+		{Names: []string{"-h", "--help"}, Description: "show help"},
+		{Names: []string{"-name"}, Description: "the name to use"},
+		{Names: []string{"-v"}, Description: "verbose"},
+	}
+	if got := parseHelpFlags(text); !reflect.DeepEqual(got, want) {
+		t.Errorf("parseHelpFlags =\n%q\nwant\n%q", got, want)
+	}
+}
+
+func TestManOptions(t *testing.T) {
+	page := "TOOL(1)          User Commands          TOOL(1)\n\n" +
+		"N\bNA\bAM\bME\bE\n       tool - do things\n\n" +
+		"D\bDE\bES\bSC\bCR\bRI\bIP\bPT\bTI\bIO\bON\bN\n       Does things.\n\n" +
+		"O\bOP\bPT\bTI\bIO\bON\bNS\bS\n       \x1b[1m-q\x1b[0m, \x1b[1m--quiet\x1b[0m\n              say less\n\n" +
+		"SEE ALSO\n       other(1)\n"
+	want := []helpFlag{{Names: []string{"-q", "--quiet"}, Description: "say less"}}
+	if got := parseHelpFlags(manOptions(page)); !reflect.DeepEqual(got, want) {
+		t.Errorf("flags from man page = %q, want %q", got, want)
+	}
+
+	noOptions := "NAME\n       t\nDESCRIPTION\n       -z  zap\n"
+	if got := parseHelpFlags(manOptions(noOptions)); len(got) != 1 || got[0].Names[0] != "-z" {
+		t.Errorf("flags from a page without OPTIONS = %q, want those in DESCRIPTION", got)
+	}
+}
+
+// helpTool installs a command in PATH whose --help lists the given flag.
+func helpTool(t *testing.T, dir, flag string) string {
+	t.Helper()
+	path := filepath.Join(dir, "bin", "helptool")
+	os.MkdirAll(filepath.Dir(path), 0755)
+	script := "#!/bin/sh\n[ \"$1\" = --help ] && echo '  " + flag + "  the description'\n"
+	if err := os.WriteFile(path, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestHelpFlagsCache(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_CACHE_HOME", filepath.Join(dir, "cache"))
+	path := helpTool(t, dir, "--first")
+	t.Setenv("PATH", filepath.Dir(path)+string(os.PathListSeparator)+os.Getenv("PATH"))
+	mtime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	os.Chtimes(path, mtime, mtime)
+
+	s := &Shell{}
+	if flags := s.helpFlagsFor("helptool"); len(flags) != 1 || flags[0].Names[0] != "--first" {
+		t.Fatalf("helpFlagsFor = %q", flags)
+	}
+	if _, err := os.Stat(helpCacheFile(path)); err != nil {
+		t.Fatalf("no disk cache: %v", err)
+	}
+
+	// A new shell reads the disk cache while the binary is unchanged...
+	helpTool(t, dir, "--second")
+	os.Chtimes(path, mtime, mtime)
+	if flags := (&Shell{}).helpFlagsFor("helptool"); len(flags) != 1 || flags[0].Names[0] != "--first" {
+		t.Errorf("helpFlagsFor with an unchanged binary = %q, want the cached flags", flags)
+	}
+	// ...and asks again once it changes.
+	os.Chtimes(path, mtime.Add(time.Minute), mtime.Add(time.Minute))
+	if flags := s.helpFlagsFor("helptool"); len(flags) != 1 || flags[0].Names[0] != "--second" {
+		t.Errorf("helpFlagsFor with a changed binary = %q", flags)
+	}
+
+	if flags := s.helpFlagsFor("history"); flags != nil {
+		t.Errorf("helpFlagsFor(history) = %q, want nil for a builtin", flags)
+	}
+}
+
+func TestCompleteHelpFlags(t *testing.T) {
+	dir := completionDir(t)
+	t.Setenv("XDG_CACHE_HOME", filepath.Join(dir, "cache"))
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(dir, "config"))
+	path := helpTool(t, dir, "-f, --format=FMT")
+	t.Setenv("PATH", filepath.Dir(path)+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	c := &completer{s: &Shell{}}
+	tests := []struct{ input, want string }{
+		{"helptool --fo", "rmat="},
+		{"helptool x -", ""},
+		{"helptool al", "pha.txt "},
+		{"helptool --zz", ""},
+	}
+	for _, tt := range tests {
+		if got := completeOnce(c, tt.input); got != tt.want {
+			t.Errorf("completing %q inserted %q, want %q", tt.input, got, tt.want)
+		}
+	}
+	var displays []string
+	for _, m := range c.complete(parseCompletion("helptool -")) {
+		displays = append(displays, m.display+" -- "+m.description)
+	}
+	want := []string{"--format= -- the description", "-f -- the description"}
+	if !reflect.DeepEqual(displays, want) {
+		t.Errorf("flags listed as %q, want %q", displays, want)
+	}
+}
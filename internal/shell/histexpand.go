@@ -0,0 +1,369 @@
+package shell
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// historyExpander performs csh-style history expansion on one input line.
+type historyExpander struct {
+	history []string
+	line    string
+	pos     int
+	out     strings.Builder
+
+	printOnly bool   // a :p modifier was seen
+	search    string // string of the last !?string? event, for the % designator
+	subOld    string // the last :s substitution, reused by :&
+	subNew    string
+}
+
+// expandHistory expands history references in line against history, the
+// previously entered lines (oldest first, numbered from 1). It supports the
+// event designators !!, !n, !-n, !string, !?string? and !#, the word
+// designators and modifiers that may follow them, and ^old^new quick
+// substitution. It returns the expanded line and whether a :p modifier
+// asked for the line to be printed instead of run. A "!" followed by a
+// blank, "=" or "(", quoted in single quotes, escaped with a backslash, or
+// forming part of $! or ${!name} is left alone.
+func expandHistory(line string, history []string) (string, bool, error) {
+	e := &historyExpander{history: history, line: line}
+	if strings.HasPrefix(line, "^") {
+		if err := e.quickSubstitution(); err != nil {
+			return "", false, err
+		}
+	}
+
+	inSingle, inDouble := false, false
+	for e.pos < len(e.line) {
+		c := e.line[e.pos]
+		switch {
+		case inSingle:
+			inSingle = c != '\''
+			e.out.WriteByte(c)
+		case c == '\'' && !inDouble:
+			inSingle = true
+			e.out.WriteByte(c)
+		case c == '"':
+			inDouble = !inDouble
+			e.out.WriteByte(c)
+		case c == '\\' && e.pos+1 < len(e.line):
+			e.out.WriteString(e.line[e.pos : e.pos+2])
+			e.pos++
+		case c == '!' && e.expandable():
+			if err := e.reference(); err != nil {
+				return "", false, err
+			}
+			continue
+		default:
+			e.out.WriteByte(c)
+		}
+		e.pos++
+	}
+	return e.out.String(), e.printOnly, nil
+}
+
+// expandable reports whether the "!" at the current position starts a
+// history reference.
+func (e *historyExpander) expandable() bool {
+	if e.pos+1 >= len(e.line) {
+		return false
+	}
+	switch e.line[e.pos+1] {
+	case ' ', '\t', '\n', '=', '(', '"':
+		return false
+	}
+	if e.pos > 0 && e.line[e.pos-1] == '$' {
+		return false
+	}
+	if e.pos > 1 && e.line[e.pos-2:e.pos] == "${" {
+		return false
+	}
+	return true
+}
+
+// quickSubstitution rewrites a leading ^old^new^ into the previous command
+// with the first occurrence of old replaced by new.
+func (e *historyExpander) quickSubstitution() error {
+	e.pos = 1
+	old := e.delimited('^')
+	repl := e.delimited('^')
+	if len(e.history) == 0 {
+		return fmt.Errorf("!!: event not found")
+	}
+	prev := e.history[len(e.history)-1]
+	if old == "" || !strings.Contains(prev, old) {
+		return fmt.Errorf("^%s^%s: substitution failed", old, repl)
+	}
+	e.subOld, e.subNew = old, repl
+	e.out.WriteString(strings.Replace(prev, old, repl, 1))
+	return nil
+}
+
+// delimited reads text up to the next unescaped delim or the end of the
+// line, consuming the delimiter.
+func (e *historyExpander) delimited(delim byte) string {
+	var sb strings.Builder
+	for e.pos < len(e.line) {
+		c := e.line[e.pos]
+		e.pos++
+		if c == delim {
+			break
+		}
+		if c == '\\' && e.pos < len(e.line) && e.line[e.pos] == delim {
+			c = delim
+			e.pos++
+		}
+		sb.WriteByte(c)
+	}
+	return sb.String()
+}
+
+// reference expands the history reference starting at the "!" at e.pos and
+// leaves e.pos after it.
+func (e *historyExpander) reference() error {
+	e.pos++
+	event, explicitEnd, err := e.event()
+	if err != nil {
+		return err
+	}
+
+	text := event
+	if e.pos < len(e.line) {
+		c := e.line[e.pos]
+		colon := c == ':' && e.pos+1 < len(e.line) && strings.IndexByte("0123456789^$*-%", e.line[e.pos+1]) >= 0
+		if colon || explicitEnd && strings.IndexByte("^$*-%", c) >= 0 {
+			if colon {
+				e.pos++
+			}
+			text, err = e.words(splitWords(event))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	text, err = e.modifiers(text)
+	if err != nil {
+		return err
+	}
+	e.out.WriteString(text)
+	return nil
+}
+
+// event parses an event designator after "!" and returns the selected
+// line. explicitEnd reports whether the designator has a fixed end, so that
+// a following word designator may omit its colon.
+func (e *historyExpander) event() (string, bool, error) {
+	start := e.pos - 1
+	c := e.line[e.pos]
+	switch {
+	case c == '!':
+		e.pos++
+		return e.relative(1, start)
+	case c == '#':
+		e.pos++
+		return e.out.String(), true, nil
+	case strings.IndexByte("^$*:", c) >= 0:
+		return e.relative(1, start)
+	case c == '-' || isDigit(c):
+		end := e.pos + 1
+		for end < len(e.line) && isDigit(e.line[end]) {
+			end++
+		}
+		n, err := strconv.Atoi(e.line[e.pos:end])
+		if err != nil {
+			return "", false, fmt.Errorf("%s: event not found", e.line[start:end])
+		}
+		e.pos = end
+		if n < 0 {
+			return e.relative(-n, start)
+		}
+		if n == 0 || n > len(e.history) {
+			return "", false, fmt.Errorf("%s: event not found", e.line[start:end])
+		}
+		return e.history[n-1], true, nil
+	case c == '?':
+		e.pos++
+		end := strings.IndexByte(e.line[e.pos:], '?')
+		var str string
+		if end < 0 {
+			str = e.line[e.pos:]
+			e.pos = len(e.line)
+		} else {
+			str = e.line[e.pos : e.pos+end]
+			e.pos += end + 1
+		}
+		e.search = str
+		for i := len(e.history) - 1; i >= 0; i-- {
+			if str != "" && strings.Contains(e.history[i], str) {
+				return e.history[i], true, nil
+			}
+		}
+		return "", false, fmt.Errorf("%s: event not found", e.line[start:e.pos])
+	default:
+		end := e.pos
+		for end < len(e.line) && strings.IndexByte(" \t\n:;&|\"'", e.line[end]) < 0 {
+			end++
+		}
+		prefix := e.line[e.pos:end]
+		e.pos = end
+		for i := len(e.history) - 1; i >= 0; i-- {
+			if strings.HasPrefix(e.history[i], prefix) {
+				return e.history[i], false, nil
+			}
+		}
+		return "", false, fmt.Errorf("%s: event not found", e.line[start:end])
+	}
+}
+
+// relative returns the nth previous history line.
+func (e *historyExpander) relative(n, start int) (string, bool, error) {
+	if n == 0 || n > len(e.history) {
+		return "", false, fmt.Errorf("%s: event not found", e.line[start:e.pos])
+	}
+	return e.history[len(e.history)-n], true, nil
+}
+
+// words parses a word designator and returns the selected words of the
+// event joined by spaces. Words are numbered from 0, the command name.
+func (e *historyExpander) words(words []string) (string, error) {
+	start := e.pos
+	last := len(words) - 1
+	bad := func() (string, error) {
+		return "", fmt.Errorf("%s: bad word specifier", e.line[start:e.pos])
+	}
+
+	// number parses a single word index, or returns -1 if there is none.
+	number := func() int {
+		if e.pos >= len(e.line) {
+			return -1
+		}
+		switch c := e.line[e.pos]; {
+		case c == '^':
+			e.pos++
+			return 1
+		case c == '$':
+			e.pos++
+			return last
+		case c == '%':
+			e.pos++
+			for i, w := range words {
+				if e.search != "" && strings.Contains(w, e.search) {
+					return i
+				}
+			}
+			return len(words)
+		case isDigit(c):
+			end := e.pos
+			for end < len(e.line) && isDigit(e.line[end]) {
+				end++
+			}
+			n, _ := strconv.Atoi(e.line[e.pos:end])
+			e.pos = end
+			return n
+		}
+		return -1
+	}
+
+	if e.line[e.pos] == '*' {
+		e.pos++
+		if last < 1 {
+			return "", nil
+		}
+		return strings.Join(words[1:], " "), nil
+	}
+	from := number()
+	to := from
+	if from < 0 {
+		from = 0
+	}
+	if e.pos < len(e.line) {
+		switch e.line[e.pos] {
+		case '*':
+			e.pos++
+			to = last
+		case '-':
+			e.pos++
+			to = number()
+			if to < 0 {
+				to = last - 1
+			}
+		}
+	}
+	if to < 0 || from > to || to > last {
+		return bad()
+	}
+	return strings.Join(words[from:to+1], " "), nil
+}
+
+// modifiers applies the :h, :t, :r, :e, :p, :s/old/new/, :& and :g
+// modifiers that follow a reference to text.
+func (e *historyExpander) modifiers(text string) (string, error) {
+	for e.pos+1 < len(e.line) && e.line[e.pos] == ':' {
+		start := e.pos
+		e.pos++
+		global := false
+		if e.line[e.pos] == 'g' || e.line[e.pos] == 'a' {
+			global = true
+			e.pos++
+			if e.pos >= len(e.line) {
+				return "", fmt.Errorf("%s: unrecognized history modifier", e.line[start:])
+			}
+		}
+		c := e.line[e.pos]
+		e.pos++
+		switch c {
+		case 'h':
+			if i := strings.LastIndexByte(text, '/'); i > 0 {
+				text = text[:i]
+			} else if i == 0 {
+				text = "/"
+			}
+		case 't':
+			text = text[strings.LastIndexByte(text, '/')+1:]
+		case 'r':
+			if ext := filepath.Ext(text); ext != "" && !strings.Contains(ext, "/") {
+				text = strings.TrimSuffix(text, ext)
+			}
+		case 'e':
+			ext := filepath.Ext(text)
+			if strings.Contains(ext, "/") {
+				ext = ""
+			}
+			text = ext
+		case 'p':
+			e.printOnly = true
+		case 's', '&':
+			if c == 's' {
+				if e.pos >= len(e.line) {
+					return "", fmt.Errorf("%s: substitution failed", e.line[start:])
+				}
+				delim := e.line[e.pos]
+				e.pos++
+				old := e.delimited(delim)
+				repl := e.delimited(delim)
+				if old == "" {
+					old = e.search
+				}
+				e.subOld = old
+				e.subNew = strings.ReplaceAll(repl, `\&`, "\x00")
+				e.subNew = strings.ReplaceAll(e.subNew, "&", old)
+				e.subNew = strings.ReplaceAll(e.subNew, "\x00", "&")
+			}
+			if e.subOld == "" || !strings.Contains(text, e.subOld) {
+				return "", fmt.Errorf("%s: substitution failed", e.line[start:e.pos])
+			}
+			n := 1
+			if global {
+				n = -1
+			}
+			text = strings.Replace(text, e.subOld, e.subNew, n)
+		default:
+			return "", fmt.Errorf("%s: unrecognized history modifier", e.line[start:e.pos])
+		}
+	}
+	return text, nil
+}
@@ -0,0 +1,138 @@
+package shell
+
+import (
+	"bytes"
+	"io"
+	"slices"
+	"testing"
+)
+
+func TestExpandHistory(t *testing.T) {
+	history := []string{
+		"cd /usr/local/src",
+		"tar xzf archive.tar.gz",
+		"grep -n foo main.go util.go",
+		"echo hello world",
+	}
+	tests := []struct {
+		line string
+		want string
+	}{
+		{"!!", "echo hello world"},
+		{"sudo !!", "sudo echo hello world"},
+		{"!1", "cd /usr/local/src"},
+		{"!-2", "grep -n foo main.go util.go"},
+		{"!ta", "tar xzf archive.tar.gz"},
+		{"!?foo?", "grep -n foo main.go util.go"},
+		{"!?foo? bar", "grep -n foo main.go util.go bar"},
+		{"ls !$", "ls world"},
+		{"ls !^", "ls hello"},
+		{"printf !*", "printf hello world"},
+		{"!grep:0", "grep"},
+		{"!grep:2-3", "foo main.go"},
+		{"!grep:3*", "main.go util.go"},
+		{"!grep:2-", "foo main.go"},
+		{"!grep:-1", "grep -n"},
+		{"!?util?:%", "util.go"},
+		{"cd !1:$:h", "cd /usr/local"},
+		{"echo !1:$:t", "echo src"},
+		{"echo !2:$:r", "echo archive.tar"},
+		{"echo !2:$:e", "echo .gz"},
+		{"!!:s/hello/bye/", "echo bye world"},
+		{"!!:s/o/0/:&", "ech0 hell0 world"},
+		{"!!:gs/o/0/", "ech0 hell0 w0rld"},
+		{"!!:s/hello/[&]/", "echo [hello] world"},
+		{"^hello^bye", "echo bye world"},
+		{"^hello^bye^ again", "echo bye world again"},
+		{"echo a !#", "echo a echo a "},
+		{"echo '!!'", "echo '!!'"},
+		{`echo \!!`, `echo \!!`},
+		{`echo "!!"`, `echo "echo hello world"`},
+		{"echo $! ${!x}", "echo $! ${!x}"},
+		{"[ a != b ]", "[ a != b ]"},
+		{"! false", "! false"},
+		{"echo wow!", "echo wow!"},
+	}
+	for _, tt := range tests {
+		got, printOnly, err := expandHistory(tt.line, history)
+		if err != nil {
+			t.Errorf("expandHistory(%q) error: %v", tt.line, err)
+			continue
+		}
+		if got != tt.want || printOnly {
+			t.Errorf("expandHistory(%q) = %q, %v; want %q, false", tt.line, got, printOnly, tt.want)
+		}
+	}
+}
+
+func TestExpandHistoryPrint(t *testing.T) {
+	got, printOnly, err := expandHistory("!!:p", []string{"make test"})
+	if err != nil || got != "make test" || !printOnly {
+		t.Errorf("expandHistory(!!:p) = %q, %v, %v; want %q, true, nil", got, printOnly, err, "make test")
+	}
+}
+
+func TestExpandHistoryErrors(t *testing.T) {
+	history := []string{"echo one two"}
+	tests := []struct {
+		line string
+		want string
+	}{
+		{"!5", "!5: event not found"},
+		{"!-3", "!-3: event not found"},
+		{"!nope", "!nope: event not found"},
+		{"!?zzz?", "!?zzz?: event not found"},
+		{"!!:5", "5: bad word specifier"},
+		{"!!:s/x/y/", ":s/x/y/: substitution failed"},
+		{"^x^y", "^x^y: substitution failed"},
+		{"!!:z", ":z: unrecognized history modifier"},
+	}
+	for _, tt := range tests {
+		_, _, err := expandHistory(tt.line, history)
+		if err == nil || err.Error() != tt.want {
+			t.Errorf("expandHistory(%q) error = %v, want %q", tt.line, err, tt.want)
+		}
+	}
+
+	if _, _, err := expandHistory("!!", nil); err == nil {
+		t.Error("expandHistory(!!) with empty history succeeded")
+	}
+}
+
+func TestExpandHistoryLineStoresExpanded(t *testing.T) {
+	s := &Shell{history: []string{"echo hi"}}
+	var out bytes.Buffer
+	line, ok := s.expandHistoryLine("!!", &out, io.Discard)
+	if !ok || line != "echo hi" {
+		t.Fatalf("expandHistoryLine(!!) = %q, %v", line, ok)
+	}
+	if out.String() != "echo hi\n" {
+		t.Errorf("echoed %q, want the expanded line", out.String())
+	}
+	s.recordHistory(line, false)
+	if want := []string{"echo hi", "echo hi"}; !slices.Equal(s.history, want) {
+		t.Errorf("history = %q, want %q", s.history, want)
+	}
+
+	out.Reset()
+	line, ok = s.expandHistoryLine("!!:s/hi/there/:p", &out, io.Discard)
+	if ok || line != "" {
+		t.Errorf("expandHistoryLine(!!:s/hi/there/:p) = %q, %v; want not run", line, ok)
+	}
+	if out.String() != "echo there\n" {
+		t.Errorf("echoed %q, want the expanded line", out.String())
+	}
+	if got := s.history[len(s.history)-1]; got != "echo there" {
+		t.Errorf("last history entry = %q, want the expanded line", got)
+	}
+
+	line, ok = s.expandHistoryLine("!e again", io.Discard, io.Discard)
+	if !ok || line != "echo there again" {
+		t.Errorf("expandHistoryLine(!e again) = %q, %v", line, ok)
+	}
+
+	var stderr bytes.Buffer
+	if _, ok := s.expandHistoryLine("!nosuch", io.Discard, &stderr); ok || stderr.Len() == 0 {
+		t.Errorf("a failed expansion ran (%v) or printed no error", ok)
+	}
+}
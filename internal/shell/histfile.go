@@ -0,0 +1,306 @@
+package shell
+
+import (
+	"encoding/json"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// historyRecord is the JSON form of a history entry, used for one line of a
+// structured history file and for history --json.
+type historyRecord struct {
+	ID       int       `json:"id,omitempty"`
+	Cmd      string    `json:"cmd"`
+	Start    time.Time `json:"start,omitzero"`
+	Duration float64   `json:"duration,omitempty"` // seconds
+	Cwd      string    `json:"cwd,omitempty"`
+	Status   *int      `json:"status,omitempty"`
+	Host     string    `json:"host,omitempty"`
+	Session  string    `json:"session,omitempty"`
+}
+
+func newHistoryRecord(cmd string, m *historyMeta) historyRecord {
+	r := historyRecord{Cmd: cmd}
+	if m == nil {
+		return r
+	}
+	r.Start = m.start.Round(time.Millisecond)
+	r.Cwd = m.cwd
+	r.Host = m.host
+	r.Session = m.session
+	if m.finished {
+		r.Duration = math.Round(m.duration.Seconds()*1000) / 1000
+		status := m.status
+		r.Status = &status
+	}
+	return r
+}
+
+// meta converts the record back to entry metadata, or nil if it has none.
+func (r historyRecord) meta() *historyMeta {
+	if r.Start.IsZero() {
+		return nil
+	}
+	m := &historyMeta{
+		start:    r.Start.Local(),
+		duration: time.Duration(r.Duration * float64(time.Second)),
+		cwd:      r.Cwd,
+		host:     r.Host,
+		session:  r.Session,
+	}
+	if r.Status != nil {
+		m.status = *r.Status
+		m.finished = true
+	}
+	return m
+}
+
+// parseHistory reads the contents of a history file. Each line is either a
+// JSON record, a bash "#<epoch>" timestamp for the line after it, or a
+// plain command, and the kinds may be mixed. It returns the commands and
+// their metadata, which is nil for plain lines without a timestamp.
+func parseHistory(data string) ([]string, []*historyMeta) {
+	var lines []string
+	var metas []*historyMeta
+	var stamp *historyMeta
+	for _, line := range strings.Split(strings.TrimRight(data, "\n"), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "{") {
+			var r historyRecord
+			if json.Unmarshal([]byte(line), &r) == nil && r.Cmd != "" {
+				lines = append(lines, r.Cmd)
+				metas = append(metas, r.meta())
+				stamp = nil
+				continue
+			}
+		}
+		if len(line) > 1 && line[0] == '#' {
+			if secs, err := strconv.ParseInt(line[1:], 10, 64); err == nil {
+				stamp = &historyMeta{start: time.Unix(secs, 0)}
+				continue
+			}
+		}
+		lines = append(lines, line)
+		metas = append(metas, stamp)
+		stamp = nil
+	}
+	return lines, metas
+}
+
+// formatHistoryEntry renders one entry for a history file: a JSON record
+// when structured is set, otherwise the plain command, preceded by a bash
+// timestamp line when stamped is set and the start time is known. A command
+// of several lines is always written as a JSON record, since a plain line
+// cannot hold it and other shells' files give no way to mark one.
+func formatHistoryEntry(cmd string, m *historyMeta, structured, stamped bool) string {
+	if structured || strings.Contains(cmd, "\n") {
+		b, err := json.Marshal(newHistoryRecord(cmd, m))
+		if err == nil {
+			return string(b) + "\n"
+		}
+	}
+	if stamped && m != nil {
+		return "#" + strconv.FormatInt(m.start.Unix(), 10) + "\n" + cmd + "\n"
+	}
+	return cmd + "\n"
+}
+
+// readHistory appends the entries of a history file to the history list and
+// marks them all as already saved.
+func (s *Shell) readHistory(data string) {
+	lines, metas := parseHistory(data)
+	for i, line := range lines {
+		s.appendHistory(line, metas[i])
+	}
+	s.trimHistory()
+	s.historyOffset = len(s.history)
+}
+
+// formatHistory renders entries [from, to) for a history file, in the
+// structured format with shopt -s histjson, or as plain lines with bash
+// timestamp lines when HISTTIMEFORMAT is set. Every history writer goes
+// through here, so this is where secrets are redacted.
+func (s *Shell) formatHistory(from, to int) string {
+	structured := s.shopt("histjson")
+	_, stamped := os.LookupEnv("HISTTIMEFORMAT")
+	var sb strings.Builder
+	for i := from; i < to; i++ {
+		if line, ok := s.historyText(i); ok {
+			sb.WriteString(formatHistoryEntry(line, s.metaAt(i), structured, stamped))
+		}
+	}
+	return sb.String()
+}
+
+// splitHistoryEntries divides the contents of a history file into the raw
+// text of each entry, keeping a bash timestamp line with the command after
+// it. Blank lines are dropped.
+func splitHistoryEntries(data string) []string {
+	var entries []string
+	stamp := ""
+	for _, line := range strings.Split(data, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if len(line) > 1 && line[0] == '#' {
+			if _, err := strconv.ParseInt(line[1:], 10, 64); err == nil {
+				stamp = line + "\n"
+				continue
+			}
+		}
+		entries = append(entries, stamp+line+"\n")
+		stamp = ""
+	}
+	return entries
+}
+
+// openLockedHistory opens the history file at path for reading and
+// appending, creating it if needed, and takes a flock lock on it (LOCK_SH
+// or LOCK_EX). Rewrites replace the file by renaming a new one over it, so
+// if the locked file is no longer the one at path it is reopened.
+func openLockedHistory(path string, how int) (*os.File, error) {
+	for {
+		f, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			return nil, err
+		}
+		if err := syscall.Flock(int(f.Fd()), how); err != nil {
+			f.Close()
+			return nil, err
+		}
+		locked, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		if current, err := os.Stat(path); err == nil && os.SameFile(locked, current) {
+			return f, nil
+		}
+		f.Close()
+	}
+}
+
+// readLockedHistory reads the history file at path under a shared lock,
+// failing if it does not exist.
+func readLockedHistory(path string) (string, os.FileInfo, error) {
+	if _, err := os.Stat(path); err != nil {
+		return "", nil, err
+	}
+	f, err := openLockedHistory(path, syscall.LOCK_SH)
+	if err != nil {
+		return "", nil, err
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return "", nil, err
+	}
+	info, err := f.Stat()
+	return string(data), info, err
+}
+
+// rewriteHistoryFile atomically replaces the file at path with data. The
+// data is written and synced to a temporary file in the same directory,
+// which is then renamed over path, so a crash leaves either the old or the
+// new file, never a partial one. The caller holds the lock on path.
+func rewriteHistoryFile(path, data string, mode os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(mode.Perm()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// syncHistoryFile brings HISTFILE and the history list up to date under an
+// exclusive lock. With imports set it first reads the entries that other
+// sessions appended since this shell last read the file; it then appends
+// this shell's unsaved entries; with trim set it finally cuts the file to
+// HISTFILESIZE entries.
+func (s *Shell) syncHistoryFile(imports, trim bool) error {
+	path := os.Getenv("HISTFILE")
+	if path == "" {
+		return nil
+	}
+	f, err := openLockedHistory(path, syscall.LOCK_EX)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	var imported string
+	// If another session rewrote the file since it was last read, there
+	// is no telling which of its entries are new, so none are imported.
+	if imports && (s.histFile == nil || os.SameFile(info, s.histFile) && info.Size() >= s.histFileOffset) {
+		offset := int64(0)
+		if s.histFile != nil {
+			offset = s.histFileOffset
+		}
+		buf := make([]byte, info.Size()-offset)
+		if _, err := f.ReadAt(buf, offset); err != nil && err != io.EOF {
+			return err
+		}
+		imported = string(buf)
+	}
+
+	if s.historyOffset < len(s.history) {
+		if _, err := f.WriteString(s.formatHistory(s.historyOffset, len(s.history))); err != nil {
+			return err
+		}
+	}
+	s.historyOffset = len(s.history)
+	if imported != "" {
+		s.readHistory(imported)
+	}
+
+	if info, err = f.Stat(); err != nil {
+		return err
+	}
+	s.histFile, s.histFileOffset = info, info.Size()
+
+	if limit := historyLimit("HISTFILESIZE"); trim && limit >= 0 {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if entries := splitHistoryEntries(string(data)); len(entries) > limit {
+			err := rewriteHistoryFile(path, strings.Join(entries[len(entries)-limit:], ""), info.Mode())
+			if err != nil {
+				return err
+			}
+			if info, err = os.Stat(path); err != nil {
+				return err
+			}
+			s.histFile, s.histFileOffset = info, info.Size()
+		}
+	}
+	return nil
+}
@@ -0,0 +1,268 @@
+package shell
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseHistoryMixed(t *testing.T) {
+	data := strings.Join([]string{
+		"plain one",
+		"#1700000000",
+		"stamped",
+		`{"cmd":"make test","start":"2024-03-05T14:07:09Z","duration":1.5,"cwd":"/src","status":2,"host":"box","session":"abc"}`,
+		`{ echo grouped; }`,
+		"",
+	}, "\n")
+	lines, metas := parseHistory(data)
+
+	want := []string{"plain one", "stamped", "make test", "{ echo grouped; }"}
+	if !reflect.DeepEqual(lines, want) {
+		t.Fatalf("lines = %q, want %q", lines, want)
+	}
+	if metas[0] != nil || metas[3] != nil {
+		t.Errorf("plain lines have metadata: %+v, %+v", metas[0], metas[3])
+	}
+	if metas[1] == nil || metas[1].start.Unix() != 1700000000 || metas[1].finished {
+		t.Errorf("stamped meta = %+v", metas[1])
+	}
+	m := metas[2]
+	if m == nil || m.cwd != "/src" || m.status != 2 || !m.finished || m.duration != 1500*time.Millisecond ||
+		m.host != "box" || m.session != "abc" {
+		t.Errorf("structured meta = %+v", m)
+	}
+}
+
+func TestFormatHistoryEntry(t *testing.T) {
+	m := &historyMeta{
+		start:    time.Date(2024, 3, 5, 14, 7, 9, 0, time.UTC),
+		duration: 250 * time.Millisecond,
+		cwd:      "/src",
+		status:   1,
+		finished: true,
+		host:     "box",
+		session:  "abc",
+	}
+	if got := formatHistoryEntry("ls", m, false, false); got != "ls\n" {
+		t.Errorf("plain = %q", got)
+	}
+	if got := formatHistoryEntry("ls", m, false, true); got != "#1709647629\nls\n" {
+		t.Errorf("stamped = %q", got)
+	}
+	if got := formatHistoryEntry("ls", nil, false, true); got != "ls\n" {
+		t.Errorf("stamped without meta = %q", got)
+	}
+	got := formatHistoryEntry("ls", m, true, false)
+	want := `{"cmd":"ls","start":"2024-03-05T14:07:09Z","duration":0.25,"cwd":"/src","status":1,"host":"box","session":"abc"}` + "\n"
+	if got != want {
+		t.Errorf("structured = %q, want %q", got, want)
+	}
+
+	lines, metas := parseHistory(got)
+	if len(lines) != 1 || lines[0] != "ls" || metas[0].status != 1 || metas[0].duration != m.duration {
+		t.Errorf("round trip = %q %+v", lines, metas[0])
+	}
+	cmd := "ls |\n  wc -l"
+	got = formatHistoryEntry(cmd, nil, false, true)
+	if want := `{"cmd":"ls |\n  wc -l"}` + "\n"; got != want {
+		t.Errorf("several lines = %q, want %q", got, want)
+	}
+	if lines, _ := parseHistory(got + "next\n"); !reflect.DeepEqual(lines, []string{cmd, "next"}) {
+		t.Errorf("several lines read back as %q", lines)
+	}
+}
+
+func TestParseLegacyHistory(t *testing.T) {
+	// Lines written by bash or older versions of gosh stay separate
+	// entries, even when one ends with a backslash.
+	data := "echo a \\\nls\nprintf '%s\\n' x\n"
+	want := []string{"echo a \\", "ls", "printf '%s\\n' x"}
+	if lines, _ := parseHistory(data); !reflect.DeepEqual(lines, want) {
+		t.Errorf("parseHistory = %q, want %q", lines, want)
+	}
+	if got := splitHistoryEntries(data); len(got) != 3 {
+		t.Errorf("splitHistoryEntries = %q, want 3 entries", got)
+	}
+}
+
+func TestStructuredHistoryFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history")
+	t.Setenv("HISTFILE", path)
+
+	s := &Shell{history: []string{"old"}, shopts: map[string]bool{"histjson": true}}
+	s.addHistory("false").finish(1)
+	s.saveHistory()
+
+	data, _ := os.ReadFile(path)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 || lines[0] != `{"cmd":"old"}` || !strings.Contains(lines[1], `"status":1`) {
+		t.Fatalf("history file = %q", data)
+	}
+
+	s2 := &Shell{}
+	s2.loadHistory()
+	if len(s2.history) != 2 || s2.metaAt(0) != nil {
+		t.Fatalf("loaded %q, meta[0] %+v", s2.history, s2.metaAt(0))
+	}
+	m := s2.metaAt(1)
+	if m == nil || m.status != 1 || m.session != s.sessionID() {
+		t.Errorf("loaded meta = %+v", m)
+	}
+}
+
+func TestSplitHistoryEntries(t *testing.T) {
+	data := "a\n#1700000000\nb\n\n{\"cmd\":\"c\"}\n"
+	want := []string{"a\n", "#1700000000\nb\n", "{\"cmd\":\"c\"}\n"}
+	if got := splitHistoryEntries(data); !reflect.DeepEqual(got, want) {
+		t.Errorf("splitHistoryEntries = %q, want %q", got, want)
+	}
+}
+
+func TestSaveHistoryKeepsOtherSessions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history")
+	os.WriteFile(path, []byte("base\n"), 0600)
+	t.Setenv("HISTFILE", path)
+
+	a, b := &Shell{}, &Shell{}
+	a.loadHistory()
+	b.loadHistory()
+	a.addHistory("from a")
+	b.addHistory("from b")
+	a.saveHistory()
+	b.saveHistory()
+
+	data, _ := os.ReadFile(path)
+	if string(data) != "base\nfrom a\nfrom b\n" {
+		t.Errorf("history file = %q, want entries from both sessions", data)
+	}
+}
+
+func TestConcurrentHistoryAppends(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history")
+	t.Setenv("HISTFILE", path)
+	t.Setenv("HISTFILESIZE", "")
+
+	const sessions, commands = 8, 50
+	done := make(chan error)
+	for i := range sessions {
+		go func() {
+			s := &Shell{shopts: map[string]bool{"histjson": i%2 == 0}}
+			for j := range commands {
+				s.addHistory(fmt.Sprintf("echo %d %d", i, j))
+				if err := s.syncHistoryFile(true, false); err != nil {
+					done <- err
+					return
+				}
+			}
+			done <- nil
+		}()
+	}
+	for range sessions {
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data, _ := os.ReadFile(path)
+	lines, _ := parseHistory(string(data))
+	if len(lines) != sessions*commands {
+		t.Fatalf("history file has %d entries, want %d", len(lines), sessions*commands)
+	}
+	seen := map[string]bool{}
+	for _, line := range lines {
+		seen[line] = true
+	}
+	if len(seen) != sessions*commands {
+		t.Errorf("history file has %d distinct entries, want %d", len(seen), sessions*commands)
+	}
+}
+
+func TestShareHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history")
+	t.Setenv("HISTFILE", path)
+
+	a, b := &Shell{}, &Shell{}
+	a.loadHistory()
+	b.loadHistory()
+
+	a.addHistory("a1")
+	a.syncHistoryFile(true, false)
+	b.syncHistoryFile(true, false)
+	if want := []string{"a1"}; !reflect.DeepEqual(b.history, want) {
+		t.Fatalf("b.history = %q, want %q", b.history, want)
+	}
+
+	b.addHistory("b1")
+	b.syncHistoryFile(true, false)
+	a.addHistory("a2")
+	a.syncHistoryFile(true, false)
+	if want := []string{"a1", "a2", "b1"}; !reflect.DeepEqual(a.history, want) {
+		t.Errorf("a.history = %q, want %q", a.history, want)
+	}
+	b.syncHistoryFile(true, false)
+	if want := []string{"a1", "b1", "a2"}; !reflect.DeepEqual(b.history, want) {
+		t.Errorf("b.history = %q, want %q", b.history, want)
+	}
+
+	data, _ := os.ReadFile(path)
+	if string(data) != "a1\nb1\na2\n" {
+		t.Errorf("history file = %q", data)
+	}
+}
+
+func TestShareHistorySkipsRewrittenFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history")
+	os.WriteFile(path, []byte("x\ny\n"), 0600)
+	t.Setenv("HISTFILE", path)
+
+	a, b := &Shell{}, &Shell{}
+	a.loadHistory()
+	b.loadHistory()
+	b.historyWrite(path)
+
+	a.syncHistoryFile(true, false)
+	if want := []string{"x", "y"}; !reflect.DeepEqual(a.history, want) {
+		t.Errorf("a.history = %q, want %q (no duplicates from rewrite)", a.history, want)
+	}
+}
+
+func TestSaveHistoryTrimsAtomically(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "history")
+	os.WriteFile(path, []byte("#1700000000\nold1\nold2\n"), 0640)
+	t.Setenv("HISTFILE", path)
+	t.Setenv("HISTFILESIZE", "3")
+
+	s := &Shell{}
+	s.loadHistory()
+	s.addHistory("new1")
+	s.addHistory("new2")
+	s.saveHistory()
+
+	data, _ := os.ReadFile(path)
+	if string(data) != "old2\nnew1\nnew2\n" {
+		t.Errorf("history file = %q", data)
+	}
+	info, _ := os.Stat(path)
+	if info.Mode().Perm() != 0640 {
+		t.Errorf("mode = %v, want 0640", info.Mode().Perm())
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("directory has %d files, want only the history file", len(entries))
+	}
+
+	os.WriteFile(path, []byte("a\n#1700000000\nb\nc\n"), 0600)
+	s2 := &Shell{}
+	s2.loadHistory()
+	t.Setenv("HISTFILESIZE", "2")
+	s2.saveHistory()
+	data, _ = os.ReadFile(path)
+	if string(data) != "#1700000000\nb\nc\n" {
+		t.Errorf("trimmed file = %q, want timestamp kept with its entry", data)
+	}
+}
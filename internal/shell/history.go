@@ -0,0 +1,473 @@
+package shell
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// historyMeta records when, where and how a history entry ran. Entries read
+// from plain history files have none.
+type historyMeta struct {
+	start    time.Time
+	duration time.Duration
+	cwd      string
+	status   int
+	finished bool // status and duration are known
+	host     string
+	session  string
+	private  bool // typed with a leading blank; kept out of history files
+}
+
+// finish records the exit status and duration of the entry's command. It
+// does nothing on a nil entry, which lets callers ignore whether the line
+// was recorded.
+func (m *historyMeta) finish(status int) {
+	if m == nil {
+		return
+	}
+	m.duration = time.Since(m.start)
+	m.status = status
+	m.finished = true
+}
+
+// historyControl holds the HISTCONTROL settings.
+type historyControl struct {
+	ignoreSpace bool
+	ignoreDups  bool
+	eraseDups   bool
+}
+
+// parseHistControl reads the colon-separated HISTCONTROL value. Unknown
+// words are ignored, as in bash.
+func parseHistControl(value string) historyControl {
+	var hc historyControl
+	for _, word := range strings.Split(value, ":") {
+		switch word {
+		case "ignorespace":
+			hc.ignoreSpace = true
+		case "ignoredups":
+			hc.ignoreDups = true
+		case "ignoreboth":
+			hc.ignoreSpace = true
+			hc.ignoreDups = true
+		case "erasedups":
+			hc.eraseDups = true
+		}
+	}
+	return hc
+}
+
+// historyLimit reads a HISTSIZE or HISTFILESIZE variable. It returns -1,
+// meaning no limit, when the variable is unset, empty, not a number or
+// negative.
+func historyLimit(name string) int {
+	n, err := strconv.Atoi(os.Getenv(name))
+	if err != nil || n < 0 {
+		return -1
+	}
+	return n
+}
+
+// historyIgnored reports whether line matches one of the colon-separated
+// HISTIGNORE patterns. Patterns are anchored globs; "&" matches the
+// previous history entry, and "\:" is a literal colon.
+func historyIgnored(line, patterns, previous string) bool {
+	if patterns == "" {
+		return false
+	}
+	for _, p := range splitColonList(patterns) {
+		if p == "&" {
+			if line == previous {
+				return true
+			}
+			continue
+		}
+		if p != "" && globMatch(p, line) {
+			return true
+		}
+	}
+	return false
+}
+
+// splitColonList splits a colon-separated variable value, in which "\:"
+// stands for a literal colon.
+func splitColonList(value string) []string {
+	var items []string
+	var cur strings.Builder
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; {
+		case c == '\\' && i+1 < len(value) && value[i+1] == ':':
+			cur.WriteByte(':')
+			i++
+		case c == ':':
+			items = append(items, cur.String())
+			cur.Reset()
+		default:
+			cur.WriteByte(c)
+		}
+	}
+	return append(items, cur.String())
+}
+
+// globMatch reports whether s matches the shell pattern in full. Unlike
+// path.Match, "*" also matches "/".
+func globMatch(pattern, s string) bool {
+	var re strings.Builder
+	re.WriteString(`^(?s:`)
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			re.WriteString(".*")
+		case '?':
+			re.WriteString(".")
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+				re.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+			} else {
+				re.WriteString(`\\`)
+			}
+		case '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end < 0 {
+				re.WriteString(`\[`)
+				continue
+			}
+			class := pattern[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			re.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		default:
+			re.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	re.WriteString(`)$`)
+	ok, err := regexp.MatchString(re.String(), s)
+	return err == nil && ok
+}
+
+// recordHistory adds a line entered at the prompt to the history, applying
+// HISTCONTROL and HISTIGNORE, and returns its metadata, or nil if the line
+// was not recorded. spaced reports whether the line was typed with leading
+// whitespace, which is trimmed before the line gets here.
+func (s *Shell) recordHistory(line string, spaced bool) *historyMeta {
+	hc := parseHistControl(os.Getenv("HISTCONTROL"))
+	if spaced && hc.ignoreSpace {
+		return nil
+	}
+	previous := ""
+	if len(s.history) > 0 {
+		previous = s.history[len(s.history)-1]
+	}
+	if hc.ignoreDups && line == previous {
+		return nil
+	}
+	if historyIgnored(line, os.Getenv("HISTIGNORE"), previous) {
+		return nil
+	}
+	if hc.eraseDups {
+		for i := len(s.history) - 1; i >= 0; i-- {
+			if s.history[i] == line {
+				s.deleteHistory(i, i+1)
+			}
+		}
+	}
+	meta := s.addHistory(line)
+	meta.private = spaced
+	return meta
+}
+
+// addHistory appends a line to the history and to the line editor's recall
+// list, then trims the history to HISTSIZE entries. It returns the new
+// entry's metadata, stamped with the current time and directory.
+func (s *Shell) addHistory(line string) *historyMeta {
+	wd, _ := os.Getwd()
+	host, _ := os.Hostname()
+	meta := &historyMeta{start: time.Now(), cwd: wd, host: host, session: s.sessionID()}
+	s.appendHistory(line, meta)
+	if s.rl != nil {
+		s.rl.SaveHistory(editLine(line))
+	}
+	s.trimHistory()
+	return meta
+}
+
+// appendHistory appends an entry to the history list without side effects.
+func (s *Shell) appendHistory(line string, meta *historyMeta) {
+	s.alignMeta()
+	s.history = append(s.history, line)
+	s.historyMeta = append(s.historyMeta, meta)
+}
+
+// alignMeta makes historyMeta parallel to history again after history was
+// set directly, padding with nil entries.
+func (s *Shell) alignMeta() {
+	for len(s.historyMeta) < len(s.history) {
+		s.historyMeta = append(s.historyMeta, nil)
+	}
+	s.historyMeta = s.historyMeta[:len(s.history)]
+}
+
+// metaAt returns the metadata of entry i, or nil if it has none.
+func (s *Shell) metaAt(i int) *historyMeta {
+	if i < len(s.historyMeta) {
+		return s.historyMeta[i]
+	}
+	return nil
+}
+
+// sessionID returns a random identifier for this shell session, recorded
+// with every history entry.
+func (s *Shell) sessionID() string {
+	if s.session == "" {
+		b := make([]byte, 6)
+		rand.Read(b)
+		s.session = hex.EncodeToString(b)
+	}
+	return s.session
+}
+
+// trimHistory drops the oldest entries beyond HISTSIZE.
+func (s *Shell) trimHistory() {
+	if limit := historyLimit("HISTSIZE"); limit >= 0 && len(s.history) > limit {
+		s.deleteHistory(0, len(s.history)-limit)
+	}
+}
+
+// deleteHistory removes the entries with indexes [from, to). historyOffset
+// is moved back by the number of removed entries that had not yet been
+// appended to a file, so history -a still writes exactly the new lines.
+func (s *Shell) deleteHistory(from, to int) {
+	if s.historyOffset > from {
+		s.historyOffset -= min(s.historyOffset, to) - from
+	}
+	s.alignMeta()
+	s.history = append(s.history[:from], s.history[to:]...)
+	s.historyMeta = append(s.historyMeta[:from], s.historyMeta[to:]...)
+	s.resetRecall()
+}
+
+// clearHistory empties the history list.
+func (s *Shell) clearHistory() {
+	s.history = nil
+	s.historyMeta = nil
+	s.historyOffset = 0
+	s.resetRecall()
+}
+
+// resetRecall rebuilds the line editor's recall list from the history.
+func (s *Shell) resetRecall() {
+	if s.rl == nil {
+		return
+	}
+	s.rl.ResetHistory()
+	for _, line := range s.history {
+		s.rl.SaveHistory(editLine(line))
+	}
+}
+
+// fileHistoryStart returns the index of the first entry to write to the
+// history file, so that at most HISTFILESIZE entries are kept.
+func (s *Shell) fileHistoryStart() int {
+	if limit := historyLimit("HISTFILESIZE"); limit >= 0 && len(s.history) > limit {
+		return len(s.history) - limit
+	}
+	return 0
+}
+
+// parseHistoryRange parses the operand of history -d: a position N, a
+// negative offset from the end, or START-END. It returns the zero-based
+// range [from, to) of entries to delete.
+func (s *Shell) parseHistoryRange(arg string) (int, int, error) {
+	position := func(p string) (int, bool) {
+		n, err := strconv.Atoi(p)
+		if err != nil || n == 0 {
+			return 0, false
+		}
+		if n < 0 {
+			n += len(s.history) + 1
+		}
+		return n, n >= 1 && n <= len(s.history)
+	}
+
+	if arg == "" {
+		return 0, 0, fmt.Errorf("history position out of range")
+	}
+	if start, end, ok := strings.Cut(arg[1:], "-"); ok {
+		start = arg[:1] + start
+		from, ok1 := position(start)
+		to, ok2 := position(end)
+		if !ok1 || !ok2 || from > to {
+			return 0, 0, fmt.Errorf("%s: history position out of range", arg)
+		}
+		return from - 1, to, nil
+	}
+	n, ok := position(arg)
+	if !ok {
+		return 0, 0, fmt.Errorf("%s: history position out of range", arg)
+	}
+	return n - 1, n, nil
+}
+
+// historyQuery selects history entries for history --since, --cwd and
+// --failed, and picks JSON output for --json.
+type historyQuery struct {
+	since  time.Time
+	cwd    string
+	failed bool
+	json   bool
+}
+
+// parseHistoryQuery consumes the leading query flags of args and returns
+// the remaining arguments.
+func parseHistoryQuery(args []string, now time.Time) (historyQuery, []string, error) {
+	var q historyQuery
+	for len(args) > 0 && strings.HasPrefix(args[0], "--") {
+		flag := args[0]
+		args = args[1:]
+		switch flag {
+		case "--failed":
+			q.failed = true
+			continue
+		case "--json":
+			q.json = true
+			continue
+		case "--since", "--cwd":
+		default:
+			return q, nil, fmt.Errorf("%s: invalid option", flag)
+		}
+		if len(args) == 0 {
+			return q, nil, fmt.Errorf("%s: option requires an argument", flag)
+		}
+		value := args[0]
+		args = args[1:]
+		if flag == "--since" {
+			since, err := parseSince(value, now)
+			if err != nil {
+				return q, nil, err
+			}
+			q.since = since
+			continue
+		}
+		dir, err := filepath.Abs(value)
+		if err != nil {
+			return q, nil, fmt.Errorf("--cwd: %v", err)
+		}
+		q.cwd = dir
+	}
+	return q, args, nil
+}
+
+// parseSince reads the argument of history --since: "today", "yesterday", a
+// duration before now such as "2h", "3d" or "1w", or a local date and time
+// such as "2024-03-05", "2024-03-05 14:00" or an RFC 3339 timestamp.
+func parseSince(value string, now time.Time) (time.Time, error) {
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	switch value {
+	case "today":
+		return midnight, nil
+	case "yesterday":
+		return midnight.AddDate(0, 0, -1), nil
+	}
+	if n := len(value); n > 1 && (value[n-1] == 'd' || value[n-1] == 'w') {
+		if count, err := strconv.Atoi(value[:n-1]); err == nil && count >= 0 {
+			if value[n-1] == 'w' {
+				count *= 7
+			}
+			return now.AddDate(0, 0, -count), nil
+		}
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return now.Add(-d), nil
+	}
+	for _, layout := range []string{"2006-01-02", "2006-01-02 15:04", "2006-01-02T15:04", "2006-01-02 15:04:05"} {
+		if t, err := time.ParseInLocation(layout, value, now.Location()); err == nil {
+			return t, nil
+		}
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("--since: %s: invalid date", value)
+}
+
+// match reports whether an entry with metadata m is selected. Entries
+// without metadata only match a query with no filters.
+func (q historyQuery) match(m *historyMeta) bool {
+	if !q.since.IsZero() && (m == nil || m.start.Before(q.since)) {
+		return false
+	}
+	if q.cwd != "" && (m == nil || !withinDir(m.cwd, q.cwd)) {
+		return false
+	}
+	if q.failed && (m == nil || !m.finished || m.status == 0) {
+		return false
+	}
+	return true
+}
+
+// withinDir reports whether path is dir or below it.
+func withinDir(path, dir string) bool {
+	if path == dir || dir == "/" {
+		return true
+	}
+	return strings.HasPrefix(path, strings.TrimSuffix(dir, "/")+"/")
+}
+
+// listHistory prints the entries selected by q, limited to the last n when
+// n is positive. Without --json each entry is numbered and, when
+// HISTTIMEFORMAT is set, prefixed with its start time in that format.
+func (s *Shell) listHistory(stdout io.Writer, q historyQuery, n int) {
+	var ids []int
+	for i := range s.history {
+		if q.match(s.metaAt(i)) {
+			ids = append(ids, i)
+		}
+	}
+	if n > 0 && n < len(ids) {
+		ids = ids[len(ids)-n:]
+	}
+
+	if !q.json {
+		s.printHistory(stdout, ids, true)
+		return
+	}
+	for _, i := range ids {
+		r := newHistoryRecord(s.history[i], s.metaAt(i))
+		r.ID = i + 1
+		b, _ := json.Marshal(r)
+		fmt.Fprintln(stdout, string(b))
+	}
+}
+
+// printHistory lists the entries ids, with their entry numbers if numbered
+// is set, and their start times when HISTTIMEFORMAT is set.
+func (s *Shell) printHistory(stdout io.Writer, ids []int, numbered bool) {
+	timeFormat, stamped := os.LookupEnv("HISTTIMEFORMAT")
+	for _, i := range ids {
+		stamp := ""
+		if stamped {
+			if m := s.metaAt(i); m != nil {
+				stamp = strftime(timeFormat, m.start)
+			} else {
+				stamp = "?? "
+			}
+		}
+		if numbered {
+			fmt.Fprintf(stdout, "    %d  %s%s\n", i+1, stamp, s.history[i])
+		} else {
+			fmt.Fprintf(stdout, "%s%s\n", stamp, s.history[i])
+		}
+	}
+}
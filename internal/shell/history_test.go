@@ -0,0 +1,361 @@
+package shell
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseHistControl(t *testing.T) {
+	tests := []struct {
+		value string
+		want  historyControl
+	}{
+		{"", historyControl{}},
+		{"ignorespace", historyControl{ignoreSpace: true}},
+		{"ignoreboth", historyControl{ignoreSpace: true, ignoreDups: true}},
+		{"ignoredups:erasedups:bogus", historyControl{ignoreDups: true, eraseDups: true}},
+	}
+	for _, tt := range tests {
+		if got := parseHistControl(tt.value); got != tt.want {
+			t.Errorf("parseHistControl(%q) = %+v, want %+v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestGlobMatch(t *testing.T) {
+	tests := []struct {
+		pattern, s string
+		want       bool
+	}{
+		{"ls", "ls", true},
+		{"ls", "ls -l", false},
+		{"cd *", "cd /tmp/dir", true},
+		{"?g", "bg", true},
+		{"[bf]g", "fg", true},
+		{"[!bf]g", "fg", false},
+		{`a\*`, "a*", true},
+		{`a\*`, "ab", false},
+		{"a.b", "axb", false},
+	}
+	for _, tt := range tests {
+		if got := globMatch(tt.pattern, tt.s); got != tt.want {
+			t.Errorf("globMatch(%q, %q) = %v, want %v", tt.pattern, tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestHistoryIgnored(t *testing.T) {
+	tests := []struct {
+		line, patterns, previous string
+		want                     bool
+	}{
+		{"ls", "ls:bg:fg", "", true},
+		{"ls -l", "ls:bg:fg", "", false},
+		{"exit", "[ \t]*:exit", "", true},
+		{"make", "&", "make", true},
+		{"make", "&", "go test", false},
+		{"a:b", `a\:b`, "", true},
+		{"anything", "", "", false},
+	}
+	for _, tt := range tests {
+		if got := historyIgnored(tt.line, tt.patterns, tt.previous); got != tt.want {
+			t.Errorf("historyIgnored(%q, %q, %q) = %v, want %v", tt.line, tt.patterns, tt.previous, got, tt.want)
+		}
+	}
+}
+
+func TestRecordHistoryControls(t *testing.T) {
+	tests := []struct {
+		name     string
+		control  string
+		ignore   string
+		lines    []string
+		spaced   []bool
+		want     []string
+		initial  []string
+		offset   int
+		wantOffs int
+	}{
+		{
+			name:   "none",
+			lines:  []string{"a", "a", "b"},
+			spaced: []bool{false, true, false},
+			want:   []string{"a", "a", "b"},
+		},
+		{
+			name:    "ignorespace",
+			control: "ignorespace",
+			lines:   []string{"a", "secret", "b"},
+			spaced:  []bool{false, true, false},
+			want:    []string{"a", "b"},
+		},
+		{
+			name:    "ignoredups",
+			control: "ignoredups",
+			lines:   []string{"a", "a", "b", "a"},
+			want:    []string{"a", "b", "a"},
+		},
+		{
+			name:     "erasedups",
+			control:  "erasedups",
+			initial:  []string{"a", "b", "a", "c"},
+			offset:   3,
+			lines:    []string{"a"},
+			want:     []string{"b", "c", "a"},
+			wantOffs: 1,
+		},
+		{
+			name:   "histignore",
+			ignore: "ls:cd *:&",
+			lines:  []string{"ls", "cd /tmp", "make", "make", "ls -l"},
+			want:   []string{"make", "ls -l"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("HISTCONTROL", tt.control)
+			t.Setenv("HISTIGNORE", tt.ignore)
+			t.Setenv("HISTSIZE", "")
+			s := &Shell{history: tt.initial, historyOffset: tt.offset}
+			for i, line := range tt.lines {
+				spaced := tt.spaced != nil && tt.spaced[i]
+				s.recordHistory(line, spaced)
+			}
+			if !reflect.DeepEqual(s.history, tt.want) {
+				t.Errorf("history = %q, want %q", s.history, tt.want)
+			}
+			if s.historyOffset != tt.wantOffs {
+				t.Errorf("historyOffset = %d, want %d", s.historyOffset, tt.wantOffs)
+			}
+		})
+	}
+}
+
+func TestHistorySize(t *testing.T) {
+	t.Setenv("HISTSIZE", "3")
+	s := &Shell{history: []string{"a", "b", "c"}, historyOffset: 2}
+	s.addHistory("d")
+	s.addHistory("e")
+	if want := []string{"c", "d", "e"}; !reflect.DeepEqual(s.history, want) {
+		t.Errorf("history = %q, want %q", s.history, want)
+	}
+	if s.historyOffset != 0 {
+		t.Errorf("historyOffset = %d, want 0", s.historyOffset)
+	}
+}
+
+func TestHistoryFileSize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history")
+	t.Setenv("HISTFILE", path)
+	t.Setenv("HISTFILESIZE", "2")
+
+	s := &Shell{history: []string{"a", "b", "c"}}
+	s.saveHistory()
+	data, _ := os.ReadFile(path)
+	if string(data) != "b\nc\n" {
+		t.Errorf("saved history = %q, want %q", data, "b\nc\n")
+	}
+
+	t.Setenv("HISTSIZE", "1")
+	s2 := &Shell{}
+	s2.loadHistory()
+	if want := []string{"c"}; !reflect.DeepEqual(s2.history, want) || s2.historyOffset != 1 {
+		t.Errorf("loaded history = %q offset %d, want %q offset 1", s2.history, s2.historyOffset, want)
+	}
+}
+
+func TestHistoryClear(t *testing.T) {
+	s := &Shell{history: []string{"a", "b"}, historyOffset: 1}
+	if status := s.runHistory([]string{"-c"}, &bytes.Buffer{}); status != 0 {
+		t.Fatalf("history -c = %d", status)
+	}
+	if len(s.history) != 0 || s.historyOffset != 0 {
+		t.Errorf("after -c: history = %q, offset %d", s.history, s.historyOffset)
+	}
+}
+
+func TestHistoryDelete(t *testing.T) {
+	tests := []struct {
+		arg        string
+		want       []string
+		wantOffset int
+	}{
+		{"2", []string{"a", "c", "d", "e"}, 2},
+		{"-1", []string{"a", "b", "c", "d"}, 3},
+		{"2-4", []string{"a", "e"}, 1},
+		{"-2--1", []string{"a", "b", "c"}, 3},
+		{"4", []string{"a", "b", "c", "e"}, 3},
+	}
+	for _, tt := range tests {
+		s := &Shell{history: []string{"a", "b", "c", "d", "e"}, historyOffset: 3}
+		if status := s.runHistory([]string{"-d", tt.arg}, &bytes.Buffer{}); status != 0 {
+			t.Errorf("history -d %s = %d", tt.arg, status)
+			continue
+		}
+		if !reflect.DeepEqual(s.history, tt.want) || s.historyOffset != tt.wantOffset {
+			t.Errorf("history -d %s: history = %q offset %d, want %q offset %d",
+				tt.arg, s.history, s.historyOffset, tt.want, tt.wantOffset)
+		}
+	}
+
+	for _, arg := range []string{"0", "6", "-6", "4-2", "x"} {
+		s := &Shell{history: []string{"a", "b", "c", "d", "e"}}
+		if status := s.runHistory([]string{"-d", arg}, &bytes.Buffer{}); status != 1 {
+			t.Errorf("history -d %s = %d, want 1", arg, status)
+		}
+	}
+}
+
+func TestHistoryDeleteThenAppend(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history")
+	s := &Shell{history: []string{"old1", "old2", "new1", "new2"}, historyOffset: 2}
+	s.runHistory([]string{"-d", "1"}, &bytes.Buffer{})
+	s.historyAppend(path)
+	data, _ := os.ReadFile(path)
+	if string(data) != "new1\nnew2\n" {
+		t.Errorf("appended %q, want %q", data, "new1\nnew2\n")
+	}
+}
+
+func TestHistoryStore(t *testing.T) {
+	s := &Shell{history: []string{"a", "history -s b c"}, lineNo: 2}
+	s.runHistory([]string{"-s", "b", "c"}, &bytes.Buffer{})
+	if want := []string{"a", "b c"}; !reflect.DeepEqual(s.history, want) {
+		t.Errorf("history = %q, want %q", s.history, want)
+	}
+
+	var buf bytes.Buffer
+	s.runHistory(nil, &buf)
+	if !strings.Contains(buf.String(), "2  b c") {
+		t.Errorf("history output = %q", buf.String())
+	}
+}
+
+func TestHistoryMetaFollowsDeletes(t *testing.T) {
+	s := &Shell{history: []string{"a"}}
+	b := s.addHistory("b")
+	c := s.addHistory("c")
+	b.finish(3)
+	s.deleteHistory(0, 1)
+	if s.metaAt(0) != b || s.metaAt(1) != c {
+		t.Errorf("meta after delete = %v, want [b c]", s.historyMeta)
+	}
+	if s.metaAt(0).status != 3 || !s.metaAt(0).finished {
+		t.Errorf("b meta = %+v", s.metaAt(0))
+	}
+	var none *historyMeta
+	none.finish(1) // must not panic
+}
+
+func TestParseSince(t *testing.T) {
+	now := time.Date(2024, 3, 5, 14, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value string
+		want  time.Time
+	}{
+		{"today", time.Date(2024, 3, 5, 0, 0, 0, 0, time.UTC)},
+		{"yesterday", time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC)},
+		{"2h", now.Add(-2 * time.Hour)},
+		{"3d", now.AddDate(0, 0, -3)},
+		{"1w", now.AddDate(0, 0, -7)},
+		{"2024-01-02", time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)},
+		{"2024-01-02 15:04", time.Date(2024, 1, 2, 15, 4, 0, 0, time.UTC)},
+		{"2024-01-02T15:04:00Z", time.Date(2024, 1, 2, 15, 4, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		got, err := parseSince(tt.value, now)
+		if err != nil || !got.Equal(tt.want) {
+			t.Errorf("parseSince(%q) = %v, %v; want %v", tt.value, got, err, tt.want)
+		}
+	}
+	if _, err := parseSince("last tuesday", now); err == nil {
+		t.Error("parseSince(last tuesday) succeeded")
+	}
+}
+
+func TestWithinDir(t *testing.T) {
+	tests := []struct {
+		path, dir string
+		want      bool
+	}{
+		{"/src/repo", "/src/repo", true},
+		{"/src/repo/pkg", "/src/repo", true},
+		{"/src/repo2", "/src/repo", false},
+		{"/anything", "/", true},
+	}
+	for _, tt := range tests {
+		if got := withinDir(tt.path, tt.dir); got != tt.want {
+			t.Errorf("withinDir(%q, %q) = %v, want %v", tt.path, tt.dir, got, tt.want)
+		}
+	}
+}
+
+func TestHistoryQuery(t *testing.T) {
+	now := time.Now()
+	s := &Shell{
+		history: []string{"plain", "old ok", "repo fail", "elsewhere fail", "repo ok"},
+		historyMeta: []*historyMeta{
+			nil,
+			{start: now.AddDate(0, 0, -10), cwd: "/repo", finished: true},
+			{start: now.Add(-time.Hour), cwd: "/repo/sub", status: 1, finished: true},
+			{start: now.Add(-time.Hour), cwd: "/tmp", status: 127, finished: true},
+			{start: now.Add(-time.Minute), cwd: "/repo", finished: true},
+		},
+	}
+	tests := []struct {
+		args []string
+		want []string
+	}{
+		{nil, []string{"1  plain", "2  old ok", "3  repo fail", "4  elsewhere fail", "5  repo ok"}},
+		{[]string{"--failed"}, []string{"3  repo fail", "4  elsewhere fail"}},
+		{[]string{"--cwd", "/repo"}, []string{"2  old ok", "3  repo fail", "5  repo ok"}},
+		{[]string{"--since", "1d", "--cwd", "/repo"}, []string{"3  repo fail", "5  repo ok"}},
+		{[]string{"--since", "1d", "--failed", "1"}, []string{"4  elsewhere fail"}},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if status := s.runHistory(tt.args, &buf); status != 0 {
+			t.Errorf("history %v = %d", tt.args, status)
+			continue
+		}
+		var got []string
+		for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+			got = append(got, strings.TrimSpace(line))
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("history %v = %q, want %q", tt.args, got, tt.want)
+		}
+	}
+
+	var buf bytes.Buffer
+	s.runHistory([]string{"--failed", "--json", "1"}, &buf)
+	if got := buf.String(); !strings.HasPrefix(got, `{"id":4,"cmd":"elsewhere fail",`) || !strings.Contains(got, `"status":127`) {
+		t.Errorf("history --json = %q", got)
+	}
+
+	for _, args := range [][]string{{"--bogus"}, {"--since"}, {"--since", "soon"}} {
+		if status := s.runHistory(args, &bytes.Buffer{}); status != 2 {
+			t.Errorf("history %v = %d, want 2", args, status)
+		}
+	}
+}
+
+func TestHistoryTimeFormat(t *testing.T) {
+	t.Setenv("HISTTIMEFORMAT", "%F %T ")
+	start := time.Date(2024, 3, 5, 14, 7, 9, 0, time.Local)
+	s := &Shell{
+		history:     []string{"plain", "stamped"},
+		historyMeta: []*historyMeta{nil, {start: start}},
+	}
+	var buf bytes.Buffer
+	s.runHistory(nil, &buf)
+	want := "    1  ?? plain\n    2  2024-03-05 14:07:09 stamped\n"
+	if buf.String() != want {
+		t.Errorf("history = %q, want %q", buf.String(), want)
+	}
+}
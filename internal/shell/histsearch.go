@@ -0,0 +1,258 @@
+package shell
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/chzyer/readline"
+)
+
+// searchMatch is a history line that matches the search query, with the
+// rune positions of the matched characters for highlighting.
+type searchMatch struct {
+	line      string
+	positions []int
+	score     float64
+}
+
+// searchAction tells the line editor what to do after a key in the search
+// widget.
+type searchAction int
+
+const (
+	searchContinue searchAction = iota // redraw and keep searching
+	searchAccept                       // put the selected line in the buffer
+	searchCancel                       // restore the original line
+)
+
+// historySearch is the state of the Ctrl-R fuzzy history search widget.
+type historySearch struct {
+	entries []searchEntry
+	cwd     string
+	cwdOnly bool
+	query   []rune
+	matches []searchMatch
+	index   int // selected match
+}
+
+// searchEntry is a history line with the directory it ran in, if known.
+type searchEntry struct {
+	line string
+	cwd  string
+}
+
+// newHistorySearch starts a search over entries (oldest first) with an
+// initial query.
+func newHistorySearch(entries []searchEntry, cwd, query string) *historySearch {
+	h := &historySearch{entries: entries, cwd: cwd, query: []rune(query)}
+	h.update()
+	return h
+}
+
+// key handles one input rune. Printable runes extend the query, Backspace
+// shortens it, Ctrl-R and Ctrl-S (or Up and Down) move to the next and
+// previous match, Ctrl-T toggles the current-directory filter, Enter, Tab
+// and the cursor keys accept, and Ctrl-G, Ctrl-C and Esc cancel.
+func (h *historySearch) key(r rune) searchAction {
+	switch r {
+	case readline.CharBckSearch, readline.CharPrev:
+		if h.index < len(h.matches)-1 {
+			h.index++
+		}
+	case readline.CharFwdSearch, readline.CharNext:
+		if h.index > 0 {
+			h.index--
+		}
+	case readline.CharTranspose:
+		h.cwdOnly = !h.cwdOnly
+		h.update()
+	case readline.CharBackspace, readline.CharCtrlH:
+		if len(h.query) > 0 {
+			h.query = h.query[:len(h.query)-1]
+			h.update()
+		}
+	case readline.CharEnter, readline.CharCtrlJ, readline.CharTab,
+		readline.CharForward, readline.CharBackward, readline.CharLineStart, readline.CharLineEnd:
+		return searchAccept
+	case readline.CharBell, readline.CharInterrupt, readline.CharEsc:
+		return searchCancel
+	default:
+		if unicode.IsPrint(r) {
+			h.query = append(h.query, r)
+			h.update()
+		}
+	}
+	return searchContinue
+}
+
+// selected returns the selected match, or nil if nothing matches.
+func (h *historySearch) selected() *searchMatch {
+	if h.index < len(h.matches) {
+		return &h.matches[h.index]
+	}
+	return nil
+}
+
+// prompt returns the prompt shown while searching.
+func (h *historySearch) prompt() string {
+	scope := ""
+	if h.cwdOnly {
+		scope = " in ."
+	}
+	status := "no match"
+	if len(h.matches) > 0 {
+		status = fmt.Sprintf("%d/%d", h.index+1, len(h.matches))
+	}
+	return fmt.Sprintf("(search%s [%s]) `%s': ", scope, status, string(h.query))
+}
+
+func (h *historySearch) update() {
+	h.matches = rankHistory(h.entries, string(h.query), h.cwd, h.cwdOnly)
+	h.index = 0
+}
+
+// rankHistory returns the distinct history lines matching query, best
+// first. The fuzzy match score is weighted by how recently and how often a
+// line was used. With cwdOnly, only lines run in cwd are considered.
+func rankHistory(entries []searchEntry, query, cwd string, cwdOnly bool) []searchMatch {
+	type stats struct {
+		count int
+		age   int // distinct lines used more recently
+	}
+	seen := map[string]*stats{}
+	var order []string
+	for i := len(entries) - 1; i >= 0; i-- {
+		e := entries[i]
+		if cwdOnly && e.cwd != cwd {
+			continue
+		}
+		if st, ok := seen[e.line]; ok {
+			st.count++
+			continue
+		}
+		seen[e.line] = &stats{count: 1, age: len(order)}
+		order = append(order, e.line)
+	}
+
+	var matches []searchMatch
+	for _, line := range order {
+		score, positions, ok := fuzzyMatch(query, line)
+		if !ok {
+			continue
+		}
+		st := seen[line]
+		score += 6 * math.Log1p(float64(st.count))
+		score += 20 / (1 + float64(st.age)/20)
+		matches = append(matches, searchMatch{line: line, positions: positions, score: score})
+	}
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].score > matches[j].score })
+	return matches
+}
+
+// fuzzyMatch reports whether the runes of query appear in order in s, and
+// scores the best such alignment: consecutive runes and runes at the start
+// of a word score higher, gaps score lower. Matching ignores case unless
+// the query contains an upper-case letter. An empty query matches
+// everything with score 0.
+func fuzzyMatch(query, s string) (float64, []int, bool) {
+	q := []rune(query)
+	text := []rune(s)
+	if len(q) == 0 {
+		return 0, nil, true
+	}
+	fold := strings.ToLower(query) == query
+	eq := func(a, b rune) bool {
+		if fold {
+			return unicode.ToLower(a) == b
+		}
+		return a == b
+	}
+
+	best := math.Inf(-1)
+	var bestPos []int
+	for start := range text {
+		if !eq(text[start], q[0]) {
+			continue
+		}
+		positions := []int{start}
+		j := start + 1
+		for k := 1; k < len(q); k++ {
+			for j < len(text) && !eq(text[j], q[k]) {
+				j++
+			}
+			if j == len(text) {
+				break
+			}
+			positions = append(positions, j)
+			j++
+		}
+		if len(positions) < len(q) {
+			break // later starts cannot match either
+		}
+		if score := alignmentScore(text, positions); score > best {
+			best, bestPos = score, positions
+		}
+	}
+	if bestPos == nil {
+		return 0, nil, false
+	}
+	return best, bestPos, true
+}
+
+func alignmentScore(text []rune, positions []int) float64 {
+	score := 0.0
+	for i, p := range positions {
+		score += 16
+		if p == 0 || strings.ContainsRune(" /-_.=|;:'\"", text[p-1]) {
+			score += 10
+		}
+		if i > 0 {
+			if gap := p - positions[i-1] - 1; gap == 0 {
+				score += 12
+			} else {
+				score -= math.Min(float64(gap), 10)
+			}
+		}
+	}
+	if positions[0] == 0 {
+		score += 8
+	}
+	return score
+}
+
+// highlight paints the runes of line at positions in bold and underlined.
+func highlight(line []rune, positions []int) []rune {
+	if len(positions) == 0 {
+		return line
+	}
+	marked := make(map[int]bool, len(positions))
+	for _, p := range positions {
+		marked[p] = true
+	}
+	var out []rune
+	for i, r := range line {
+		if marked[i] {
+			out = append(out, []rune("\x1b[1;4m")...)
+			out = append(out, r)
+			out = append(out, []rune("\x1b[0m")...)
+			continue
+		}
+		out = append(out, r)
+	}
+	return out
+}
+
+// searchEntries returns the history as search entries, oldest first.
+func (s *Shell) searchEntries() []searchEntry {
+	entries := make([]searchEntry, len(s.history))
+	for i, line := range s.history {
+		entries[i].line = line
+		if m := s.metaAt(i); m != nil {
+			entries[i].cwd = m.cwd
+		}
+	}
+	return entries
+}
@@ -0,0 +1,161 @@
+package shell
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/chzyer/readline"
+)
+
+func TestFuzzyMatch(t *testing.T) {
+	tests := []struct {
+		query, s  string
+		ok        bool
+		positions []int
+	}{
+		{"", "anything", true, nil},
+		{"gco", "git checkout", true, []int{0, 4, 9}},
+		{"mk", "make", true, []int{0, 2}},
+		{"test", "go test ./...", true, []int{3, 4, 5, 6}},
+		{"xyz", "git status", false, nil},
+		{"tsg", "git status", false, nil},
+		{"GIT", "git status", false, nil},
+		{"Make", "Makefile", true, []int{0, 1, 2, 3}},
+	}
+	for _, tt := range tests {
+		_, positions, ok := fuzzyMatch(tt.query, tt.s)
+		if ok != tt.ok || !reflect.DeepEqual(positions, tt.positions) {
+			t.Errorf("fuzzyMatch(%q, %q) = %v %v, want %v %v", tt.query, tt.s, positions, ok, tt.positions, tt.ok)
+		}
+	}
+}
+
+func TestFuzzyMatchPrefersWordStarts(t *testing.T) {
+	consecutive, _, _ := fuzzyMatch("log", "git log")
+	scattered, _, _ := fuzzyMatch("log", "look at bugs")
+	if consecutive <= scattered {
+		t.Errorf("score(git log) = %v, score(look at bugs) = %v; want consecutive match first", consecutive, scattered)
+	}
+}
+
+func searchLines(lines ...string) []searchEntry {
+	entries := make([]searchEntry, len(lines))
+	for i, line := range lines {
+		entries[i].line = line
+	}
+	return entries
+}
+
+func matchLines(matches []searchMatch) []string {
+	var lines []string
+	for _, m := range matches {
+		lines = append(lines, m.line)
+	}
+	return lines
+}
+
+func TestRankHistoryRecency(t *testing.T) {
+	entries := searchLines("make build", "ls", "make test", "make lint")
+	got := matchLines(rankHistory(entries, "make", "", false))
+	want := []string{"make lint", "make test", "make build"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("rankHistory = %q, want %q", got, want)
+	}
+}
+
+func TestRankHistoryFrequency(t *testing.T) {
+	var lines []string
+	for range 10 {
+		lines = append(lines, "go test ./...")
+	}
+	lines = append(lines, "go build ./...")
+	got := matchLines(rankHistory(searchLines(lines...), "go", "", false))
+	if len(got) != 2 || got[0] != "go test ./..." {
+		t.Errorf("rankHistory = %q, want the frequent line first", got)
+	}
+}
+
+func TestRankHistoryCwdOnly(t *testing.T) {
+	entries := []searchEntry{
+		{"make", "/src/a"},
+		{"make install", "/src/b"},
+		{"make check", ""},
+	}
+	if got := matchLines(rankHistory(entries, "make", "/src/a", true)); !reflect.DeepEqual(got, []string{"make"}) {
+		t.Errorf("cwd-only ranking = %q, want [make]", got)
+	}
+	if got := rankHistory(entries, "make", "/src/a", false); len(got) != 3 {
+		t.Errorf("unfiltered ranking has %d matches, want 3", len(got))
+	}
+}
+
+func TestHistorySearchKeys(t *testing.T) {
+	entries := []searchEntry{
+		{"git status", "/repo"},
+		{"git stash", "/other"},
+		{"grep -r stash", "/repo"},
+	}
+	h := newHistorySearch(entries, "/repo", "")
+	for _, r := range "gst" {
+		if h.key(r) != searchContinue {
+			t.Fatalf("key %q ended the search", r)
+		}
+	}
+	if m := h.selected(); m == nil || m.line != "git stash" {
+		t.Fatalf("selected = %+v, want git stash", m)
+	}
+	if !strings.Contains(h.prompt(), "`gst'") || !strings.Contains(h.prompt(), "1/") {
+		t.Errorf("prompt = %q", h.prompt())
+	}
+
+	h.key(readline.CharBckSearch)
+	if h.index != 1 {
+		t.Errorf("Ctrl-R index = %d, want 1", h.index)
+	}
+	h.key(readline.CharFwdSearch)
+	if h.index != 0 {
+		t.Errorf("Ctrl-S index = %d, want 0", h.index)
+	}
+
+	h.key(readline.CharTranspose)
+	for _, m := range h.matches {
+		if m.line == "git stash" {
+			t.Errorf("cwd filter kept %q", m.line)
+		}
+	}
+	if !strings.Contains(h.prompt(), " in .") {
+		t.Errorf("prompt = %q, want directory scope", h.prompt())
+	}
+
+	h.key(readline.CharBackspace)
+	if string(h.query) != "gs" {
+		t.Errorf("query after Backspace = %q, want gs", string(h.query))
+	}
+	if h.key(readline.CharEnter) != searchAccept {
+		t.Errorf("Enter did not accept")
+	}
+	if h.key(readline.CharBell) != searchCancel {
+		t.Errorf("Ctrl-G did not cancel")
+	}
+}
+
+func TestHistorySearchNoMatch(t *testing.T) {
+	h := newHistorySearch(searchLines("ls"), "", "zz")
+	if h.selected() != nil {
+		t.Errorf("selected = %+v, want nil", h.selected())
+	}
+	if !strings.Contains(h.prompt(), "no match") {
+		t.Errorf("prompt = %q", h.prompt())
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	got := string(highlight([]rune("abc"), []int{1}))
+	if got != "a\x1b[1;4mb\x1b[0mc" {
+		t.Errorf("highlight = %q", got)
+	}
+	if got := string(highlight([]rune("abc"), nil)); got != "abc" {
+		t.Errorf("highlight without positions = %q", got)
+	}
+}
@@ -0,0 +1,146 @@
+package shell
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"time"
+)
+
+// hookEvents are the points in the shell's life cycle where hooks run:
+// preexec before a line entered at the prompt runs, precmd before each
+// prompt, and chpwd after cd changes the working directory.
+var hookEvents = []string{"preexec", "precmd", "chpwd"}
+
+// hooks holds the commands registered with the hook builtin, by event, and
+// the Go functions registered by code that embeds the shell.
+type hooks struct {
+	commands map[string][]string
+	preexec  []func(line string)
+	precmd   []func(status int, duration time.Duration)
+	chpwd    []func(oldDir, newDir string)
+	running  bool // a hook command is running
+}
+
+// OnPreexec registers fn to be called with each line entered at the
+// prompt, after history expansion and before it runs.
+func (s *Shell) OnPreexec(fn func(line string)) {
+	s.hooks.preexec = append(s.hooks.preexec, fn)
+}
+
+// OnPrecmd registers fn to be called before each prompt with the exit
+// status and run time of the last line.
+func (s *Shell) OnPrecmd(fn func(status int, duration time.Duration)) {
+	s.hooks.precmd = append(s.hooks.precmd, fn)
+}
+
+// OnChpwd registers fn to be called when cd changes the working directory.
+func (s *Shell) OnChpwd(fn func(oldDir, newDir string)) {
+	s.hooks.chpwd = append(s.hooks.chpwd, fn)
+}
+
+// runHook implements the hook builtin:
+//
+//	hook                      list the hook commands
+//	hook event command        run command at event
+//	hook -d event [command]   remove command, or all commands, from event
+func (s *Shell) runHook(args []string, stdout, stderr io.Writer) int {
+	remove := len(args) > 0 && args[0] == "-d"
+	if remove {
+		args = args[1:]
+	}
+	if len(args) == 0 && !remove {
+		for _, event := range hookEvents {
+			for _, cmd := range s.hooks.commands[event] {
+				fmt.Fprintf(stdout, "hook %s %s\n", event, shellQuote(cmd))
+			}
+		}
+		return 0
+	}
+	if len(args) == 0 || len(args) > 2 || !remove && len(args) != 2 {
+		fmt.Fprintln(stderr, "hook: usage: hook [-d] event [command]")
+		return 2
+	}
+	event := args[0]
+	if !slices.Contains(hookEvents, event) {
+		fmt.Fprintf(stderr, "hook: %s: unknown event\n", event)
+		return 1
+	}
+	cmds := s.hooks.commands[event]
+	switch {
+	case !remove:
+		if s.hooks.commands == nil {
+			s.hooks.commands = make(map[string][]string)
+		}
+		s.hooks.commands[event] = append(cmds, args[1])
+	case len(args) == 1:
+		delete(s.hooks.commands, event)
+	default:
+		i := slices.Index(cmds, args[1])
+		if i < 0 {
+			fmt.Fprintf(stderr, "hook: %s: no such %s hook\n", args[1], event)
+			return 1
+		}
+		s.hooks.commands[event] = slices.Delete(cmds, i, i+1)
+	}
+	return 0
+}
+
+// runHooks runs the commands registered for event with the variables in
+// assigns set. Like trap handlers, they leave $? unchanged, fire no DEBUG
+// or ERR traps and do not make set -e exit the shell when they fail.
+// Commands run by a hook command do not run hook commands themselves.
+func (s *Shell) runHooks(event string, assigns []string) {
+	cmds := s.hooks.commands[event]
+	if len(cmds) == 0 || s.hooks.running {
+		return
+	}
+	status, inTrap := s.lastStatus, s.inTrap
+	s.hooks.running, s.inTrap = true, true
+	restore, _ := s.assignTemporary(assigns)
+	for _, cmd := range slices.Clone(cmds) {
+		s.execLine(cmd)
+		if s.exiting {
+			break
+		}
+	}
+	restore()
+	s.hooks.running, s.inTrap = false, inTrap
+	if !s.exiting {
+		s.lastStatus = status
+	}
+}
+
+// preexec runs the preexec hooks for line. Hook commands find it in
+// GOSH_COMMAND.
+func (s *Shell) preexec(line string) {
+	for _, fn := range s.hooks.preexec {
+		fn(line)
+	}
+	s.runHooks("preexec", []string{"GOSH_COMMAND=" + shellQuote(line)})
+}
+
+// precmd runs the precmd hooks. Hook commands find the status of the last
+// line in $? and its run time in seconds in GOSH_DURATION.
+func (s *Shell) precmd() {
+	for _, fn := range s.hooks.precmd {
+		fn(s.lastStatus, s.lastDuration)
+	}
+	s.runHooks("precmd", []string{"GOSH_DURATION=" + formatDuration(s.lastDuration, 3, false)})
+}
+
+// chpwd updates PWD and OLDPWD after cd left oldDir, and runs the chpwd
+// hooks, which find the directories in those variables.
+func (s *Shell) chpwd(oldDir string) {
+	dir, err := os.Getwd()
+	if err != nil || dir == oldDir {
+		return
+	}
+	os.Setenv("OLDPWD", oldDir)
+	os.Setenv("PWD", dir)
+	for _, fn := range s.hooks.chpwd {
+		fn(oldDir, dir)
+	}
+	s.runHooks("chpwd", nil)
+}
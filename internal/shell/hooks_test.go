@@ -0,0 +1,126 @@
+package shell
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunHook(t *testing.T) {
+	s := &Shell{}
+	var out, errs strings.Builder
+	s.runHook([]string{"precmd", "echo it's"}, &out, &errs)
+	s.runHook([]string{"preexec", "a"}, &out, &errs)
+	s.runHook([]string{"preexec", "b"}, &out, &errs)
+	s.runHook(nil, &out, &errs)
+	want := "hook preexec a\nhook preexec b\nhook precmd 'echo it'\\''s'\n"
+	if out.String() != want || errs.Len() > 0 {
+		t.Errorf("hook listed %q, %q; want %q", out.String(), errs.String(), want)
+	}
+
+	if s.runHook([]string{"-d", "preexec", "a"}, &out, &errs) != 0 || len(s.hooks.commands["preexec"]) != 1 {
+		t.Errorf("hook -d preexec a left %q", s.hooks.commands["preexec"])
+	}
+	if s.runHook([]string{"-d", "precmd"}, &out, &errs) != 0 || len(s.hooks.commands["precmd"]) != 0 {
+		t.Errorf("hook -d precmd left %q", s.hooks.commands["precmd"])
+	}
+
+	tests := []struct {
+		args   []string
+		status int
+		msg    string
+	}{
+		{[]string{"preexec"}, 2, "usage"},
+		{[]string{"-d"}, 2, "usage"},
+		{[]string{"postexec", "x"}, 1, "postexec: unknown event"},
+		{[]string{"-d", "preexec", "zz"}, 1, "zz: no such preexec hook"},
+	}
+	for _, tt := range tests {
+		errs.Reset()
+		if got := s.runHook(tt.args, &out, &errs); got != tt.status || !strings.Contains(errs.String(), tt.msg) {
+			t.Errorf("hook %q = %d, %q; want %d, %q", tt.args, got, errs.String(), tt.status, tt.msg)
+		}
+	}
+}
+
+func TestHookCommands(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	t.Setenv("PWD", dir)
+	t.Setenv("OLDPWD", "")
+	os.Mkdir("sub", 0755)
+	log := filepath.Join(dir, "log")
+
+	s := &Shell{}
+	s.execLine(`hook preexec 'echo "pre $GOSH_COMMAND" >> ` + log + `'`)
+	s.execLine(`hook precmd 'echo "cmd $? $GOSH_DURATION" >> ` + log + `; cd sub'`)
+	s.execLine(`hook chpwd 'echo "cd $OLDPWD $PWD" >> ` + log + `'`)
+
+	s.runLine("false")
+	s.lastDuration = 1500 * time.Millisecond
+	s.precmd()
+	if s.lastStatus != 1 {
+		t.Errorf("hooks changed $? to %d", s.lastStatus)
+	}
+	s.runLine("cd ..")
+
+	data, _ := os.ReadFile(log)
+	sub := filepath.Join(dir, "sub")
+	want := "pre false\n" +
+		"cmd 1 1.500\n" + // cd in a hook runs no chpwd hooks
+		"pre cd ..\n" +
+		"cd " + sub + " " + dir + "\n"
+	if string(data) != want {
+		t.Errorf("hooks wrote\n%s\nwant\n%s", data, want)
+	}
+	if _, ok := os.LookupEnv("GOSH_COMMAND"); ok {
+		t.Errorf("GOSH_COMMAND is still set")
+	}
+}
+
+func TestFailingHookWithErrexit(t *testing.T) {
+	log := filepath.Join(t.TempDir(), "log")
+	s := &Shell{}
+	s.execLine("set -e")
+	s.execLine("hook precmd false")
+	s.execLine(`trap 'echo trap >> ` + log + `' ERR DEBUG`)
+	s.precmd()
+	if s.exiting {
+		t.Errorf("a failing precmd hook made set -e exit the shell")
+	}
+	if data, _ := os.ReadFile(log); len(data) != 0 {
+		t.Errorf("the hook fired traps: %q", data)
+	}
+}
+
+func TestGoHooks(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	t.Setenv("PWD", dir)
+	t.Setenv("OLDPWD", "")
+	os.Mkdir("sub", 0755)
+
+	var events []string
+	s := &Shell{}
+	s.OnPreexec(func(line string) { events = append(events, "preexec "+line) })
+	s.OnPrecmd(func(status int, d time.Duration) {
+		events = append(events, "precmd "+formatDuration(d, 0, false)+" "+string(rune('0'+status)))
+	})
+	s.OnChpwd(func(oldDir, newDir string) { events = append(events, "chpwd "+newDir) })
+
+	s.runLine("cd sub")
+	s.runLine("cd .") // no change, no chpwd
+	s.lastDuration = 2 * time.Second
+	s.lastStatus = 3
+	s.precmd()
+
+	want := []string{"preexec cd sub", "chpwd " + filepath.Join(dir, "sub"), "preexec cd .", "precmd 2 3"}
+	if strings.Join(events, "\n") != strings.Join(want, "\n") {
+		t.Errorf("events = %q, want %q", events, want)
+	}
+	if os.Getenv("OLDPWD") != dir || os.Getenv("PWD") != filepath.Join(dir, "sub") {
+		t.Errorf("OLDPWD, PWD = %q, %q", os.Getenv("OLDPWD"), os.Getenv("PWD"))
+	}
+}
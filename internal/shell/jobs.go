@@ -0,0 +1,354 @@
+package shell
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// job is a pipeline started in the background with "&".
+type job struct {
+	id       int
+	line     string
+	pids     []int
+	pgid     int
+	pipefail bool
+	done     chan struct{}
+	statuses []int
+}
+
+func (j *job) finished() bool {
+	select {
+	case <-j.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// status returns the exit status of the job's pipeline. It is only
+// meaningful once the job has finished.
+func (j *job) status() int {
+	return pipelineStatus(j.statuses, j.pipefail)
+}
+
+func (j *job) state() string {
+	switch {
+	case !j.finished():
+		return "Running"
+	case j.status() == 0:
+		return "Done"
+	default:
+		return fmt.Sprintf("Exit %d", j.status())
+	}
+}
+
+// startJob runs a pipeline in the background and registers it in the job
+// table. Background jobs read from /dev/null and get their own process
+// group so that kill %n reaches every stage.
+func (s *Shell) startJob(words []string, stdout, stderr io.Writer) {
+	segments := splitPipeline(words)
+	if len(segments) == 0 {
+		return
+	}
+	segments, out, errOut, err := s.expandPipeline(segments, stdout, stderr)
+	if err != nil {
+		fmt.Fprintf(stderr, "gosh: %v\n", err)
+		return
+	}
+	p := s.startPipeline(segments, nil, out, errOut, true)
+	if p == nil {
+		return
+	}
+
+	j := &job{
+		id:       s.nextJobID(),
+		line:     strings.Join(words, " "),
+		pids:     p.pids(),
+		pipefail: s.option("pipefail"),
+		done:     make(chan struct{}),
+	}
+	if len(j.pids) > 0 {
+		j.pgid = j.pids[0]
+		s.lastBgPid = j.pids[len(j.pids)-1]
+		fmt.Fprintf(os.Stderr, "[%d] %d\n", j.id, s.lastBgPid)
+	} else {
+		fmt.Fprintf(os.Stderr, "[%d]\n", j.id)
+	}
+	s.jobs = append(s.jobs, j)
+
+	go func() {
+		p.wait()
+		j.statuses = p.statuses
+		close(j.done)
+	}()
+}
+
+func (s *Shell) runningJobs() int {
+	n := 0
+	for _, j := range s.jobs {
+		if !j.finished() {
+			n++
+		}
+	}
+	return n
+}
+
+// hangupJobs sends SIGHUP to every running job, for shopt huponexit.
+func (s *Shell) hangupJobs() {
+	for _, j := range s.jobs {
+		if j.pgid != 0 && !j.finished() {
+			syscall.Kill(-j.pgid, syscall.SIGHUP)
+		}
+	}
+}
+
+func (s *Shell) nextJobID() int {
+	id := 1
+	for _, j := range s.jobs {
+		if j.id >= id {
+			id = j.id + 1
+		}
+	}
+	return id
+}
+
+func (s *Shell) removeJob(target *job) {
+	for i, j := range s.jobs {
+		if j == target {
+			s.jobs = append(s.jobs[:i], s.jobs[i+1:]...)
+			return
+		}
+	}
+}
+
+// jobMarker returns the "+" or "-" flag bash shows for the current and
+// previous job.
+func (s *Shell) jobMarker(j *job) byte {
+	switch {
+	case len(s.jobs) > 0 && s.jobs[len(s.jobs)-1] == j:
+		return '+'
+	case len(s.jobs) > 1 && s.jobs[len(s.jobs)-2] == j:
+		return '-'
+	default:
+		return ' '
+	}
+}
+
+// notifyJobs reports background jobs that have finished since the last
+// prompt and drops them from the job table.
+func (s *Shell) notifyJobs(w io.Writer) {
+	for _, j := range append([]*job(nil), s.jobs...) {
+		if j.finished() {
+			fmt.Fprintf(w, "[%d]%c  %-24s%s\n", j.id, s.jobMarker(j), j.state(), j.line)
+			s.removeJob(j)
+		}
+	}
+}
+
+// findJob resolves a job spec: %n, %% or %+ for the current job, %- for the
+// previous one, %string for a command prefix, and %?string for a substring.
+func (s *Shell) findJob(spec string) (*job, error) {
+	ref := strings.TrimPrefix(spec, "%")
+	switch ref {
+	case "", "%", "+":
+		if len(s.jobs) > 0 {
+			return s.jobs[len(s.jobs)-1], nil
+		}
+	case "-":
+		if len(s.jobs) > 1 {
+			return s.jobs[len(s.jobs)-2], nil
+		}
+	default:
+		if id, err := strconv.Atoi(ref); err == nil {
+			for _, j := range s.jobs {
+				if j.id == id {
+					return j, nil
+				}
+			}
+			break
+		}
+		var found *job
+		for _, j := range s.jobs {
+			var match bool
+			if sub, ok := strings.CutPrefix(ref, "?"); ok {
+				match = strings.Contains(j.line, sub)
+			} else {
+				match = strings.HasPrefix(j.line, ref)
+			}
+			if match {
+				if found != nil {
+					return nil, fmt.Errorf("%s: ambiguous job spec", spec)
+				}
+				found = j
+			}
+		}
+		if found != nil {
+			return found, nil
+		}
+	}
+	return nil, fmt.Errorf("%s: no such job", spec)
+}
+
+// jobForPid returns the job containing pid and the index of its stage.
+func (s *Shell) jobForPid(pid int) (*job, int) {
+	for _, j := range s.jobs {
+		for i, p := range j.pids {
+			if p == pid {
+				return j, i
+			}
+		}
+	}
+	return nil, -1
+}
+
+func (s *Shell) runJobs(args []string, stdout, stderr io.Writer) int {
+	mode := ""
+	if len(args) > 0 {
+		switch args[0] {
+		case "-l", "-p":
+			mode = args[0]
+		default:
+			fmt.Fprintf(stderr, "jobs: %s: invalid option\n", args[0])
+			return 2
+		}
+	}
+	for _, j := range append([]*job(nil), s.jobs...) {
+		switch mode {
+		case "-p":
+			for _, pid := range j.pids {
+				fmt.Fprintln(stdout, pid)
+			}
+			continue
+		case "-l":
+			pids := make([]string, len(j.pids))
+			for i, pid := range j.pids {
+				pids[i] = strconv.Itoa(pid)
+			}
+			fmt.Fprintf(stdout, "[%d]%c %s %-24s%s\n", j.id, s.jobMarker(j), strings.Join(pids, " "), j.state(), j.line)
+		default:
+			fmt.Fprintf(stdout, "[%d]%c  %-24s%s\n", j.id, s.jobMarker(j), j.state(), j.line)
+		}
+		if j.finished() {
+			s.removeJob(j)
+		}
+	}
+	return 0
+}
+
+// runWait waits for the given jobs or process IDs, or for every background
+// job when called without arguments, and returns the status of the last one.
+func (s *Shell) runWait(args []string, stderr io.Writer) int {
+	if len(args) == 0 {
+		for _, j := range append([]*job(nil), s.jobs...) {
+			<-j.done
+			s.removeJob(j)
+		}
+		return 0
+	}
+
+	status := 0
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "%") {
+			j, err := s.findJob(arg)
+			if err != nil {
+				fmt.Fprintf(stderr, "wait: %v\n", err)
+				status = 127
+				continue
+			}
+			<-j.done
+			status = j.status()
+			s.removeJob(j)
+			continue
+		}
+
+		pid, err := strconv.Atoi(arg)
+		if err != nil {
+			fmt.Fprintf(stderr, "wait: `%s': not a pid or valid job spec\n", arg)
+			status = 2
+			continue
+		}
+		j, stage := s.jobForPid(pid)
+		if j == nil {
+			fmt.Fprintf(stderr, "wait: pid %d is not a child of this shell\n", pid)
+			status = 127
+			continue
+		}
+		<-j.done
+		status = j.statuses[stage]
+		s.removeJob(j)
+	}
+	return status
+}
+
+// runKill sends a signal to processes or jobs, or lists signal names with -l.
+func (s *Shell) runKill(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprintln(stderr, "kill: usage: kill [-s sigspec | -n signum | -sigspec] pid | jobspec ... or kill -l [sigspec]")
+		return 2
+	}
+
+	sig := syscall.SIGTERM
+	switch arg := args[0]; {
+	case arg == "-l" || arg == "-L":
+		return listSignals(args[1:], stdout, stderr)
+	case arg == "-s" || arg == "-n":
+		if len(args) < 2 {
+			fmt.Fprintf(stderr, "kill: %s: option requires an argument\n", arg)
+			return 2
+		}
+		var ok bool
+		if sig, ok = parseSignal(args[1]); !ok {
+			fmt.Fprintf(stderr, "kill: %s: invalid signal specification\n", args[1])
+			return 1
+		}
+		args = args[2:]
+	case arg == "--":
+		args = args[1:]
+	case len(arg) > 1 && arg[0] == '-':
+		var ok bool
+		if sig, ok = parseSignal(arg[1:]); !ok {
+			fmt.Fprintf(stderr, "kill: %s: invalid signal specification\n", arg[1:])
+			return 1
+		}
+		args = args[1:]
+	}
+
+	status := 0
+	for _, arg := range args {
+		pid, err := s.killTarget(arg)
+		if err != nil {
+			fmt.Fprintf(stderr, "kill: %v\n", err)
+			status = 1
+			continue
+		}
+		if err := syscall.Kill(pid, sig); err != nil {
+			fmt.Fprintf(stderr, "kill: (%s) - %v\n", arg, err)
+			status = 1
+		}
+	}
+	return status
+}
+
+// killTarget resolves a kill operand to a pid, or to the negated process
+// group ID for a job spec.
+func (s *Shell) killTarget(arg string) (int, error) {
+	if !strings.HasPrefix(arg, "%") {
+		pid, err := strconv.Atoi(arg)
+		if err != nil {
+			return 0, fmt.Errorf("%s: arguments must be process or job IDs", arg)
+		}
+		return pid, nil
+	}
+	j, err := s.findJob(arg)
+	if err != nil {
+		return 0, err
+	}
+	if j.pgid == 0 {
+		return 0, fmt.Errorf("%s: no such process", arg)
+	}
+	return -j.pgid, nil
+}
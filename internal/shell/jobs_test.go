@@ -0,0 +1,106 @@
+package shell
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBackgroundJob(t *testing.T) {
+	s := &Shell{}
+	s.execLine("sleep 0.05 &")
+	if len(s.jobs) != 1 {
+		t.Fatalf("got %d jobs, want 1", len(s.jobs))
+	}
+	j := s.jobs[0]
+	if j.id != 1 || j.line != "sleep 0.05" || s.lastBgPid == 0 {
+		t.Errorf("job = %+v, lastBgPid = %d", j, s.lastBgPid)
+	}
+
+	var stderr bytes.Buffer
+	if status := s.runWait([]string{"%1"}, &stderr); status != 0 {
+		t.Errorf("wait status = %d, stderr %q", status, stderr.String())
+	}
+	if len(s.jobs) != 0 {
+		t.Errorf("job not removed after wait")
+	}
+}
+
+func TestWaitPidStatus(t *testing.T) {
+	s := &Shell{}
+	s.execLine(`sh -c "exit 7" &`)
+	var stderr bytes.Buffer
+	if got := s.execLine("wait $!"); got != 7 {
+		t.Errorf("wait $! = %d, want 7 (stderr %q)", got, stderr.String())
+	}
+	if got := s.runWait([]string{"999999"}, &stderr); got != 127 {
+		t.Errorf("wait on unknown pid = %d, want 127", got)
+	}
+}
+
+func TestKillJob(t *testing.T) {
+	s := &Shell{}
+	s.execLine("sleep 5 | sleep 5 &")
+	var stdout, stderr bytes.Buffer
+	if status := s.runKill([]string{"-TERM", "%1"}, &stdout, &stderr); status != 0 {
+		t.Fatalf("kill status = %d, stderr %q", status, stderr.String())
+	}
+	select {
+	case <-s.jobs[0].done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("job still running after kill %1")
+	}
+	if got := s.jobs[0].status(); got != 128+15 {
+		t.Errorf("job status = %d, want %d", got, 128+15)
+	}
+}
+
+func TestFindJob(t *testing.T) {
+	done := make(chan struct{})
+	s := &Shell{jobs: []*job{
+		{id: 1, line: "make build", done: done},
+		{id: 2, line: "sleep 10", done: done},
+		{id: 3, line: "make test", done: done},
+	}}
+	tests := []struct {
+		spec   string
+		wantID int
+		errMsg string
+	}{
+		{"%1", 1, ""},
+		{"%%", 3, ""},
+		{"%+", 3, ""},
+		{"%-", 2, ""},
+		{"%sleep", 2, ""},
+		{"%?test", 3, ""},
+		{"%make", 0, "ambiguous"},
+		{"%9", 0, "no such job"},
+	}
+	for _, tt := range tests {
+		j, err := s.findJob(tt.spec)
+		if tt.errMsg != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+				t.Errorf("findJob(%q) error = %v, want %q", tt.spec, err, tt.errMsg)
+			}
+			continue
+		}
+		if err != nil || j.id != tt.wantID {
+			t.Errorf("findJob(%q) = %v, %v, want job %d", tt.spec, j, err, tt.wantID)
+		}
+	}
+}
+
+func TestNotifyJobs(t *testing.T) {
+	done := make(chan struct{})
+	close(done)
+	s := &Shell{jobs: []*job{{id: 1, line: "false", done: done, statuses: []int{1}}}}
+	var buf bytes.Buffer
+	s.notifyJobs(&buf)
+	if !strings.Contains(buf.String(), "Exit 1") || !strings.Contains(buf.String(), "false") {
+		t.Errorf("notification = %q", buf.String())
+	}
+	if len(s.jobs) != 0 {
+		t.Error("finished job not removed")
+	}
+}
@@ -0,0 +1,202 @@
+package shell
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/chzyer/readline"
+)
+
+// menuQueryItems is the number of candidates above which the menu asks
+// before showing them all, like bash's completion-query-items.
+const menuQueryItems = 100
+
+// completionMenu lists the candidates for a word below the edit line. It
+// opens on the second Tab when there is no unique match. Before the first
+// move nothing is selected, and the menu is just a listing.
+type completionMenu struct {
+	items    []completion
+	selected int  // index into items, or -1
+	confirm  bool // asking whether to display all the items
+	rows     int  // rows of the layout as last rendered
+	top      int  // first row shown, for menus taller than a page
+}
+
+// menuAction is the result of a key pressed while the menu is open.
+type menuAction int
+
+const (
+	menuContinue menuAction = iota // the key was used by the menu
+	menuAccept                     // put the selected item in the line
+	menuClose                      // close the menu and drop the key
+	menuPass                       // close the menu and handle the key
+)
+
+// charBackTab is the rune the editor sees for Shift-Tab. readline drops
+// the key's escape sequence, so keyReader replaces it before readline reads
+// it. It is a private-use character of the same UTF-8 length.
+const charBackTab = '\uE000'
+
+func newCompletionMenu(items []completion) *completionMenu {
+	return &completionMenu{items: items, selected: -1, confirm: len(items) > menuQueryItems}
+}
+
+// key handles a key pressed while the menu is open. Tab, Shift-Tab and the
+// up and down arrows move through the items, and the left and right arrows
+// move across columns. Enter accepts the selected item, and Ctrl-G closes
+// the menu. Any other key closes the menu and is handled as usual.
+func (m *completionMenu) key(r rune) menuAction {
+	if m.confirm {
+		switch r {
+		case 'y', 'Y', ' ', readline.CharTab:
+			m.confirm = false
+			return menuContinue
+		}
+		return menuClose
+	}
+	rows := max(m.rows, 1)
+	switch r {
+	case readline.CharTab, readline.CharNext:
+		m.move(1)
+	case charBackTab, readline.CharPrev:
+		m.move(-1)
+	case readline.CharForward:
+		m.move(rows)
+	case readline.CharBackward:
+		m.move(-rows)
+	case readline.CharEnter, readline.CharCtrlJ:
+		if m.selected < 0 {
+			return menuPass
+		}
+		return menuAccept
+	case readline.CharBell:
+		return menuClose
+	default:
+		return menuPass
+	}
+	return menuContinue
+}
+
+// move moves the selection by n items, wrapping around at either end. The
+// first move forward selects the first item, and the first move back the
+// last.
+func (m *completionMenu) move(n int) {
+	count := len(m.items)
+	switch {
+	case m.selected < 0 && n > 0:
+		m.selected = 0
+	case m.selected < 0:
+		m.selected = count - 1
+	default:
+		m.selected = ((m.selected+n)%count + count) % count
+	}
+}
+
+// render lays the items out to fit width columns and at most height rows,
+// and returns the lines of the menu. Items are sorted down the columns, as
+// bash lists them, or one per row with their descriptions if any has one.
+// A menu taller than height shows the page with the selected item and a
+// status line.
+func (m *completionMenu) render(width, height int) []string {
+	if m.confirm {
+		return []string{fmt.Sprintf("Display all %d possibilities? (y or n)", len(m.items))}
+	}
+	width = max(width-1, 1) // stay off the last column, so lines never wrap
+	nameWidth, described := 0, false
+	for _, it := range m.items {
+		nameWidth = max(nameWidth, textWidth(it.display))
+		described = described || it.description != ""
+	}
+
+	cols := 1
+	if !described {
+		cols = max(1, (width+2)/(nameWidth+2))
+	}
+	m.rows = (len(m.items) + cols - 1) / cols
+	cols = (len(m.items) + m.rows - 1) / m.rows
+
+	page := m.rows
+	if page > height {
+		page = max(height-1, 1)
+	}
+	if m.selected >= 0 {
+		row := m.selected % m.rows
+		m.top = min(max(m.top, row-page+1), row)
+	}
+	m.top = min(m.top, m.rows-page)
+
+	var lines []string
+	for row := m.top; row < m.top+page; row++ {
+		var sb strings.Builder
+		used := 0
+		for col := 0; col < cols; col++ {
+			i := col*m.rows + row
+			if i >= len(m.items) {
+				break
+			}
+			cell := m.items[i].display
+			if described && m.items[i].description != "" {
+				cell += strings.Repeat(" ", nameWidth-textWidth(cell)) + "  -- " + m.items[i].description
+			}
+			if i+m.rows < len(m.items) {
+				cell += strings.Repeat(" ", nameWidth-textWidth(cell)+2)
+			}
+			cell = truncateText(cell, width-used)
+			used += textWidth(cell)
+			if i == m.selected {
+				cell = "\033[7m" + cell + "\033[0m"
+			}
+			sb.WriteString(cell)
+		}
+		lines = append(lines, sb.String())
+	}
+	if page < m.rows {
+		lines = append(lines, truncateText(fmt.Sprintf("rows %d-%d of %d", m.top+1, m.top+page, m.rows), width))
+	}
+	return lines
+}
+
+// textWidth returns the number of columns s takes on the terminal.
+func textWidth(s string) int {
+	var r readline.Runes
+	return r.WidthAll(r.ColorFilter([]rune(s)))
+}
+
+// truncateText cuts s to at most width columns.
+func truncateText(s string, width int) string {
+	var r readline.Runes
+	used := 0
+	for i, c := range s {
+		if used += r.Width(c); used > width {
+			return s[:i]
+		}
+	}
+	return s
+}
+
+// menuOverlay returns what the editor paints after the line to show the
+// menu: its lines, and the cursor movement back to the end of the line,
+// where readline expects the cursor to be. prompt is the prompt before
+// the line. The movement is relative, so it still works if showing the
+// menu scrolls the screen.
+func menuOverlay(lines []string, prompt string, line []rune, width int) string {
+	if i := strings.LastIndexByte(prompt, '\n'); i >= 0 {
+		prompt = prompt[i+1:]
+	}
+	var r readline.Runes
+	end := textWidth(prompt) + r.WidthAll(line)
+	var sb strings.Builder
+	if end > 0 && width > 0 && end%width == 0 {
+		// The cursor waits past the last column; move it to the next line
+		// first, as readline does.
+		sb.WriteString(" \b")
+	}
+	for _, l := range lines {
+		sb.WriteString("\r\n" + l)
+	}
+	fmt.Fprintf(&sb, "\033[%dA\r", len(lines))
+	if width > 0 && end%width > 0 {
+		fmt.Fprintf(&sb, "\033[%dC", end%width)
+	}
+	return sb.String()
+}
@@ -0,0 +1,179 @@
+package shell
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/chzyer/readline"
+)
+
+func menuItems(names ...string) []completion {
+	var items []completion
+	for _, n := range names {
+		items = append(items, completion{word: n, value: n, display: n, final: true})
+	}
+	return items
+}
+
+func TestMenuRenderColumns(t *testing.T) {
+	m := newCompletionMenu(menuItems("alpha", "beta", "gamma", "delta", "epsilon"))
+	// Columns of 7+2 in 20-1 columns: two columns of three rows, filled
+	// down the columns.
+	got := m.render(20, 10)
+	want := []string{
+		"alpha    delta",
+		"beta     epsilon",
+		"gamma",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("render =\n%q\nwant\n%q", got, want)
+	}
+
+	m.key(readline.CharTab)
+	m.key(readline.CharForward)
+	if m.selected != 3 {
+		t.Errorf("Tab, Right selected %d, want 3 (delta)", m.selected)
+	}
+	if got := m.render(20, 10); got[0] != "alpha    \033[7mdelta\033[0m" {
+		t.Errorf("selected row = %q", got[0])
+	}
+}
+
+func TestMenuRenderDescriptions(t *testing.T) {
+	items := menuItems("--all", "-a", "--color=")
+	items[0].description = "show all"
+	items[2].description = "colorize the output"
+	m := newCompletionMenu(items)
+	want := []string{
+		"--all     -- show all",
+		"-a",
+		"--color=  -- colorize",
+	}
+	if got := m.render(22, 10); !slices.Equal(got, want) {
+		t.Errorf("render =\n%q\nwant\n%q", got, want)
+	}
+}
+
+func TestMenuPagination(t *testing.T) {
+	var names []string
+	for i := range 30 {
+		names = append(names, fmt.Sprintf("item%02d", i))
+	}
+	m := newCompletionMenu(menuItems(names...))
+	got := m.render(20, 5) // two columns of 15 rows, four per page
+	if len(got) != 5 || got[0] != "item00  item15" || got[4] != "rows 1-4 of 15" {
+		t.Fatalf("first page = %q", got)
+	}
+
+	m.key(charBackTab) // selects the last item
+	got = m.render(20, 5)
+	if got[3] != "item14  \033[7mitem29\033[0m" || got[4] != "rows 12-15 of 15" {
+		t.Errorf("page with the last item = %q", got)
+	}
+	m.key(readline.CharTab) // wraps to the first
+	if got = m.render(20, 5); got[4] != "rows 1-4 of 15" {
+		t.Errorf("page after wrapping = %q", got)
+	}
+}
+
+func TestMenuConfirm(t *testing.T) {
+	names := make([]string, menuQueryItems+1)
+	for i := range names {
+		names[i] = fmt.Sprint(i)
+	}
+	m := newCompletionMenu(menuItems(names...))
+	if got := m.render(80, 24); len(got) != 1 || got[0] != "Display all 101 possibilities? (y or n)" {
+		t.Errorf("render = %q", got)
+	}
+	if m.key('y') != menuContinue || m.confirm {
+		t.Errorf("y did not show the menu")
+	}
+	m = newCompletionMenu(menuItems(names...))
+	if m.key('n') != menuClose {
+		t.Errorf("n did not close the menu")
+	}
+}
+
+func TestMenuKeys(t *testing.T) {
+	m := newCompletionMenu(menuItems("a", "b", "c"))
+	if m.key(readline.CharEnter) != menuPass {
+		t.Errorf("Enter with nothing selected should be handled as usual")
+	}
+	for _, k := range []rune{readline.CharNext, readline.CharNext, readline.CharPrev} {
+		if m.key(k) != menuContinue {
+			t.Errorf("key %d did not move", k)
+		}
+	}
+	if m.selected != 0 {
+		t.Errorf("selected = %d, want 0", m.selected)
+	}
+	if m.key(readline.CharEnter) != menuAccept || m.key(readline.CharBell) != menuClose || m.key('x') != menuPass {
+		t.Errorf("unexpected actions for Enter, Ctrl-G or x")
+	}
+}
+
+func TestMenuOverlay(t *testing.T) {
+	lines := []string{"one", "two"}
+	if got := menuOverlay(lines, "$ ", []rune("ls a"), 80); got != "\r\none\r\ntwo\033[2A\r\033[6C" {
+		t.Errorf("overlay = %q", got)
+	}
+	// A line that fills the last column: move to the next line first, and
+	// back to its start.
+	if got := menuOverlay(lines, "> \n$ ", []rune("12345678"), 10); got != " \b\r\none\r\ntwo\033[2A\r" {
+		t.Errorf("overlay at the edge = %q", got)
+	}
+}
+
+func TestEditorCompletionMenu(t *testing.T) {
+	completionDir(t)
+	e, _ := newTestEditor()
+	e.termSize = func() (int, int) { return 40, 10 }
+	e.s.editor = e
+	e.s.shopts = map[string]bool{"highlight": false}
+	c := &completer{s: e.s}
+
+	input := []rune("cat d")
+	if out, _ := c.Do(input, len(input)); len(out) != 1 || string(out[0]) != "ir" || e.menu != nil {
+		t.Fatalf("the first Tab inserted %q; menu %v", out, e.menu)
+	}
+	input = []rune("cat dir")
+	c.Do(input, len(input))
+	if e.menu != nil {
+		t.Fatal("a Tab without progress opened the menu")
+	}
+	c.Do(input, len(input))
+	if e.menu == nil {
+		t.Fatal("the second Tab did not open the menu")
+	}
+	painted := string(e.Paint(input, len(input)))
+	if !strings.HasPrefix(painted, "cat dir\r\ndir/      dir two/") {
+		t.Errorf("painted = %q", painted)
+	}
+
+	if _, ok := e.filter(charBackTab); ok || e.menu.selected != 1 {
+		t.Fatalf("Shift-Tab selected %d", e.menu.selected)
+	}
+	r, ok := e.filter(readline.CharEnter)
+	if r != readline.CharTab || !ok || e.menu != nil {
+		t.Fatalf("Enter gave %q, %v; menu %v", r, ok, e.menu)
+	}
+	out, _ := c.Do(input, len(input))
+	if len(out) != 1 || string(out[0]) != `\ two/` {
+		t.Errorf("accepting inserted %q, want %q", out, `\ two/`)
+	}
+}
+
+type stringReader struct{ io.Reader }
+
+func (stringReader) Close() error { return nil }
+
+func TestKeyReader(t *testing.T) {
+	k := keyReader{ReadCloser: stringReader{strings.NewReader("a\x1b[Zb\x1b[Z\x1b[A")}}
+	data, _ := io.ReadAll(k)
+	if want := "a" + string(charBackTab) + "b" + string(charBackTab) + "\x1b[A"; string(data) != want {
+		t.Errorf("read %q, want %q", data, want)
+	}
+}
@@ -0,0 +1,270 @@
+package shell
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// setOption is an option controlled by set -o, with its single-letter flag
+// (0 if it has none).
+type setOption struct {
+	name string
+	flag byte
+}
+
+var setOptions = []setOption{
+	{"emacs", 0},
+	{"errexit", 'e'},
+	{"noclobber", 'C'},
+	{"nounset", 'u'},
+	{"pipefail", 0},
+	{"vi", 0},
+	{"xtrace", 'x'},
+}
+
+// shoptOptions lists the gosh-specific toggles managed by shopt, with their
+// default values.
+var shoptOptions = map[string]bool{
+	// autosuggest shows the rest of a matching history line after the
+	// cursor as a line is typed.
+	"autosuggest": true,
+	// checkjobs makes the first exit with running jobs print a warning
+	// instead of exiting.
+	"checkjobs": false,
+	// histjson writes the history file as JSON records with the start time,
+	// duration, directory, exit status, host and session of each command.
+	"histjson": false,
+	// histredact redacts secrets in lines written to history files and
+	// keeps lines typed with a leading blank out of them.
+	"histredact": true,
+	// sharehistory appends each command to HISTFILE as soon as it finishes
+	// and reads the commands other sessions have added before each prompt.
+	"sharehistory": false,
+	// highlight colors the command line as it is typed.
+	"highlight": true,
+	// huponexit sends SIGHUP to every background job when the shell exits.
+	"huponexit": false,
+	// transientprompt redraws each entered line with GOSH_TRANSIENT_PROMPT
+	// in place of PS1 and RPROMPT.
+	"transientprompt": false,
+}
+
+// option reports whether a set -o option is enabled. emacs and vi select
+// the editing mode; emacs is on whenever vi is off.
+func (s *Shell) option(name string) bool {
+	if name == "emacs" {
+		return !s.options["vi"]
+	}
+	return s.options[name]
+}
+
+func (s *Shell) setOption(name string, on bool) {
+	if s.options == nil {
+		s.options = map[string]bool{}
+	}
+	if name == "emacs" {
+		name, on = "vi", !on
+	}
+	s.options[name] = on
+	if name == "vi" && s.rl != nil {
+		s.rl.SetVimMode(on)
+	}
+}
+
+// shopt reports whether a shopt toggle is enabled.
+func (s *Shell) shopt(name string) bool {
+	if on, ok := s.shopts[name]; ok {
+		return on
+	}
+	return shoptOptions[name]
+}
+
+// optionFlags returns the value of $-: the letters of the enabled options.
+func (s *Shell) optionFlags() string {
+	var sb strings.Builder
+	for _, o := range setOptions {
+		if o.flag != 0 && s.option(o.name) {
+			sb.WriteByte(o.flag)
+		}
+	}
+	return sb.String()
+}
+
+func lookupSetOption(name string) bool {
+	for _, o := range setOptions {
+		if o.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func lookupSetFlag(flag byte) (string, bool) {
+	for _, o := range setOptions {
+		if o.flag != 0 && o.flag == flag {
+			return o.name, true
+		}
+	}
+	return "", false
+}
+
+// runSet implements set: -e/+e style flags, -o/+o name, and listing.
+func (s *Shell) runSet(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		env := os.Environ()
+		sort.Strings(env)
+		for _, kv := range env {
+			name, value, _ := strings.Cut(kv, "=")
+			fmt.Fprintf(stdout, "%s=%s\n", name, shellQuote(value))
+		}
+		return 0
+	}
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" || arg == "-" {
+			break
+		}
+		if len(arg) < 2 || (arg[0] != '-' && arg[0] != '+') {
+			fmt.Fprintf(stderr, "set: %s: invalid option\n", arg)
+			return 2
+		}
+		on := arg[0] == '-'
+		for _, c := range []byte(arg[1:]) {
+			if c != 'o' {
+				name, ok := lookupSetFlag(c)
+				if !ok {
+					fmt.Fprintf(stderr, "set: %c%c: invalid option\n", arg[0], c)
+					return 2
+				}
+				s.setOption(name, on)
+				continue
+			}
+			if i+1 >= len(args) {
+				s.printSetOptions(stdout, on)
+				continue
+			}
+			i++
+			if !lookupSetOption(args[i]) {
+				fmt.Fprintf(stderr, "set: %s: invalid option name\n", args[i])
+				return 1
+			}
+			s.setOption(args[i], on)
+		}
+	}
+	return 0
+}
+
+// printSetOptions lists the set -o options as a table, or with plus set,
+// as the set commands that would restore them.
+func (s *Shell) printSetOptions(stdout io.Writer, table bool) {
+	for _, o := range setOptions {
+		on := s.option(o.name)
+		if table {
+			state := "off"
+			if on {
+				state = "on"
+			}
+			fmt.Fprintf(stdout, "%-15s\t%s\n", o.name, state)
+			continue
+		}
+		sign := '+'
+		if on {
+			sign = '-'
+		}
+		fmt.Fprintf(stdout, "set %co %s\n", sign, o.name)
+	}
+}
+
+// runShopt implements shopt [-s|-u] [-p] [-q] [name...].
+func (s *Shell) runShopt(args []string, stdout, stderr io.Writer) int {
+	var set, unset, print, quiet bool
+	for len(args) > 0 && strings.HasPrefix(args[0], "-") {
+		for _, c := range args[0][1:] {
+			switch c {
+			case 's':
+				set = true
+			case 'u':
+				unset = true
+			case 'p':
+				print = true
+			case 'q':
+				quiet = true
+			default:
+				fmt.Fprintf(stderr, "shopt: -%c: invalid option\n", c)
+				return 2
+			}
+		}
+		args = args[1:]
+	}
+	if set && unset {
+		fmt.Fprintln(stderr, "shopt: cannot set and unset shell options simultaneously")
+		return 1
+	}
+
+	names := args
+	if len(names) == 0 {
+		for name := range shoptOptions {
+			if (set && !s.shopt(name)) || (unset && s.shopt(name)) {
+				continue
+			}
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		set, unset = false, false
+	}
+
+	status := 0
+	for _, name := range names {
+		if _, ok := shoptOptions[name]; !ok {
+			fmt.Fprintf(stderr, "shopt: %s: invalid shell option name\n", name)
+			status = 1
+			continue
+		}
+		switch {
+		case set || unset:
+			if s.shopts == nil {
+				s.shopts = map[string]bool{}
+			}
+			s.shopts[name] = set
+		case quiet:
+			if !s.shopt(name) {
+				status = 1
+			}
+		case print:
+			flag := 'u'
+			if s.shopt(name) {
+				flag = 's'
+			}
+			fmt.Fprintf(stdout, "shopt -%c %s\n", flag, name)
+		default:
+			state := "off"
+			if s.shopt(name) {
+				state = "on"
+			}
+			fmt.Fprintf(stdout, "%-15s\t%s\n", name, state)
+		}
+	}
+	return status
+}
+
+// trace prints a command to stderr for set -x, prefixed by PS4. assigns
+// are the raw NAME=value words that precede the command.
+func (s *Shell) trace(assigns, parts []string) {
+	if !s.option("xtrace") || len(assigns)+len(parts) == 0 {
+		return
+	}
+	ps4, control := s.prompt("PS4")
+	var words []string
+	for _, a := range assigns {
+		name, value, _ := s.assignValue(a)
+		words = append(words, name+"="+shellQuote(value))
+	}
+	for _, p := range parts {
+		words = append(words, shellQuote(p))
+	}
+	fmt.Fprintf(os.Stderr, "%s%s%s\n", control, ps4, strings.Join(words, " "))
+}
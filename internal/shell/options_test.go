@@ -0,0 +1,192 @@
+package shell
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunSet(t *testing.T) {
+	s := &Shell{}
+	var stdout, stderr bytes.Buffer
+
+	s.runSet([]string{"-eu", "-o", "pipefail", "-C"}, &stdout, &stderr)
+	for _, name := range []string{"errexit", "nounset", "pipefail", "noclobber"} {
+		if !s.option(name) {
+			t.Errorf("option %s not enabled", name)
+		}
+	}
+	if got := s.optionFlags(); got != "eCu" {
+		t.Errorf("$- = %q, want %q", got, "eCu")
+	}
+
+	s.runSet([]string{"+e", "+o", "pipefail"}, &stdout, &stderr)
+	if s.option("errexit") || s.option("pipefail") {
+		t.Error("options not disabled by +e / +o")
+	}
+
+	s.runSet([]string{"+o"}, &stdout, &stderr)
+	if !strings.Contains(stdout.String(), "set -o nounset\n") || !strings.Contains(stdout.String(), "set +o xtrace\n") {
+		t.Errorf("set +o output = %q", stdout.String())
+	}
+
+	if status := s.runSet([]string{"-Q"}, &stdout, &stderr); status != 2 {
+		t.Errorf("invalid flag returned %d, want 2", status)
+	}
+	if status := s.runSet([]string{"-o", "bogus"}, &stdout, &stderr); status != 1 {
+		t.Errorf("invalid option name returned %d, want 1", status)
+	}
+}
+
+func TestErrexit(t *testing.T) {
+	tests := []struct {
+		line     string
+		wantExit bool
+	}{
+		{"false", true},
+		{"false && true", false},
+		{"false || true", false},
+		{"true && false", true},
+		{"! true", false},
+		{"false | true", false},
+		{"true; false; true", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			s := &Shell{}
+			s.setOption("errexit", true)
+			s.execLine(tt.line)
+			if s.exiting != tt.wantExit {
+				t.Errorf("exiting = %v, want %v", s.exiting, tt.wantExit)
+			}
+		})
+	}
+}
+
+func TestNounset(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out")
+	s := &Shell{}
+	s.setOption("nounset", true)
+	status := s.execLine("echo $GOSH_SURELY_UNSET > " + out + " 2> /dev/null")
+	if status != 1 {
+		t.Errorf("status = %d, want 1", status)
+	}
+	if _, err := os.Stat(out); err == nil {
+		t.Error("command ran despite unbound variable")
+	}
+	if status := s.execLine("echo $? > /dev/null"); status != 0 {
+		t.Errorf("special parameter treated as unset: status %d", status)
+	}
+
+	for _, line := range []string{"GOSH_NOUNSET_X=$GOSH_SURELY_UNSET", "GOSH_NOUNSET_X=$GOSH_SURELY_UNSET touch " + out} {
+		if status := s.execLine(line); status != 1 {
+			t.Errorf("%s: status = %d, want 1", line, status)
+		}
+		if _, ok := os.LookupEnv("GOSH_NOUNSET_X"); ok {
+			t.Errorf("%s: variable was assigned", line)
+		}
+	}
+	if _, err := os.Stat(out); err == nil {
+		t.Error("command ran despite unbound variable in an assignment")
+	}
+}
+
+func TestPipefail(t *testing.T) {
+	line := `sh -c "exit 3" | sh -c "exit 4" | true`
+	s := &Shell{}
+	if got := s.execLine(line); got != 0 {
+		t.Errorf("without pipefail = %d, want 0", got)
+	}
+	s.setOption("pipefail", true)
+	if got := s.execLine(line); got != 4 {
+		t.Errorf("with pipefail = %d, want 4", got)
+	}
+	if got := pipelineStatus([]int{0, 0, 0}, true); got != 0 {
+		t.Errorf("pipelineStatus all zero = %d, want 0", got)
+	}
+}
+
+func TestXtrace(t *testing.T) {
+	f, err := os.CreateTemp(t.TempDir(), "stderr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	orig := os.Stderr
+	os.Stderr = f
+	defer func() { os.Stderr = orig }()
+	t.Setenv("PS4", ">> ")
+
+	s := &Shell{}
+	s.setOption("xtrace", true)
+	s.execLine(`GOSH_X="a b"; echo "$GOSH_X" c > /dev/null`)
+
+	data, _ := os.ReadFile(f.Name())
+	want := ">> GOSH_X='a b'\n>> echo 'a b' c\n"
+	if string(data) != want {
+		t.Errorf("trace = %q, want %q", data, want)
+	}
+}
+
+func TestRunShopt(t *testing.T) {
+	s := &Shell{}
+	var stdout, stderr bytes.Buffer
+
+	if status := s.runShopt([]string{"-q", "checkjobs"}, &stdout, &stderr); status != 1 {
+		t.Errorf("shopt -q on unset option = %d, want 1", status)
+	}
+	s.runShopt([]string{"-s", "checkjobs"}, &stdout, &stderr)
+	if !s.shopt("checkjobs") {
+		t.Error("checkjobs not set")
+	}
+	s.runShopt([]string{"-p"}, &stdout, &stderr)
+	if !strings.Contains(stdout.String(), "shopt -s checkjobs\n") || !strings.Contains(stdout.String(), "shopt -u huponexit\n") {
+		t.Errorf("shopt -p output = %q", stdout.String())
+	}
+	if status := s.runShopt([]string{"-s", "nope"}, &stdout, &stderr); status != 1 {
+		t.Errorf("unknown option returned %d, want 1", status)
+	}
+}
+
+func TestCheckjobs(t *testing.T) {
+	s := &Shell{shopts: map[string]bool{"checkjobs": true}}
+	s.execLine("sleep 5 &")
+	defer s.runKill([]string{"%1"}, &bytes.Buffer{}, &bytes.Buffer{})
+
+	var stderr bytes.Buffer
+	s.lineNo = 1
+	s.runExit(nil, &stderr)
+	if s.exiting || !strings.Contains(stderr.String(), "running jobs") {
+		t.Fatalf("first exit: exiting = %v, stderr %q", s.exiting, stderr.String())
+	}
+	s.lineNo = 2
+	s.runExit(nil, &stderr)
+	if !s.exiting {
+		t.Error("second consecutive exit did not exit")
+	}
+}
+
+func TestEditingModeOptions(t *testing.T) {
+	s := &Shell{}
+	if !s.option("emacs") || s.option("vi") {
+		t.Fatal("the editing mode is not emacs by default")
+	}
+	var stdout, stderr bytes.Buffer
+	s.runSet([]string{"-o", "vi"}, &stdout, &stderr)
+	if s.option("emacs") || !s.option("vi") {
+		t.Error("set -o vi did not leave emacs mode")
+	}
+	s.runSet([]string{"+o", "vi"}, &stdout, &stderr)
+	if !s.option("emacs") {
+		t.Error("set +o vi did not go back to emacs mode")
+	}
+	s.runSet([]string{"+o", "emacs"}, &stdout, &stderr)
+	if !s.option("vi") {
+		t.Error("set +o emacs did not switch to vi mode")
+	}
+	s.runSet([]string{"+o"}, &stdout, &stderr)
+	if !strings.Contains(stdout.String(), "set +o emacs\n") || !strings.Contains(stdout.String(), "set -o vi\n") {
+		t.Errorf("set +o output = %q", stdout.String())
+	}
+}
@@ -6,12 +6,27 @@ import "strings"
 // double quotes, and backslash escapes.
 func parseArgs(line string) []string {
 	var args []string
+	for _, word := range splitWords(line) {
+		args = append(args, expandWord(word, nil)...)
+	}
+	return args
+}
+
+// splitWords splits a command line on unquoted blanks without removing
+// quotes or escapes, so that expansion can be deferred until each command
+// runs and operators can be told apart from quoted text. The control
+// operators ";", "&", "&&", "|" and "||" always form words of their own,
+// except directly after ">" as in "2>&1" and ">|". An unquoted newline ends
+// a command like ";" unless it follows an operator, and a backslash before
+// a newline joins the two lines.
+func splitWords(line string) []string {
+	var words []string
 	var cur strings.Builder
 	inSingle, inDouble := false, false
 
 	flush := func() {
 		if cur.Len() > 0 {
-			args = append(args, cur.String())
+			words = append(words, cur.String())
 			cur.Reset()
 		}
 	}
@@ -19,6 +34,174 @@ func parseArgs(line string) []string {
 	for i := 0; i < len(line); i++ {
 		c := line[i]
 		switch {
+		case inSingle:
+			if c == '\'' {
+				inSingle = false
+			}
+			cur.WriteByte(c)
+		case inDouble:
+			if c == '"' {
+				inDouble = false
+			} else if c == '\\' && i+1 < len(line) && line[i+1] == '\n' {
+				i++
+				continue
+			} else if c == '\\' && i+1 < len(line) {
+				cur.WriteByte(c)
+				i++
+				c = line[i]
+			}
+			cur.WriteByte(c)
+		case c == '\'':
+			inSingle = true
+			cur.WriteByte(c)
+		case c == '"':
+			inDouble = true
+			cur.WriteByte(c)
+		case c == ' ' || c == '\t':
+			flush()
+		case c == '\n':
+			flush()
+			if n := len(words); n > 0 && !isOperator(words[n-1]) {
+				words = append(words, ";")
+			}
+		case c == '\\' && i+1 < len(line) && line[i+1] == '\n':
+			i++
+		case c == '\\' && i+1 < len(line):
+			cur.WriteByte(c)
+			cur.WriteByte(line[i+1])
+			i++
+		case c == ';' || (c == '|' || c == '&') && !strings.HasSuffix(cur.String(), ">"):
+			flush()
+			op := line[i : i+1]
+			if c != ';' && i+1 < len(line) && line[i+1] == c {
+				op = line[i : i+2]
+				i++
+			}
+			words = append(words, op)
+		default:
+			cur.WriteByte(c)
+		}
+	}
+	flush()
+	return words
+}
+
+// isOperator reports whether a word produced by splitWords is one of the
+// control operators.
+func isOperator(word string) bool {
+	switch word {
+	case ";", "&", "&&", "|", "||":
+		return true
+	}
+	return false
+}
+
+// incompleteInput reports whether line stops in the middle of a command:
+// inside quotes, after a backslash that continues it on the next line, or
+// after "|", "&&" or "||", which need a command after them.
+func incompleteInput(line string) bool {
+	inSingle, inDouble := false, false
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case inSingle:
+			inSingle = c != '\''
+		case c == '\\':
+			if i+1 == len(line) {
+				return true
+			}
+			i++
+		case inDouble:
+			inDouble = c != '"'
+		case c == '\'':
+			inSingle = true
+		case c == '"':
+			inDouble = true
+		}
+	}
+	if inSingle || inDouble {
+		return true
+	}
+	words := splitWords(line)
+	if n := len(words); n > 1 {
+		switch words[n-1] {
+		case "|", "&&", "||":
+			return true
+		}
+	}
+	return false
+}
+
+// joinLines divides text into lines and joins each incomplete one with
+// those after it, so that every line holds whole commands. A line starting
+// with # is left alone, so that the comments of a file of commands do not
+// join what follows them. rest is the incomplete text at the end, if any.
+func joinLines(text string) (lines []string, rest string) {
+	for _, line := range strings.Split(text, "\n") {
+		if rest == "" && strings.HasPrefix(strings.TrimSpace(line), "#") {
+			lines = append(lines, line)
+			continue
+		}
+		rest += line
+		if incompleteInput(rest) {
+			rest += "\n"
+			continue
+		}
+		lines = append(lines, rest)
+		rest = ""
+	}
+	return lines, strings.TrimSuffix(rest, "\n")
+}
+
+// expandWord removes quotes and escapes from a word produced by splitWords
+// and substitutes $NAME, ${NAME} and special parameters using lookup. An
+// unquoted "~" alone or before "/" at the start of the word becomes $HOME.
+// The results of unquoted substitutions are split on blanks, so one word may
+// expand to several fields; empty fields are dropped. A nil lookup leaves
+// dollar signs and tildes untouched.
+func expandWord(word string, lookup func(string) (string, bool)) []string {
+	var fields []string
+	var cur strings.Builder
+	inSingle, inDouble := false, false
+
+	flush := func() {
+		if cur.Len() > 0 {
+			fields = append(fields, cur.String())
+			cur.Reset()
+		}
+	}
+
+	start := 0
+	if lookup != nil && (word == "~" || strings.HasPrefix(word, "~/")) {
+		home, _ := lookup("HOME")
+		cur.WriteString(home)
+		start = 1
+	}
+	for i := start; i < len(word); i++ {
+		c := word[i]
+		if c == '$' && !inSingle && lookup != nil {
+			if name, n := paramName(word[i+1:]); n > 0 {
+				value, _ := lookup(name)
+				i += n
+				if inDouble {
+					cur.WriteString(value)
+					continue
+				}
+				if value != "" && isBlank(value[0]) {
+					flush()
+				}
+				for j, field := range strings.Fields(value) {
+					if j > 0 {
+						flush()
+					}
+					cur.WriteString(field)
+				}
+				if value != "" && isBlank(value[len(value)-1]) {
+					flush()
+				}
+				continue
+			}
+		}
+		switch {
 		case inSingle:
 			if c == '\'' {
 				inSingle = false
@@ -28,8 +211,8 @@ func parseArgs(line string) []string {
 		case inDouble:
 			if c == '"' {
 				inDouble = false
-			} else if c == '\\' && i+1 < len(line) {
-				next := line[i+1]
+			} else if c == '\\' && i+1 < len(word) {
+				next := word[i+1]
 				if next == '"' || next == '\\' || next == '$' {
 					cur.WriteByte(next)
 					i++
@@ -43,17 +226,85 @@ func parseArgs(line string) []string {
 			inSingle = true
 		case c == '"':
 			inDouble = true
-		case c == ' ' || c == '\t':
-			flush()
-		case c == '\\' && i+1 < len(line):
-			cur.WriteByte(line[i+1])
+		case c == '\\' && i+1 < len(word):
+			cur.WriteByte(word[i+1])
 			i++
 		default:
 			cur.WriteByte(c)
 		}
 	}
 	flush()
-	return args
+	return fields
+}
+
+// paramName reports the parameter name at the start of s (the text after a
+// '$') and how many bytes it occupies, or 0 if s does not start a parameter.
+func paramName(s string) (string, int) {
+	if s == "" {
+		return "", 0
+	}
+	if s[0] == '{' {
+		end := strings.IndexByte(s, '}')
+		if end < 2 {
+			return "", 0
+		}
+		return s[1:end], end + 1
+	}
+	if strings.IndexByte("?$!-#@*0123456789", s[0]) >= 0 {
+		return s[:1], 1
+	}
+	n := 0
+	for n < len(s) && (s[n] == '_' || isAlpha(s[n]) || (n > 0 && isDigit(s[n]))) {
+		n++
+	}
+	return s[:n], n
+}
+
+// shellQuote quotes s so that the shell reads it back as a single word,
+// leaving it unchanged when no quoting is needed.
+func shellQuote(s string) string {
+	if s == "" {
+		return "''"
+	}
+	if !strings.ContainsAny(s, " \t\n'\"\\$|&;<>(){}*?[]#~`!") {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func isAlpha(c byte) bool { return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' }
+
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
+
+func isBlank(c byte) bool { return c == ' ' || c == '\t' || c == '\n' }
+
+// listItem is one pipeline in a command list together with the operator
+// that follows it: ";", "&", "&&", "||", or "" for the last item.
+type listItem struct {
+	words []string
+	op    string
+}
+
+// splitList divides the words of a line on the unquoted list operators
+// ";", "&", "&&" and "||".
+func splitList(words []string) []listItem {
+	var items []listItem
+	var current []string
+	for _, w := range words {
+		switch w {
+		case ";", "&", "&&", "||":
+			if len(current) > 0 {
+				items = append(items, listItem{words: current, op: w})
+				current = nil
+			}
+		default:
+			current = append(current, w)
+		}
+	}
+	if len(current) > 0 {
+		items = append(items, listItem{words: current})
+	}
+	return items
 }
 
 // splitPipeline divides a parsed argument list on "|" tokens into segments.
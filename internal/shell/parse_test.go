@@ -165,3 +165,122 @@ func TestSplitPipeline(t *testing.T) {
 		})
 	}
 }
+
+func TestSplitWords(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []string
+	}{
+		{"keeps quotes", `echo 'a b' "c d"`, []string{"echo", "'a b'", `"c d"`}},
+		{"keeps escapes", `echo a\ b`, []string{"echo", `a\ b`}},
+		{"escaped quote in double quotes", `echo "a\"b"`, []string{"echo", `"a\"b"`}},
+		{"operators split words", "a;b&&c||d|e&", []string{"a", ";", "b", "&&", "c", "||", "d", "|", "e", "&"}},
+		{"quoted operators stay", `echo ';' "|"`, []string{"echo", "';'", `"|"`}},
+		{"escaped operator stays", `echo a\;b`, []string{"echo", `a\;b`}},
+		{"redirect to fd not split", "cmd 2>&1", []string{"cmd", "2>&1"}},
+		{"newline ends a command", "a\nb", []string{"a", ";", "b"}},
+		{"newline after an operator", "a |\nb &&\nc;\nd", []string{"a", "|", "b", "&&", "c", ";", "d"}},
+		{"blank lines", "\n\na\n\n", []string{"a", ";"}},
+		{"backslash newline joins lines", "ec\\\nho a\\\n b", []string{"echo", "a", "b"}},
+		{"quoted newlines stay", "echo 'a\\\nb' \"c\\\nd\"", []string{"echo", "'a\\\nb'", `"cd"`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := splitWords(tt.input)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitWords(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIncompleteInput(t *testing.T) {
+	tests := []struct {
+		line string
+		want bool
+	}{
+		{"echo hi", false},
+		{"echo 'it", true},
+		{`echo "a`, true},
+		{`echo "it's"`, false},
+		{`echo 'a\'`, false},
+		{`echo "a\"`, true},
+		{"ls \\", true},
+		{`ls \\`, false},
+		{"ls |", true},
+		{"make &&", true},
+		{"make ||", true},
+		{"make &", false},
+		{"make;", false},
+		{"echo '|'", false},
+		{"|", false},
+		{"echo 'a\nb'", false},
+		{"ls |\nwc", false},
+	}
+	for _, tt := range tests {
+		if got := incompleteInput(tt.line); got != tt.want {
+			t.Errorf("incompleteInput(%q) = %v, want %v", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestJoinLines(t *testing.T) {
+	lines, rest := joinLines("ls |\n  wc -l\n# it's a comment\necho 'a\nb'\nfalse \\\n  && true\necho \"open")
+	want := []string{"ls |\n  wc -l", "# it's a comment", "echo 'a\nb'", "false \\\n  && true"}
+	if !reflect.DeepEqual(lines, want) || rest != `echo "open` {
+		t.Errorf("joinLines = %q, %q; want %q and the open quote", lines, rest, want)
+	}
+}
+
+func TestExpandWord(t *testing.T) {
+	vars := map[string]string{"NAME": "world", "SPACED": " a  b ", "EMPTY": "", "HOME": "/home/me"}
+	lookup := func(name string) (string, bool) {
+		v, ok := vars[name]
+		return v, ok
+	}
+	tests := []struct {
+		name string
+		word string
+		want []string
+	}{
+		{"plain", "$NAME", []string{"world"}},
+		{"braces", "${NAME}s", []string{"worlds"}},
+		{"inside double quotes", `"hello $NAME"`, []string{"hello world"}},
+		{"single quotes suppress", `'$NAME'`, []string{"$NAME"}},
+		{"escaped dollar", `\$NAME`, []string{"$NAME"}},
+		{"unset is empty", "x$UNSET", []string{"x"}},
+		{"field splitting", "$SPACED", []string{"a", "b"}},
+		{"splitting joins neighbours", "x${SPACED}y", []string{"x", "a", "b", "y"}},
+		{"quoted keeps blanks", `"$SPACED"`, []string{" a  b "}},
+		{"empty expansion dropped", "$EMPTY", nil},
+		{"lone dollar", "$", []string{"$"}},
+		{"dollar before non-name", "$%", []string{"$%"}},
+		{"tilde", "~", []string{"/home/me"}},
+		{"tilde slash", "~/src", []string{"/home/me/src"}},
+		{"quoted tilde", `"~/src"`, []string{"~/src"}},
+		{"tilde user untouched", "~root", []string{"~root"}},
+		{"tilde not at start", "a~/b", []string{"a~/b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := expandWord(tt.word, lookup)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expandWord(%q) = %q, want %q", tt.word, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitList(t *testing.T) {
+	got := splitList([]string{"a", "&&", "b", "||", "c", ";", "d", "&", ";"})
+	want := []listItem{
+		{words: []string{"a"}, op: "&&"},
+		{words: []string{"b"}, op: "||"},
+		{words: []string{"c"}, op: ";"},
+		{words: []string{"d"}, op: "&"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("splitList = %+v, want %+v", got, want)
+	}
+}
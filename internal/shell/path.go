@@ -4,37 +4,139 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 )
 
+// pathRecheckInterval bounds how often findInPath re-stats the PATH
+// directories to notice newly installed or removed commands.
+const pathRecheckInterval = time.Second
+
+// pathDir is the cached listing of one PATH directory.
+type pathDir struct {
+	mtime  time.Time
+	names  []string
+	loaded bool
+}
+
+// pathCache holds the command hash table and the completion index. Both are
+// dropped when PATH changes and refreshed when a directory's mtime changes.
+type pathCache struct {
+	mu      sync.Mutex
+	path    string
+	dirs    map[string]*pathDir
+	hash    map[string]string
+	checked time.Time
+}
+
+var commandCache = &pathCache{}
+
+// sync resets the cache if PATH has changed and, when force is set or the
+// recheck interval has elapsed, compares directory mtimes against the
+// recorded ones. Callers must hold c.mu.
+func (c *pathCache) sync(force bool) []string {
+	path := os.Getenv("PATH")
+	dirs := filepath.SplitList(path)
+	if c.dirs == nil || path != c.path {
+		c.path = path
+		c.dirs = map[string]*pathDir{}
+		c.hash = map[string]string{}
+		c.checked = time.Time{}
+	}
+	if !force && time.Since(c.checked) < pathRecheckInterval {
+		return dirs
+	}
+	for _, dir := range dirs {
+		var mtime time.Time
+		if info, err := os.Stat(dir); err == nil {
+			mtime = info.ModTime()
+		}
+		d, ok := c.dirs[dir]
+		if !ok {
+			c.dirs[dir] = &pathDir{mtime: mtime}
+			continue
+		}
+		if !d.mtime.Equal(mtime) {
+			*d = pathDir{mtime: mtime}
+			clear(c.hash)
+		}
+	}
+	c.checked = time.Now()
+	return dirs
+}
+
 // findInPath locates an executable by name in the PATH directories.
 func findInPath(cmd string) string {
-	for _, dir := range filepath.SplitList(os.Getenv("PATH")) {
-		full := filepath.Join(dir, cmd)
-		if info, err := os.Stat(full); err == nil && !info.IsDir() && info.Mode()&0111 != 0 {
+	c := commandCache
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	dirs := c.sync(false)
+	if full, ok := c.hash[cmd]; ok {
+		if isExecutable(full) {
 			return full
 		}
+		delete(c.hash, cmd)
 	}
-	return ""
+	full := searchPath(dirs, cmd)
+	if full != "" {
+		c.hash[cmd] = full
+	}
+	return full
 }
 
 // executablesInPath returns all executables in PATH matching the given prefix.
 func executablesInPath(prefix string) []string {
+	c := commandCache
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	seen := map[string]bool{}
 	var results []string
-	for _, dir := range filepath.SplitList(os.Getenv("PATH")) {
-		entries, err := os.ReadDir(dir)
-		if err != nil {
-			continue
+	for _, dir := range c.sync(true) {
+		d := c.dirs[dir]
+		if !d.loaded {
+			d.names = listExecutables(dir)
+			d.loaded = true
 		}
-		for _, e := range entries {
-			if seen[e.Name()] || !strings.HasPrefix(e.Name(), prefix) {
+		for _, name := range d.names {
+			if seen[name] || !strings.HasPrefix(name, prefix) {
 				continue
 			}
-			if info, err := e.Info(); err == nil && !info.IsDir() && info.Mode()&0111 != 0 {
-				results = append(results, e.Name())
-				seen[e.Name()] = true
-			}
+			results = append(results, name)
+			seen[name] = true
 		}
 	}
 	return results
 }
+
+// searchPath is the uncached lookup behind findInPath.
+func searchPath(dirs []string, cmd string) string {
+	for _, dir := range dirs {
+		full := filepath.Join(dir, cmd)
+		if isExecutable(full) {
+			return full
+		}
+	}
+	return ""
+}
+
+// listExecutables returns the names of executable files in dir.
+func listExecutables(dir string) []string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var names []string
+	for _, e := range entries {
+		if info, err := e.Info(); err == nil && !info.IsDir() && info.Mode()&0111 != 0 {
+			names = append(names, e.Name())
+		}
+	}
+	return names
+}
+
+func isExecutable(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir() && info.Mode()&0111 != 0
+}
@@ -1,10 +1,13 @@
 package shell
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
 	"sort"
+	"strings"
 	"testing"
+	"time"
 )
 
 func createExec(t *testing.T, dir, name string) {
@@ -99,3 +102,108 @@ func TestExecutablesInPath(t *testing.T) {
 		}
 	})
 }
+
+func TestFindInPathCache(t *testing.T) {
+	dir1 := t.TempDir()
+	dir2 := t.TempDir()
+	createExec(t, dir2, "cachedcmd")
+	t.Setenv("PATH", dir1+string(os.PathListSeparator)+dir2)
+
+	if got, want := findInPath("cachedcmd"), filepath.Join(dir2, "cachedcmd"); got != want {
+		t.Fatalf("findInPath = %q, want %q", got, want)
+	}
+
+	t.Run("shadowed after directory changes", func(t *testing.T) {
+		createExec(t, dir1, "cachedcmd")
+		commandCache.checked = time.Time{}
+		if got, want := findInPath("cachedcmd"), filepath.Join(dir1, "cachedcmd"); got != want {
+			t.Errorf("findInPath = %q, want %q", got, want)
+		}
+	})
+
+	t.Run("removed executable", func(t *testing.T) {
+		os.Remove(filepath.Join(dir1, "cachedcmd"))
+		if got, want := findInPath("cachedcmd"), filepath.Join(dir2, "cachedcmd"); got != want {
+			t.Errorf("findInPath = %q, want %q", got, want)
+		}
+	})
+
+	t.Run("PATH change", func(t *testing.T) {
+		t.Setenv("PATH", dir1)
+		if got := findInPath("cachedcmd"); got != "" {
+			t.Errorf("findInPath = %q, want empty", got)
+		}
+	})
+}
+
+func TestExecutablesInPathRefresh(t *testing.T) {
+	dir := t.TempDir()
+	createExec(t, dir, "idx-one")
+	t.Setenv("PATH", dir)
+
+	if got := executablesInPath("idx-"); len(got) != 1 {
+		t.Fatalf("got %v, want [idx-one]", got)
+	}
+	createExec(t, dir, "idx-two")
+	got := executablesInPath("idx-")
+	sort.Strings(got)
+	if len(got) != 2 || got[1] != "idx-two" {
+		t.Errorf("got %v, want [idx-one idx-two]", got)
+	}
+}
+
+// benchPath builds a PATH of many directories holding many executables each,
+// with the lookup target in the last directory.
+func benchPath(b *testing.B) (dirs []string, target string) {
+	b.Helper()
+	root := b.TempDir()
+	for i := range 30 {
+		dir := filepath.Join(root, fmt.Sprintf("bin%02d", i))
+		if err := os.Mkdir(dir, 0755); err != nil {
+			b.Fatal(err)
+		}
+		for j := range 200 {
+			path := filepath.Join(dir, fmt.Sprintf("tool%02d-%03d", i, j))
+			if err := os.WriteFile(path, nil, 0755); err != nil {
+				b.Fatal(err)
+			}
+		}
+		dirs = append(dirs, dir)
+	}
+	b.Setenv("PATH", strings.Join(dirs, string(os.PathListSeparator)))
+	return dirs, "tool29-199"
+}
+
+func BenchmarkSearchPathUncached(b *testing.B) {
+	dirs, target := benchPath(b)
+	for b.Loop() {
+		if searchPath(dirs, target) == "" {
+			b.Fatal("not found")
+		}
+	}
+}
+
+func BenchmarkFindInPath(b *testing.B) {
+	_, target := benchPath(b)
+	for b.Loop() {
+		if findInPath(target) == "" {
+			b.Fatal("not found")
+		}
+	}
+}
+
+func BenchmarkListExecutablesUncached(b *testing.B) {
+	dirs, _ := benchPath(b)
+	for b.Loop() {
+		for _, dir := range dirs {
+			listExecutables(dir)
+		}
+	}
+}
+
+func BenchmarkExecutablesInPath(b *testing.B) {
+	benchPath(b)
+	for b.Loop() {
+		executablesInPath("tool1")
+	}
+}
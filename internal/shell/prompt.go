@@ -0,0 +1,257 @@
+package shell
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// promptDefaults are the values of the prompt variables when they are
+// unset. PS1 is shown before each command, PS2 before each continuation
+// line, and PS4 before each command traced by set -x. RPROMPT is shown at
+// the right edge of the line, and GOSH_TRANSIENT_PROMPT replaces PS1 once
+// a line is entered, with shopt transientprompt. In vi mode, the mode
+// indicators GOSH_VI_INSERT_MODE and GOSH_VI_COMMAND_MODE are shown before
+// the last line of PS1.
+var promptDefaults = map[string]string{
+	"PS1":                   "$ ",
+	"PS2":                   "> ",
+	"PS4":                   "+ ",
+	"RPROMPT":               "",
+	"GOSH_TRANSIENT_PROMPT": `\$ `,
+	"GOSH_VI_INSERT_MODE":   "(ins) ",
+	"GOSH_VI_COMMAND_MODE":  "(cmd) ",
+}
+
+// promptDurationMin is the shortest run time \R shows.
+const promptDurationMin = 2 * time.Second
+
+// sgrEscape matches the color and attribute sequences that readline leaves
+// out when it measures the prompt.
+var sgrEscape = regexp.MustCompile("\x1b\\[[0-9;]*m")
+
+// prompt expands the prompt variable name, or its default when it is
+// unset. See expandPrompt.
+func (s *Shell) prompt(name string) (prompt, control string) {
+	ps, ok := os.LookupEnv(name)
+	if !ok {
+		ps = promptDefaults[name]
+	}
+	return s.expandPrompt(ps, time.Now())
+}
+
+// expandPrompt replaces the backslash escapes of bash prompts and the
+// parameters in ps, with now as the current time. Colors between \[ and \]
+// stay in the prompt, since readline skips them when it measures the line.
+// Other non-printing text, such as the sequence that sets the window title,
+// is returned in control, to be written before the prompt.
+func (s *Shell) expandPrompt(ps string, now time.Time) (prompt, control string) {
+	var sb, hidden strings.Builder
+	hiddenAt := -1 // where the \[ segment being read starts in sb
+	for i := 0; i < len(ps); i++ {
+		c := ps[i]
+		if c == '$' {
+			if name, n := paramName(ps[i+1:]); n > 0 {
+				value, _ := s.lookupVar(name)
+				sb.WriteString(value)
+				i += n
+				continue
+			}
+		}
+		if c != '\\' || i+1 >= len(ps) {
+			sb.WriteByte(c)
+			continue
+		}
+		i++
+		switch e := ps[i]; e {
+		case 'a':
+			sb.WriteByte('\a')
+		case 'e':
+			sb.WriteByte('\x1b')
+		case 'n':
+			sb.WriteByte('\n')
+		case 'r':
+			sb.WriteByte('\r')
+		case '\\':
+			sb.WriteByte('\\')
+		case 'd':
+			sb.WriteString(now.Format("Mon Jan 02"))
+		case 'D':
+			end := strings.IndexByte(ps[i:], '}')
+			if i+1 >= len(ps) || ps[i+1] != '{' || end < 0 {
+				sb.WriteString(`\D`)
+				continue
+			}
+			format := ps[i+2 : i+end]
+			if format == "" {
+				format = "%H:%M:%S"
+			}
+			sb.WriteString(strftime(format, now))
+			i += end
+		case 't':
+			sb.WriteString(now.Format("15:04:05"))
+		case 'T':
+			sb.WriteString(now.Format("03:04:05"))
+		case '@':
+			sb.WriteString(now.Format("03:04 PM"))
+		case 'A':
+			sb.WriteString(now.Format("15:04"))
+		case 'h', 'H':
+			host, _ := os.Hostname()
+			if e == 'h' {
+				host, _, _ = strings.Cut(host, ".")
+			}
+			sb.WriteString(host)
+		case 'u':
+			sb.WriteString(userName())
+		case 's':
+			sb.WriteString("gosh")
+		case 'w', 'W':
+			sb.WriteString(promptDir(e == 'W'))
+		case 'g':
+			sb.WriteString(s.gitPrompt())
+		case '?':
+			if s.lastStatus != 0 {
+				sb.WriteString(strconv.Itoa(s.lastStatus))
+			}
+		case 'R':
+			if s.lastDuration >= promptDurationMin {
+				sb.WriteString(promptDuration(s.lastDuration))
+			}
+		case 'j':
+			sb.WriteString(strconv.Itoa(len(s.jobs)))
+		case '!':
+			sb.WriteString(strconv.Itoa(len(s.history) + 1))
+		case '#':
+			sb.WriteString(strconv.Itoa(s.lineNo + 1))
+		case '$':
+			if os.Geteuid() == 0 {
+				sb.WriteByte('#')
+			} else {
+				sb.WriteByte('$')
+			}
+		case '[':
+			hiddenAt = sb.Len()
+		case ']':
+			if hiddenAt < 0 {
+				continue
+			}
+			text := sb.String()
+			if seg := text[hiddenAt:]; sgrEscape.ReplaceAllString(seg, "") != "" {
+				hidden.WriteString(seg)
+				sb.Reset()
+				sb.WriteString(text[:hiddenAt])
+			}
+			hiddenAt = -1
+		case '0', '1', '2', '3', '4', '5', '6', '7':
+			n := 1
+			for n < 3 && i+n < len(ps) && ps[i+n] >= '0' && ps[i+n] <= '7' {
+				n++
+			}
+			code, _ := strconv.ParseUint(ps[i:i+n], 8, 8)
+			sb.WriteByte(byte(code))
+			i += n - 1
+		default:
+			sb.WriteByte('\\')
+			sb.WriteByte(e)
+		}
+	}
+	return sb.String(), hidden.String()
+}
+
+// userName returns the name of the user running the shell.
+func userName() string {
+	if name := os.Getenv("USER"); name != "" {
+		return name
+	}
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return strconv.Itoa(os.Getuid())
+}
+
+// promptDir returns the working directory for \w, with $HOME shown as ~,
+// or only its last element for \W.
+func promptDir(base bool) string {
+	dir, err := os.Getwd()
+	if err != nil {
+		return "?"
+	}
+	home := os.Getenv("HOME")
+	switch {
+	case home != "" && dir == home:
+		return "~"
+	case base:
+		return filepath.Base(dir)
+	case home != "" && home != "/" && strings.HasPrefix(dir, home+"/"):
+		return "~" + dir[len(home):]
+	}
+	return dir
+}
+
+// promptDuration formats the run time of a command for \R: tenths of a
+// second under a minute, then minutes and seconds, then hours and minutes.
+func promptDuration(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return fmt.Sprintf("%.1fs", d.Seconds())
+	case d < time.Hour:
+		return fmt.Sprintf("%dm%02ds", int(d.Minutes()), int(d.Seconds())%60)
+	}
+	return fmt.Sprintf("%dh%02dm", int(d.Hours()), int(d.Minutes())%60)
+}
+
+// readPrompt expands PS1 for the next line. readline measures the prompt
+// as if it were one line, so the lines before the last are written out
+// here, with any non-printing text, and only the last is left to readline.
+func (s *Shell) readPrompt() string {
+	prompt, control := s.prompt("PS1")
+	s.promptAbove = ""
+	if i := strings.LastIndexByte(prompt, '\n'); i >= 0 {
+		s.promptAbove = prompt[:i+1]
+		prompt = prompt[i+1:]
+	}
+	if control+s.promptAbove != "" {
+		fmt.Fprint(os.Stdout, control+s.promptAbove)
+	}
+	return prompt
+}
+
+// rightPrompt returns what the editor paints before the line to show
+// rprompt at the right edge of the first row, after prompt. The right
+// prompt is left out when the line would reach it, as in zsh.
+func rightPrompt(rprompt, prompt string, line []rune, width int) string {
+	if rprompt == "" {
+		return ""
+	}
+	start := textWidth(prompt)
+	end := start + textWidth(strings.TrimSuffix(string(line), "\n"))
+	col := width - 1 - textWidth(rprompt) // stay off the last column
+	if end+1 >= col {
+		return ""
+	}
+	back := "\r"
+	if start > 0 {
+		back += fmt.Sprintf("\033[%dC", start)
+	}
+	return fmt.Sprintf("\033[%dC%s%s", col-start, rprompt, back)
+}
+
+// collapsePrompt redraws the line just entered after GOSH_TRANSIENT_PROMPT
+// instead of its full prompt, for shopt transientprompt, so scrollback
+// keeps only the short form. The cursor is on the row after the line.
+func (s *Shell) collapsePrompt(w io.Writer, line string) {
+	width, _ := s.editor.termSize()
+	rows := 0
+	for _, row := range strings.Split(s.promptAbove+s.editor.prompt+line, "\n") {
+		rows += max(1, (textWidth(row)+width-1)/width)
+	}
+	short, control := s.prompt("GOSH_TRANSIENT_PROMPT")
+	fmt.Fprintf(w, "\033[%dA\r\033[J%s%s%s\n", rows, control, short, string(showNewlines([]rune(line))))
+}
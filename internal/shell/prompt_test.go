@@ -0,0 +1,143 @@
+package shell
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExpandPrompt(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USER", "ann")
+	t.Setenv("GOSH_SEGMENT", "seg")
+	os.MkdirAll(filepath.Join(home, "src", "app"), 0755)
+	t.Chdir(filepath.Join(home, "src", "app"))
+
+	s := &Shell{history: []string{"a", "b"}, lineNo: 4, lastStatus: 3}
+	s.jobs = []*job{{}}
+	now := time.Date(2024, 3, 5, 14, 7, 9, 0, time.UTC)
+	dollar := "$"
+	if os.Geteuid() == 0 {
+		dollar = "#"
+	}
+	tests := []struct{ ps, want string }{
+		{`\u:\w\$ `, "ann:~/src/app" + dollar + " "},
+		{`\W>`, "app>"},
+		{`[\t] \A \T \@`, "[14:07:09] 14:07 02:07:09 02:07 PM"},
+		{`\d \D{%Y-%m-%d}`, "Tue Mar 05 2024-03-05"},
+		{`\j \! \#`, "1 3 5"},
+		{`$? ${GOSH_SEGMENT}-$GOSH_UNSET_X.`, "3 seg-."},
+		{`a\nb\\c\101\e`, "a\nb\\cA\x1b"},
+		{`\s \q \D`, `gosh \q \D`},
+	}
+	for _, tt := range tests {
+		if got, control := s.expandPrompt(tt.ps, now); got != tt.want || control != "" {
+			t.Errorf("expandPrompt(%q) = %q, %q; want %q", tt.ps, got, control, tt.want)
+		}
+	}
+
+	t.Chdir(home)
+	if got, _ := s.expandPrompt(`\w \W`, now); got != "~ ~" {
+		t.Errorf("prompt in $HOME = %q", got)
+	}
+}
+
+func TestExpandPromptNonPrinting(t *testing.T) {
+	s := &Shell{}
+	got, control := s.expandPrompt(`\[\e]0;title\a\]\[\e[1;32m\]ok\[\e[0m\] `, time.Now())
+	if got != "\x1b[1;32mok\x1b[0m " || control != "\x1b]0;title\a" {
+		t.Errorf("expandPrompt = %q, %q", got, control)
+	}
+	if textWidth(got) != 3 {
+		t.Errorf("width of %q = %d, want 3", got, textWidth(got))
+	}
+}
+
+func TestReadPrompt(t *testing.T) {
+	f, err := os.CreateTemp(t.TempDir(), "stdout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	orig := os.Stdout
+	os.Stdout = f
+	defer func() { os.Stdout = orig }()
+
+	s := &Shell{}
+	t.Setenv("PS1", `\[\e]0;t\a\]top\nline $ `)
+	if got := s.readPrompt(); got != "line $ " {
+		t.Errorf("readPrompt = %q, want the last line", got)
+	}
+	os.Unsetenv("PS1")
+	if got := s.readPrompt(); got != "$ " {
+		t.Errorf("readPrompt without PS1 = %q", got)
+	}
+	data, _ := os.ReadFile(f.Name())
+	if want := "\x1b]0;t\atop\n"; string(data) != want {
+		t.Errorf("written before the prompt: %q, want %q", data, want)
+	}
+
+	e, view := newTestEditor()
+	e.setPrompt("new> ", "")
+	if e.prompt != "new> " || view.prompt != "new> " {
+		t.Errorf("setPrompt left %q, %q", e.prompt, view.prompt)
+	}
+}
+
+func TestPromptStatusDuration(t *testing.T) {
+	s := &Shell{}
+	if got, _ := s.expandPrompt(`[\?][\R]`, time.Now()); got != "[][]" {
+		t.Errorf("after a quick success = %q", got)
+	}
+	s.lastStatus, s.lastDuration = 130, 75*time.Second
+	if got, _ := s.expandPrompt(`[\?][\R]`, time.Now()); got != "[130][1m15s]" {
+		t.Errorf("after a slow failure = %q", got)
+	}
+	for d, want := range map[time.Duration]string{
+		2500 * time.Millisecond:         "2.5s",
+		time.Hour + 2*time.Minute + 3e9: "1h02m",
+	} {
+		if got := promptDuration(d); got != want {
+			t.Errorf("promptDuration(%v) = %q, want %q", d, got, want)
+		}
+	}
+}
+
+func TestRightPrompt(t *testing.T) {
+	if got := rightPrompt("\x1b[31m1\x1b[0m 3s", "$ ", []rune("ls"), 20); got != "\x1b[13C\x1b[31m1\x1b[0m 3s\r\x1b[2C" {
+		t.Errorf("rightPrompt = %q", got)
+	}
+	if got := rightPrompt("1 3s", "$ ", []rune("0123456789ab"), 20); got != "" {
+		t.Errorf("rightPrompt over a long line = %q, want nothing", got)
+	}
+	if got := rightPrompt("x", "", []rune("ls\n"), 10); got != "\x1b[8Cx\r" {
+		t.Errorf("rightPrompt for an entered line = %q", got)
+	}
+
+	e, _ := newTestEditor()
+	e.termSize = func() (int, int) { return 20, 10 }
+	e.s.shopts = map[string]bool{"highlight": false}
+	e.setPrompt("$ ", "[1]")
+	if got := string(e.Paint([]rune("ls"), 2)); got != "\x1b[14C[1]\r\x1b[2Cls" {
+		t.Errorf("Paint = %q", got)
+	}
+}
+
+func TestCollapsePrompt(t *testing.T) {
+	e, _ := newTestEditor()
+	e.termSize = func() (int, int) { return 10, 10 }
+	s := e.s
+	s.editor = e
+	s.promptAbove = "~/src/my-project (main)\n"
+	e.setPrompt("$ ", "")
+	t.Setenv("GOSH_TRANSIENT_PROMPT", "> ")
+
+	// Three rows for the first prompt line, two for the prompt and line.
+	var sb strings.Builder
+	s.collapsePrompt(&sb, "echo hello")
+	if got := sb.String(); got != "\x1b[5A\r\x1b[J> echo hello\n" {
+		t.Errorf("collapsePrompt = %q", got)
+	}
+}
@@ -0,0 +1,41 @@
+package shell
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// rcFile returns the path of the file of commands an interactive shell
+// runs at startup, such as set -o vi, bind and hook.
+func rcFile() string {
+	return filepath.Join(configDir(), "goshrc")
+}
+
+// loadRC runs the commands in the rc file, one per line unless a command is
+// continued onto the next. Blank lines and lines starting with # are
+// skipped, and a missing file is not an error.
+func (s *Shell) loadRC() {
+	data, err := os.ReadFile(rcFile())
+	if err != nil {
+		if !os.IsNotExist(err) {
+			fmt.Fprintf(os.Stderr, "gosh: %v\n", err)
+		}
+		return
+	}
+	lines, rest := joinLines(string(data))
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		s.execLine(line)
+		if s.exiting {
+			return
+		}
+	}
+	if strings.TrimSpace(rest) != "" {
+		fmt.Fprintf(os.Stderr, "gosh: %s: unexpected end of file\n", rcFile())
+	}
+}
@@ -0,0 +1,37 @@
+package shell
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadRC(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	s := &Shell{}
+	s.loadRC() // a missing file is fine
+
+	os.MkdirAll(filepath.Join(dir, "gosh"), 0755)
+	rc := "# editing\nset -o vi\n\n  bind '\\C-g' history-search\nexport GOSH_RC_TEST=loaded\n"
+	if err := os.WriteFile(rcFile(), []byte(rc), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GOSH_RC_TEST", "")
+	s.loadRC()
+	if !s.option("vi") || s.bindings[keySeq{7}].action != "history-search" || os.Getenv("GOSH_RC_TEST") != "loaded" {
+		t.Errorf("after loadRC: vi %v, bindings %v, GOSH_RC_TEST %q", s.option("vi"), s.bindings, os.Getenv("GOSH_RC_TEST"))
+	}
+
+	os.WriteFile(rcFile(), []byte("# it's continued\nexport \\\n  GOSH_RC_TEST='two\nlines'\n"), 0644)
+	s.loadRC()
+	if got := os.Getenv("GOSH_RC_TEST"); got != "two\nlines" {
+		t.Errorf("a continued command set GOSH_RC_TEST to %q", got)
+	}
+
+	os.WriteFile(rcFile(), []byte("exit 3\nexport GOSH_RC_TEST=after\n"), 0644)
+	s.loadRC()
+	if !s.exiting || os.Getenv("GOSH_RC_TEST") != "two\nlines" {
+		t.Errorf("exit in the rc file: exiting %v, GOSH_RC_TEST %q", s.exiting, os.Getenv("GOSH_RC_TEST"))
+	}
+}
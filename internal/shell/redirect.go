@@ -1,16 +1,21 @@
 package shell
 
 import (
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 )
 
 type redirect struct {
 	outFile   string
 	outAppend bool
+	outForce  bool
 	errFile   string
 	errAppend bool
+	errForce  bool
+	errToOut  bool // 2>&1: stderr goes wherever stdout goes
 }
 
 // extractRedirect separates redirection tokens from command arguments.
@@ -25,12 +30,20 @@ func extractRedirect(parts []string) (args []string, r redirect) {
 		case (tok == ">" || tok == "1>") && hasNext:
 			r.outFile, r.outAppend = parts[i+1], false
 			i++
+		case (tok == ">|" || tok == "1>|") && hasNext:
+			r.outFile, r.outAppend, r.outForce = parts[i+1], false, true
+			i++
 		case tok == "2>>" && hasNext:
-			r.errFile, r.errAppend = parts[i+1], true
+			r.errFile, r.errAppend, r.errToOut = parts[i+1], true, false
 			i++
 		case tok == "2>" && hasNext:
-			r.errFile, r.errAppend = parts[i+1], false
+			r.errFile, r.errAppend, r.errToOut = parts[i+1], false, false
+			i++
+		case tok == "2>|" && hasNext:
+			r.errFile, r.errAppend, r.errForce, r.errToOut = parts[i+1], false, true, false
 			i++
+		case tok == "2>&1":
+			r.errFile, r.errToOut = "", true
 		default:
 			args = append(args, tok)
 		}
@@ -38,32 +51,59 @@ func extractRedirect(parts []string) (args []string, r redirect) {
 	return
 }
 
-func openOutput(path string, appendMode bool) (*os.File, error) {
+// isRedirectOp reports whether tok is a redirection operator, which takes
+// the next word as its target.
+func isRedirectOp(tok string) bool {
+	switch tok {
+	case ">", ">>", ">|", "1>", "1>>", "1>|", "2>", "2>>", "2>|":
+		return true
+	}
+	return false
+}
+
+// openOutput opens a redirection target. With noclobber set, an existing
+// regular file is never truncated; devices such as /dev/null are still
+// allowed.
+func openOutput(path string, appendMode, noclobber bool) (*os.File, error) {
 	if appendMode {
 		return os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	}
+	if noclobber {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if !errors.Is(err, fs.ErrExist) {
+			return f, err
+		}
+		if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() {
+			return nil, errors.New("cannot overwrite existing file")
+		}
+		return os.OpenFile(path, os.O_WRONLY, 0)
+	}
 	return os.Create(path)
 }
 
-// resolveStreams returns stdout and stderr writers based on redirection config.
-func resolveStreams(r redirect) (stdout, stderr io.Writer) {
-	stdout = os.Stdout
-	stderr = os.Stderr
+// resolveStreams returns stdout and stderr writers based on redirection
+// config, starting from the given ones. noclobber is the state of set -C;
+// ">|" overrides it. If a target cannot be opened the command must not run.
+func resolveStreams(r redirect, noclobber bool, stdout, stderr io.Writer) (io.Writer, io.Writer, error) {
 	if r.outFile != "" {
-		f, err := openOutput(r.outFile, r.outAppend)
+		f, err := openOutput(r.outFile, r.outAppend, noclobber && !r.outForce)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "cannot open %s: %v\n", r.outFile, err)
-		} else {
-			stdout = f
+			return nil, nil, fmt.Errorf("cannot open %s: %w", r.outFile, err)
 		}
+		stdout = f
 	}
 	if r.errFile != "" {
-		f, err := openOutput(r.errFile, r.errAppend)
+		f, err := openOutput(r.errFile, r.errAppend, noclobber && !r.errForce)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "cannot open %s: %v\n", r.errFile, err)
-		} else {
-			stderr = f
+			if r.outFile != "" {
+				stdout.(*os.File).Close()
+			}
+			return nil, nil, fmt.Errorf("cannot open %s: %w", r.errFile, err)
 		}
+		stderr = f
 	}
-	return
+	if r.errToOut {
+		stderr = stdout
+	}
+	return stdout, stderr, nil
 }
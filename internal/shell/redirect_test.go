@@ -62,6 +62,12 @@ func TestExtractRedirect(t *testing.T) {
 			wantArgs: []string{"cmd"},
 			wantR:    redirect{outFile: "out.txt", errFile: "err.log"},
 		},
+		{
+			name:     "stderr to stdout",
+			parts:    []string{"cmd", ">", "out.txt", "2>&1"},
+			wantArgs: []string{"cmd"},
+			wantR:    redirect{outFile: "out.txt", errToOut: true},
+		},
 		{
 			name:     "redirect without target is kept as arg",
 			parts:    []string{"echo", ">"},
@@ -88,14 +94,14 @@ func TestOpenOutput(t *testing.T) {
 
 	t.Run("create truncate", func(t *testing.T) {
 		path := filepath.Join(dir, "truncate.txt")
-		f, err := openOutput(path, false)
+		f, err := openOutput(path, false, false)
 		if err != nil {
 			t.Fatal(err)
 		}
 		f.WriteString("hello\n")
 		f.Close()
 
-		f, err = openOutput(path, false)
+		f, err = openOutput(path, false, false)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -110,14 +116,14 @@ func TestOpenOutput(t *testing.T) {
 
 	t.Run("create append", func(t *testing.T) {
 		path := filepath.Join(dir, "append.txt")
-		f, err := openOutput(path, true)
+		f, err := openOutput(path, true, false)
 		if err != nil {
 			t.Fatal(err)
 		}
 		f.WriteString("hello\n")
 		f.Close()
 
-		f, err = openOutput(path, true)
+		f, err = openOutput(path, true, false)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -130,3 +136,44 @@ func TestOpenOutput(t *testing.T) {
 		}
 	})
 }
+
+func TestNoclobber(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "existing.txt")
+	os.WriteFile(path, []byte("keep\n"), 0644)
+
+	if _, err := openOutput(path, false, true); err == nil {
+		t.Error("noclobber truncated an existing file")
+	}
+	if data, _ := os.ReadFile(path); string(data) != "keep\n" {
+		t.Errorf("file changed to %q", data)
+	}
+
+	f, err := openOutput(filepath.Join(dir, "new.txt"), false, true)
+	if err != nil {
+		t.Fatalf("noclobber refused a new file: %v", err)
+	}
+	f.Close()
+
+	f, err = openOutput(os.DevNull, false, true)
+	if err != nil {
+		t.Fatalf("noclobber refused %s: %v", os.DevNull, err)
+	}
+	f.Close()
+
+	f, err = openOutput(path, true, true)
+	if err != nil {
+		t.Fatalf("noclobber refused append: %v", err)
+	}
+	f.Close()
+
+	args, r := extractRedirect([]string{"echo", "x", ">|", path})
+	if len(args) != 2 || !r.outForce {
+		t.Fatalf("extractRedirect >| = %v, %+v", args, r)
+	}
+	stdout, _, err := resolveStreams(r, true, os.Stdout, os.Stderr)
+	if err != nil {
+		t.Fatalf(">| did not override noclobber: %v", err)
+	}
+	stdout.(*os.File).Close()
+}
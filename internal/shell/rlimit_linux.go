@@ -0,0 +1,7 @@
+package shell
+
+// RLIMIT_NPROC and RLIM_INFINITY are missing from package syscall.
+const (
+	rlimitNproc    = 0x6
+	rlimitInfinity = ^uint64(0)
+)
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package shell
+
+// RLIMIT_NPROC and RLIM_INFINITY are missing from package syscall. These
+// values are shared by macOS and the BSDs.
+const (
+	rlimitNproc    = 0x7
+	rlimitInfinity = 1<<63 - 1
+)
@@ -2,8 +2,13 @@ package shell
 
 import (
 	"fmt"
+	"io"
+	"maps"
 	"os"
+	"regexp"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/chzyer/readline"
 )
@@ -11,8 +16,55 @@ import (
 // Shell is the main interactive shell instance.
 type Shell struct {
 	rl            *readline.Instance
+	editor        *editor
 	history       []string
+	historyMeta   []*historyMeta // parallel to history; may be shorter
 	historyOffset int
+	session       string
+	running       *historyMeta // the entry of the line being run, if recorded
+
+	// histFile and histFileOffset identify HISTFILE and how much of it
+	// this shell has read, for picking up other sessions' entries.
+	histFile       os.FileInfo
+	histFileOffset int64
+
+	// redact caches the redaction rules compiled from redactSpec, the
+	// value of HISTREDACT.
+	redact     []*regexp.Regexp
+	redactSpec string
+
+	bindings map[keySeq]keyBinding // made with bind
+
+	completions map[string]*completionSpec // registered with complete
+	specFiles   map[string]*specFile       // completion spec files read so far
+	helpCache   map[string]*helpCache      // flags from --help, by binary path
+
+	gitDirty map[string]*gitDirty // dirty flags for \g, by git directory
+
+	lastStatus   int
+	lastDuration time.Duration // run time of the last line, for \R
+	promptAbove  string        // the lines of PS1 above the edit line
+	exiting      bool
+	exitCode     int
+
+	jobs      []*job
+	lastBgPid int
+
+	traps  map[string]string
+	hooks  hooks
+	sigCh  chan os.Signal
+	inTrap bool
+
+	inSubshell bool     // a copy made by subshell
+	params     []string // $1, $2, ... while a completion command runs
+
+	options map[string]bool
+	shopts  map[string]bool
+
+	lineNo     int
+	jobsWarned int
+
+	timing *usageLog
 }
 
 // New creates and initializes a new Shell instance.
@@ -20,15 +72,23 @@ func New() (*Shell, error) {
 	s := &Shell{}
 	s.loadHistory()
 
+	ed := newEditor(s, "")
 	rl, err := readline.NewEx(&readline.Config{
-		Prompt:          "$ ",
-		AutoComplete:    newCompleter(),
+		AutoComplete:    newCompleter(s),
+		Stdin:           keyReader{readline.NewCancelableStdin(readline.Stdin), func() bool { return s.option("vi") }},
 		InterruptPrompt: "^C",
+		// Lines are added by addHistory after history expansion.
+		DisableAutoSaveHistory: true,
+		FuncFilterInputRune:    ed.filter,
+		Listener:               ed,
+		Painter:                ed,
 	})
 	if err != nil {
 		return nil, err
 	}
 	s.rl = rl
+	ed.view = rl.Operation
+	s.editor = ed
 
 	return s, nil
 }
@@ -37,85 +97,187 @@ func New() (*Shell, error) {
 func (s *Shell) Run() int {
 	defer s.rl.Close()
 
+	s.loadRC()
+	if s.exiting {
+		return s.exit(s.exitCode)
+	}
+
 	for {
+		s.notifyJobs(os.Stderr)
+		s.runPendingTraps()
+		s.precmd()
+		if s.shopt("sharehistory") {
+			if err := s.syncHistoryFile(true, false); err != nil {
+				fmt.Fprintf(os.Stderr, "history: %v\n", err)
+			}
+		}
+
+		rprompt, _ := s.prompt("RPROMPT")
+		s.editor.setPrompt(s.readPrompt(), rprompt)
 		line, err := s.rl.Readline()
 		if err != nil {
-			s.saveHistory()
-			return 0
+			return s.exit(0)
 		}
-
-		line = strings.TrimSpace(line)
-		if line == "" {
-			continue
+		if s.shopt("transientprompt") {
+			s.collapsePrompt(os.Stdout, line)
 		}
+		line = enteredLine(line)
 
-		s.history = append(s.history, line)
-
-		parts := parseArgs(line)
-		if len(parts) == 0 {
+		if s.editor.takeEditRequest() {
+			s.editCommandLine(line)
+			if s.exiting {
+				return s.exit(s.exitCode)
+			}
 			continue
 		}
-
-		if parts[0] == "exit" {
-			s.saveHistory()
-			return 0
+		line, ok := s.readContinuation(line)
+		if !ok {
+			continue
 		}
 
-		segments := splitPipeline(parts)
-
-		if len(segments) > 1 {
-			lastSeg, redir := extractRedirect(segments[len(segments)-1])
-			segments[len(segments)-1] = lastSeg
-			stdout, stderr := resolveStreams(redir)
-			s.runPipeline(segments, stdout, stderr)
+		spaced := line != "" && isBlank(line[0])
+		line = strings.TrimSpace(line)
+		if line == "" {
 			continue
 		}
 
-		parts, redir := extractRedirect(segments[0])
-		if len(parts) == 0 {
+		line, ok = s.expandHistoryLine(line, os.Stdout, os.Stderr)
+		if !ok {
 			continue
 		}
-		stdout, stderr := resolveStreams(redir)
-
-		if parts[0] == "exit" {
-			s.saveHistory()
-			return 0
+		s.running = s.recordHistory(line, spaced)
+		s.lineNo++
+		s.runLine(line)
+		s.running.finish(s.lastStatus)
+		s.running = nil
+		if s.exiting {
+			return s.exit(s.exitCode)
 		}
+	}
+}
 
-		s.dispatch(parts, os.Stdin, stdout, stderr)
+// readContinuation reads lines after PS2 while line is incomplete input,
+// and returns the whole command, its lines joined by newlines. It reports
+// false if Ctrl-C or Ctrl-D ended the command first.
+func (s *Shell) readContinuation(line string) (string, bool) {
+	for incompleteInput(line) {
+		prompt, _ := s.prompt("PS2")
+		s.editor.setPrompt(prompt, "")
+		next, err := s.rl.Readline()
+		if err == io.EOF {
+			fmt.Fprintln(os.Stderr, "gosh: unexpected end of input")
+		}
+		if err != nil {
+			return "", false
+		}
+		line += "\n" + enteredLine(next)
 	}
+	return line, true
 }
 
-func (s *Shell) loadHistory() {
-	histFile := os.Getenv("HISTFILE")
-	if histFile == "" {
-		return
+// runLine runs a line entered at the prompt, or by fc, after the preexec
+// hooks, and times it for \R and the precmd hooks.
+func (s *Shell) runLine(line string) int {
+	s.preexec(line)
+	start := time.Now()
+	status := s.execLine(line)
+	s.lastDuration = time.Since(start)
+	return status
+}
+
+// expandHistoryLine applies history expansion to an input line. When the
+// line changes it is echoed to stdout, as in bash. It reports false if the
+// line should not be run: expansion failed, or a :p modifier asked only to
+// print it, in which case the expanded line is still added to the history.
+func (s *Shell) expandHistoryLine(line string, stdout, stderr io.Writer) (string, bool) {
+	if !strings.ContainsAny(line, "!^") {
+		return line, true
 	}
-	data, err := os.ReadFile(histFile)
+	expanded, printOnly, err := expandHistory(line, s.history)
 	if err != nil {
-		return
+		fmt.Fprintf(stderr, "gosh: %v\n", err)
+		return "", false
 	}
-	for _, line := range strings.Split(strings.TrimRight(string(data), "\n"), "\n") {
-		line = strings.TrimSpace(line)
-		if line != "" {
-			s.history = append(s.history, line)
+	if expanded != line {
+		fmt.Fprintln(stdout, expanded)
+	}
+	if printOnly {
+		s.addHistory(expanded)
+		return "", false
+	}
+	return expanded, true
+}
+
+// exit runs the EXIT trap and saves history before the shell terminates.
+func (s *Shell) exit(code int) int {
+	s.exiting = false
+	s.fireTrap("EXIT")
+	if s.exiting {
+		code = s.exitCode
+	}
+	if s.shopt("huponexit") {
+		s.hangupJobs()
+	}
+	s.saveHistory()
+	return code
+}
+
+// subshell returns a copy of the shell for running a builtin stage of a
+// pipeline or background job, or a completion command, so that it cannot
+// change the options, traps, hooks or completions of the shell itself. As
+// in other shells, caught traps are reset in the copy; ignored signals stay
+// ignored. The working directory and environment belong to the process and
+// cannot be copied, so builtins that would change them refuse to run in a
+// subshell.
+func (s *Shell) subshell() *Shell {
+	sub := &Shell{
+		history:       slices.Clip(s.history),
+		historyMeta:   slices.Clip(s.historyMeta),
+		historyOffset: s.historyOffset,
+		session:       s.session,
+		bindings:      maps.Clone(s.bindings),
+		completions:   maps.Clone(s.completions),
+		specFiles:     maps.Clone(s.specFiles),
+		helpCache:     maps.Clone(s.helpCache),
+		lastStatus:    s.lastStatus,
+		jobs:          slices.Clone(s.jobs),
+		lastBgPid:     s.lastBgPid,
+		hooks:         hooks{commands: maps.Clone(s.hooks.commands)},
+		options:       maps.Clone(s.options),
+		shopts:        maps.Clone(s.shopts),
+		lineNo:        s.lineNo,
+		inSubshell:    true,
+		params:        s.params,
+	}
+	for name, action := range s.traps {
+		if action == "" {
+			if sub.traps == nil {
+				sub.traps = make(map[string]string)
+			}
+			sub.traps[name] = action
 		}
 	}
-	s.historyOffset = len(s.history)
+	return sub
 }
 
-func (s *Shell) saveHistory() {
+// loadHistory reads HISTFILE under a shared lock.
+func (s *Shell) loadHistory() {
 	histFile := os.Getenv("HISTFILE")
 	if histFile == "" {
 		return
 	}
-	f, err := os.Create(histFile)
+	data, info, err := readLockedHistory(histFile)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "history: %s: %v\n", histFile, err)
 		return
 	}
-	defer f.Close()
-	for _, cmd := range s.history {
-		fmt.Fprintln(f, cmd)
+	s.readHistory(data)
+	s.histFile, s.histFileOffset = info, int64(len(data))
+}
+
+// saveHistory appends the entries entered in this session to HISTFILE and
+// trims it to HISTFILESIZE. Entries saved by other sessions are kept.
+func (s *Shell) saveHistory() {
+	if err := s.syncHistoryFile(false, true); err != nil {
+		fmt.Fprintf(os.Stderr, "history: %s: %v\n", os.Getenv("HISTFILE"), err)
 	}
 }
@@ -0,0 +1,31 @@
+package shell
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStrftime(t *testing.T) {
+	ts := time.Date(2024, time.March, 5, 14, 7, 9, 0, time.UTC)
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{"%F %T", "2024-03-05 14:07:09"},
+		{"%Y/%m/%d", "2024/03/05"},
+		{"%H:%M:%S", "14:07:09"},
+		{"%I %p", "02 PM"},
+		{"%a %b %e", "Tue Mar  5"},
+		{"%j %u %w", "065 2 2"},
+		{"%y%%", "24%"},
+		{"%s", "1709647629"},
+		{"%Z %z", "UTC +0000"},
+		{"%Q", "%Q"},
+		{"trailing %", "trailing %"},
+	}
+	for _, tt := range tests {
+		if got := strftime(tt.format, ts); got != tt.want {
+			t.Errorf("strftime(%q) = %q, want %q", tt.format, got, tt.want)
+		}
+	}
+}
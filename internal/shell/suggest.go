@@ -0,0 +1,184 @@
+package shell
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/chzyer/readline"
+)
+
+// styleSuggestion is the SGR attribute of the suggested rest of the line.
+const styleSuggestion = "90"
+
+// autosuggest holds the suggestion for the line being typed. Paint asks for
+// it on readline's input goroutine, and completion suggestions are worked
+// out on goroutines of their own, so that typing never waits for them.
+type autosuggest struct {
+	mu    sync.Mutex
+	input string // the line the suggestion is for
+	text  string // the suggested rest of the line, or ""
+}
+
+// suggest returns the suggested rest of input: the rest of the most recent
+// history line that starts with it, from those run in the working
+// directory first. Failing that, completion candidates are looked up in
+// the background; refresh is called if they yield a suggestion while input
+// is still the line.
+func (s *Shell) suggest(a *autosuggest, input string, refresh func()) string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if input == a.input {
+		return a.text
+	}
+	a.input, a.text = input, ""
+	if strings.TrimSpace(input) == "" || strings.ContainsAny(input, "\n"+string(charNewline)) {
+		return ""
+	}
+	cwd, _ := os.Getwd()
+	if a.text = s.historySuggestion(input, cwd); a.text != "" {
+		return a.text
+	}
+	go func() {
+		text := completionSuggestion(input)
+		if text == "" {
+			return
+		}
+		a.mu.Lock()
+		current := a.input == input
+		if current {
+			a.text = text
+		}
+		a.mu.Unlock()
+		if current && refresh != nil {
+			refresh()
+		}
+	}()
+	return ""
+}
+
+// historySuggestion returns the rest of the most recent history line that
+// starts with input and was run in cwd or, if there is none, of the most
+// recent one run anywhere. Lines of several rows are never suggested.
+func (s *Shell) historySuggestion(input, cwd string) string {
+	anywhere := ""
+	for i := len(s.history) - 1; i >= 0; i-- {
+		line := s.history[i]
+		if len(line) <= len(input) || !strings.HasPrefix(line, input) || strings.ContainsRune(line, '\n') {
+			continue
+		}
+		if m := s.metaAt(i); m != nil && m.cwd == cwd {
+			return line[len(input):]
+		}
+		if anywhere == "" {
+			anywhere = line[len(input):]
+		}
+	}
+	return anywhere
+}
+
+// completionSuggestion returns what completing the last word of input would
+// add to it: the rest of the only candidate, or of the prefix all
+// candidates share. Only commands, files and variables are considered;
+// completions registered for a command may run programs, which a
+// suggestion must not do behind the user's back.
+func completionSuggestion(input string) string {
+	if strings.HasSuffix(input, " ") {
+		return ""
+	}
+	ctx := parseCompletion(input)
+	if ctx.value == "" {
+		return ""
+	}
+	var matches []completion
+	switch {
+	case ctx.kind == completeVariable:
+		matches = completeVariables(ctx)
+	case ctx.kind == completeCommand && !strings.Contains(ctx.value, "/"):
+		matches = completeCommands(ctx)
+	case ctx.kind == completeCommand:
+		matches = completeFiles(ctx, func(info fs.FileInfo) bool {
+			return info.IsDir() || info.Mode()&0111 != 0
+		})
+	case ctx.kind == completeDirectory:
+		matches = completeFiles(ctx, fs.FileInfo.IsDir)
+	case ctx.kind == completeFile:
+		matches = completeFiles(ctx, nil)
+	}
+	if len(matches) == 0 {
+		return ""
+	}
+	words := make([]string, len(matches))
+	for i, m := range matches {
+		words[i] = m.word
+	}
+	text, ok := strings.CutPrefix(longestCommonPrefix(words), ctx.raw)
+	if !ok {
+		return ""
+	}
+	for !utf8.ValidString(text) {
+		text = text[:len(text)-1]
+	}
+	return text
+}
+
+// suggestionWord returns the first word of the suggested text, with the
+// blanks before it, for Alt-F.
+func suggestionWord(text string) string {
+	start := len(text) - len(strings.TrimLeftFunc(text, unicode.IsSpace))
+	if end := strings.IndexFunc(text[start:], unicode.IsSpace); end >= 0 {
+		return text[:start+end]
+	}
+	return text
+}
+
+// acceptSuggestion handles the keys that take the suggestion shown after
+// the cursor: Right and End take all of it, and Alt-F its first word. It
+// reports whether it used the key.
+func (e *editor) acceptSuggestion(r rune) bool {
+	if r != readline.CharForward && r != readline.CharLineEnd && r != readline.MetaForward {
+		return false
+	}
+	if e.pos != len(e.line) || !e.s.shopt("autosuggest") {
+		return false
+	}
+	text := e.s.suggest(&e.suggestion, string(e.line), nil)
+	if text == "" {
+		return false
+	}
+	if r == readline.MetaForward {
+		text = suggestionWord(text)
+	}
+	line := string(e.line) + text
+	e.line, e.pos = []rune(line), len([]rune(line))
+	if e.view != nil {
+		e.view.SetBuffer(line)
+	}
+	return true
+}
+
+// ghostText returns the escape sequences that show text greyed out after
+// the cursor, which ends the line, and move the cursor back to its place.
+// start is the width of the prompt and line before the cursor. Nothing is
+// shown when the line fills its last row, since readline then writes over
+// the first column of the next.
+func ghostText(text string, start, width int) string {
+	if text == "" || width <= 0 || start > 0 && start%width == 0 {
+		return ""
+	}
+	end := start + textWidth(text)
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "\033[%sm%s\033[0m", styleSuggestion, text)
+	if up := (end-1)/width - start/width; up > 0 {
+		fmt.Fprintf(&sb, "\033[%dA", up)
+	}
+	sb.WriteString("\r")
+	if col := start % width; col > 0 {
+		fmt.Fprintf(&sb, "\033[%dC", col)
+	}
+	return sb.String()
+}
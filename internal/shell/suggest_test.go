@@ -0,0 +1,140 @@
+package shell
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/chzyer/readline"
+)
+
+func TestHistorySuggestion(t *testing.T) {
+	s := &Shell{
+		history: []string{"make test", "make build", "make lint", "ls", "echo 'a\nb'"},
+		historyMeta: []*historyMeta{
+			{cwd: "/src"}, nil, {cwd: "/tmp"}, nil, nil,
+		},
+	}
+	tests := []struct{ input, cwd, want string }{
+		{"make ", "/src", "test"},
+		{"make ", "/home", "lint"},
+		{"make b", "/src", "uild"},
+		{"ls", "/src", ""},
+		{"echo", "/src", ""},
+		{"cat", "/src", ""},
+	}
+	for _, tt := range tests {
+		if got := s.historySuggestion(tt.input, tt.cwd); got != tt.want {
+			t.Errorf("historySuggestion(%q, %q) = %q, want %q", tt.input, tt.cwd, got, tt.want)
+		}
+	}
+}
+
+func TestCompletionSuggestion(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "report-2024.txt"), nil, 0644)
+	os.WriteFile(filepath.Join(dir, "report-2025.txt"), nil, 0644)
+	os.Mkdir(filepath.Join(dir, "scripts"), 0755)
+	t.Chdir(dir)
+	t.Setenv("GOSH_SUGGEST_VAR", "1")
+
+	tests := []struct{ input, want string }{
+		{"cat rep", "ort-202"},
+		{"cd sc", "ripts/"},
+		{"echo $GOSH_SUGGEST_V", "AR"},
+		{"cat report-2024.txt", ""},
+		{"cat ", ""},
+		{"cat missing", ""},
+	}
+	for _, tt := range tests {
+		if got := completionSuggestion(tt.input); got != tt.want {
+			t.Errorf("completionSuggestion(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestSuggestionWord(t *testing.T) {
+	for text, want := range map[string]string{
+		"ommit -m fix": "ommit",
+		" -m fix":      " -m",
+		"fix":          "fix",
+	} {
+		if got := suggestionWord(text); got != want {
+			t.Errorf("suggestionWord(%q) = %q, want %q", text, got, want)
+		}
+	}
+}
+
+func TestGhostText(t *testing.T) {
+	if got := ghostText("test", 7, 80); got != "\x1b[90mtest\x1b[0m\r\x1b[7C" {
+		t.Errorf("ghostText = %q", got)
+	}
+	// The suggestion wraps onto the next two rows.
+	if got := ghostText("0123456789abc", 8, 10); got != "\x1b[90m0123456789abc\x1b[0m\x1b[2A\r\x1b[8C" {
+		t.Errorf("ghostText over rows = %q", got)
+	}
+	// The suggestion ends on the last column, where the cursor waits.
+	if got := ghostText("ab", 8, 10); got != "\x1b[90mab\x1b[0m\r\x1b[8C" {
+		t.Errorf("ghostText to the edge = %q", got)
+	}
+	if got := ghostText("ab", 10, 10); got != "" {
+		t.Errorf("ghostText after a full row = %q, want nothing", got)
+	}
+}
+
+func TestEditorSuggestion(t *testing.T) {
+	e, view := newTestEditor("git commit -m fix", "git status")
+	e.s.shopts = map[string]bool{"highlight": false}
+	e.termSize = func() (int, int) { return 80, 24 }
+
+	e.OnChange([]rune("git c"), 5, 'c')
+	if got := string(e.Paint([]rune("git c"), 5)); got != "git c\x1b[90mommit -m fix\x1b[0m\r\x1b[7C" {
+		t.Errorf("Paint = %q", got)
+	}
+	if got := string(e.Paint([]rune("git c"), 3)); got != "git c" {
+		t.Errorf("Paint with the cursor inside the line = %q", got)
+	}
+
+	if _, ok := e.filter(readline.MetaForward); ok || view.buffer != "git commit" {
+		t.Fatalf("after Alt-F: passed %v, buffer %q", ok, view.buffer)
+	}
+	if _, ok := e.filter(readline.CharLineEnd); ok || view.buffer != "git commit -m fix" {
+		t.Fatalf("after End: passed %v, buffer %q", ok, view.buffer)
+	}
+	if _, ok := e.filter(readline.CharForward); !ok {
+		t.Error("Right without a suggestion was not passed to readline")
+	}
+
+	e.OnChange([]rune("git c"), 3, readline.CharBackward)
+	if _, ok := e.filter(readline.CharForward); !ok {
+		t.Error("Right inside the line was not passed to readline")
+	}
+
+	e.s.shopts["autosuggest"] = false
+	e.OnChange([]rune("git s"), 5, 's')
+	if got := string(e.Paint([]rune("git s"), 5)); got != "git s" {
+		t.Errorf("Paint with autosuggest off = %q", got)
+	}
+}
+
+func TestSuggestFromCompletion(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "notes.md"), nil, 0644)
+	t.Chdir(dir)
+
+	s := &Shell{}
+	var a autosuggest
+	refreshed := make(chan struct{}, 1)
+	if got := s.suggest(&a, "cat no", func() { refreshed <- struct{}{} }); got != "" {
+		t.Errorf("suggest returned %q before completion ran", got)
+	}
+	select {
+	case <-refreshed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the line was not refreshed with the completion")
+	}
+	if got := s.suggest(&a, "cat no", nil); got != "tes.md" {
+		t.Errorf("suggest after completion = %q, want tes.md", got)
+	}
+}
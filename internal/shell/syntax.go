@@ -0,0 +1,215 @@
+package shell
+
+import (
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+// The SGR attributes of each kind of text in a highlighted line.
+const (
+	styleCommand  = "32" // a builtin, keyword or command found in PATH
+	styleMissing  = "31" // a command that cannot be found
+	styleOperator = "35" // ;, &, &&, | and ||
+	styleRedirect = "34" // redirection operators
+	styleString   = "33" // quoted text
+	styleVariable = "36" // $NAME, ${NAME} and the name of NAME=value
+	stylePath     = "4"  // a word naming an existing file, added to the others
+)
+
+// syntaxToken is a word or operator of a line being typed, as runes
+// [start, end), with the spans of its quoted text and parameters.
+type syntaxToken struct {
+	start, end int
+	operator   bool
+	quoted     [][2]int
+	params     [][2]int
+}
+
+// lexSyntax splits line into tokens the way splitWords does, but keeps
+// their positions and never fails: an unterminated quote or ${ runs to the
+// end of the line.
+func lexSyntax(line []rune) []syntaxToken {
+	var tokens []syntaxToken
+	i := 0
+	for i < len(line) {
+		c := line[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+			continue
+		case c == ';' || c == '|' || c == '&':
+			end := i + 1
+			if c != ';' && end < len(line) && line[end] == c {
+				end++
+			}
+			tokens = append(tokens, syntaxToken{start: i, end: end, operator: true})
+			i = end
+			continue
+		}
+
+		t := syntaxToken{start: i}
+		quote := rune(0)
+		quoteAt := 0
+		for ; i < len(line); i++ {
+			c := line[i]
+			if quote == 0 && (c == ' ' || c == '\t' || c == '\n' || c == ';') {
+				break
+			}
+			if quote == 0 && (c == '|' || c == '&') && (i == t.start || line[i-1] != '>') {
+				break
+			}
+			switch {
+			case quote == '\'':
+				if c == '\'' {
+					t.quoted = append(t.quoted, [2]int{quoteAt, i + 1})
+					quote = 0
+				}
+			case c == '\\':
+				i++
+			case c == '$' && i+1 < len(line):
+				if n := paramLength(line[i+1:]); n > 0 {
+					t.params = append(t.params, [2]int{i, i + 1 + n})
+					i += n
+				}
+			case quote == '"':
+				if c == '"' {
+					t.quoted = append(t.quoted, [2]int{quoteAt, i + 1})
+					quote = 0
+				}
+			case c == '\'' || c == '"':
+				quote, quoteAt = c, i
+			}
+		}
+		i = min(i, len(line))
+		if quote != 0 {
+			t.quoted = append(t.quoted, [2]int{quoteAt, i})
+		}
+		t.end = i
+		tokens = append(tokens, t)
+	}
+	return tokens
+}
+
+// paramLength returns the length of the parameter name at the start of
+// r, the text after a '$', as paramName finds it, except that an
+// unterminated "${" runs to the end of r.
+func paramLength(r []rune) int {
+	switch {
+	case len(r) == 0:
+		return 0
+	case r[0] == '{':
+		for i, c := range r {
+			if c == '}' {
+				return i + 1
+			}
+		}
+		return len(r)
+	case strings.ContainsRune("?$!-#@*0123456789", r[0]):
+		return 1
+	}
+	n := 0
+	for n < len(r) && r[n] < utf8.RuneSelf && (r[n] == '_' || isAlpha(byte(r[n])) || n > 0 && isDigit(byte(r[n]))) {
+		n++
+	}
+	return n
+}
+
+// highlightSyntax colors line as it is typed: commands by whether they
+// can be run, operators, redirections, quoted text and parameters. Words
+// that name existing files are underlined.
+func (s *Shell) highlightSyntax(line []rune) []rune {
+	styles := make([]string, len(line))
+	fill := func(from, to int, style string) {
+		for i := from; i < to; i++ {
+			styles[i] = style
+		}
+	}
+
+	// The newlines of a command of several lines end commands like ";".
+	lexed := []rune(strings.ReplaceAll(string(line), string(charNewline), ";"))
+	commandPos, target := true, false
+	for _, t := range lexSyntax(lexed) {
+		raw := string(line[t.start:t.end])
+		style, name, path := "", 0, false
+		switch {
+		case t.operator:
+			style = styleOperator
+			commandPos, target = true, false
+		case isRedirectOp(raw):
+			style = styleRedirect
+			target = true
+		case target:
+			target = false
+			path = s.isPath(raw)
+		case commandPos && isAssignment(raw):
+			name = len([]rune(raw[:strings.IndexByte(raw, '=')]))
+		case commandPos && isKeyword(raw):
+			style = styleCommand
+		case commandPos:
+			commandPos = false
+			style = styleMissing
+			if s.isCommand(raw) {
+				style = styleCommand
+			}
+		default:
+			path = s.isPath(raw)
+		}
+		fill(t.start, t.end, style)
+		fill(t.start, t.start+name, styleVariable)
+		for _, q := range t.quoted {
+			fill(q[0], q[1], styleString)
+		}
+		for _, p := range t.params {
+			fill(p[0], p[1], styleVariable)
+		}
+		if path {
+			for i := t.start; i < t.end; i++ {
+				styles[i] = strings.TrimSuffix(stylePath+";"+styles[i], ";")
+			}
+		}
+	}
+
+	var out []rune
+	cur := ""
+	for i, r := range line {
+		if r == charNewline {
+			r, styles[i] = '↵', styleNewline
+		}
+		if styles[i] != cur {
+			out = append(out, []rune("\x1b[0m")...)
+			if styles[i] != "" {
+				out = append(out, []rune("\x1b["+styles[i]+"m")...)
+			}
+			cur = styles[i]
+		}
+		out = append(out, r)
+	}
+	if cur != "" {
+		out = append(out, []rune("\x1b[0m")...)
+	}
+	return out
+}
+
+// isCommand reports whether the raw word names a builtin, a keyword or an
+// executable, by path or in PATH.
+func (s *Shell) isCommand(raw string) bool {
+	name := strings.Join(expandWord(raw, s.lookupVar), "")
+	switch {
+	case name == "":
+		return false
+	case strings.Contains(name, "/"):
+		return isExecutable(name)
+	}
+	return isBuiltin(name) || isKeyword(name) || findInPath(name) != ""
+}
+
+// isPath reports whether the raw word names an existing file.
+func (s *Shell) isPath(raw string) bool {
+	name := strings.Join(expandWord(raw, s.lookupVar), "")
+	if name == "" {
+		return false
+	}
+	_, err := os.Stat(name)
+	return err == nil
+}
@@ -0,0 +1,105 @@
+package shell
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLexSyntax(t *testing.T) {
+	line := []rune(`a "b $X" c|d&&e;f>|g 2>&1 "h ${Y`)
+	var got []string
+	for _, tok := range lexSyntax(line) {
+		got = append(got, string(line[tok.start:tok.end]))
+	}
+	want := []string{"a", `"b $X"`, "c", "|", "d", "&&", "e", ";", "f>|g", "2>&1", `"h ${Y`}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("tokens = %q, want %q", got, want)
+	}
+	toks := lexSyntax(line)
+	if q := toks[1].quoted; len(q) != 1 || q[0] != [2]int{2, 8} {
+		t.Errorf("quoted spans of %q = %v", got[1], q)
+	}
+	if p := toks[1].params; len(p) != 1 || string(line[p[0][0]:p[0][1]]) != "$X" {
+		t.Errorf("parameters of %q = %v", got[1], p)
+	}
+	last := toks[len(toks)-1]
+	if len(last.quoted) != 1 || last.quoted[0][1] != len(line) || string(line[last.params[0][0]:last.params[0][1]]) != "${Y" {
+		t.Errorf("unterminated quote and ${: quoted %v, params %v", last.quoted, last.params)
+	}
+	if toks := lexSyntax([]rune("echo 'a | b")); len(toks) != 2 || toks[1].quoted[0] != [2]int{5, 11} {
+		t.Errorf("an unterminated ' did not run to the end: %+v", toks)
+	}
+}
+
+// syntaxStyles decodes the output of highlightSyntax into one letter per
+// character of the line, for the style it is shown in.
+func syntaxStyles(painted []rune) string {
+	letters := map[string]byte{
+		"": '.', styleCommand: 'c', styleMissing: 'm', styleOperator: 'o', styleRedirect: 'r',
+		styleString: 's', styleVariable: 'v', stylePath: 'p', stylePath + ";" + styleString: 'S', stylePath + ";" + styleVariable: 'V',
+		styleNewline: 'n',
+	}
+	var sb strings.Builder
+	style := ""
+	for i := 0; i < len(painted); i++ {
+		if painted[i] == '\x1b' {
+			end := i + strings.IndexRune(string(painted[i:]), 'm')
+			if s := string(painted[i+2 : end]); s == "0" {
+				style = ""
+			} else {
+				style = s
+			}
+			i = end
+			continue
+		}
+		c, ok := letters[style]
+		if !ok {
+			c = '?'
+		}
+		sb.WriteByte(c)
+	}
+	return sb.String()
+}
+
+func TestHighlightSyntax(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	os.WriteFile("notes.txt", nil, 0644)
+	os.WriteFile("my file", nil, 0644)
+	bin := filepath.Join(dir, "bin")
+	os.Mkdir(bin, 0755)
+	os.WriteFile(filepath.Join(bin, "tool"), []byte("#!/bin/sh\n"), 0755)
+	t.Setenv("PATH", bin)
+	t.Setenv("GOSH_NOTES", "notes.txt")
+
+	s := &Shell{}
+	tests := []struct{ line, want string }{
+		{"tool notes.txt -x", "cccc.ppppppppp..."},
+		{"nope | echo hi", "mmmm.o.cccc..."},
+		{"X=1 time tool", "v...cccc.cccc"},
+		{`echo "a $HOME" 'b' 'my file' $GOSH_NOTES`, `cccc.sssvvvvvs.sss.SSSSSSSSS.VVVVVVVVVVV`},
+		{"tool > notes.txt 2>> new", "cccc.r.ppppppppp.rrr...."},
+		{"./bin/tool && ./nope", "cccccccccc.oo.mmmmmm"},
+		{`echo "unterminated $X`, `cccc.ssssssssssssssvv`},
+		{editLine("tool |\necho \\\nx\nnope"), "cccc.oncccc..n.nmmmm"},
+	}
+	for _, tt := range tests {
+		if got := syntaxStyles(s.highlightSyntax([]rune(tt.line))); got != tt.want {
+			t.Errorf("styles of %q =\n%s\nwant\n%s", tt.line, got, tt.want)
+		}
+	}
+
+	e, _ := newTestEditor()
+	if got := string(e.Paint([]rune("tool"), 4)); got != "\x1b[0m\x1b[32mtool\x1b[0m" {
+		t.Errorf("Paint = %q", got)
+	}
+	e.s.shopts = map[string]bool{"highlight": false}
+	if got := string(e.Paint([]rune("tool"), 4)); got != "tool" {
+		t.Errorf("Paint with highlight off = %q", got)
+	}
+	if got := string(e.Paint([]rune(editLine("tool |\nwc")), 0)); got != "tool |\x1b[90m↵\x1b[0mwc" {
+		t.Errorf("Paint of several lines = %q", got)
+	}
+}
@@ -0,0 +1,201 @@
+package shell
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// defaultTimeFormat is used by the time keyword when TIMEFORMAT is unset.
+// It matches bash, plus the peak resident set size.
+const defaultTimeFormat = "\nreal\t%3lR\nuser\t%3lU\nsys\t%3lS\nmaxrss\t%Mk"
+
+// posixTimeFormat is the format selected by time -p.
+const posixTimeFormat = "real %2R\nuser %2U\nsys %2S"
+
+// stageUsage is the resource usage of one finished external command.
+type stageUsage struct {
+	name   string
+	user   time.Duration
+	sys    time.Duration
+	maxRSS int64 // kilobytes
+}
+
+// usageLog collects the usage of the commands run while a time keyword is
+// active.
+type usageLog struct {
+	stages []stageUsage
+}
+
+// recordUsage adds a finished command to the active usage log, if any.
+func (s *Shell) recordUsage(parts []string, ps *os.ProcessState) {
+	if s.timing == nil || ps == nil {
+		return
+	}
+	u := stageUsage{
+		name: strings.Join(parts, " "),
+		user: ps.UserTime(),
+		sys:  ps.SystemTime(),
+	}
+	if ru, ok := ps.SysUsage().(*syscall.Rusage); ok {
+		u.maxRSS = maxRSSKilobytes(ru)
+	}
+	s.timing.stages = append(s.timing.stages, u)
+}
+
+// maxRSSKilobytes returns ru_maxrss in kilobytes; macOS reports it in bytes.
+func maxRSSKilobytes(ru *syscall.Rusage) int64 {
+	if runtime.GOOS == "darwin" {
+		return int64(ru.Maxrss) / 1024
+	}
+	return int64(ru.Maxrss)
+}
+
+// runTimed implements the time keyword. It runs the pipeline in words and
+// reports its real time and the user and system time of every stage on
+// stderr, formatted by TIMEFORMAT or, with -p, in the POSIX format.
+func (s *Shell) runTimed(words []string, stdin io.Reader, stdout, stderr io.Writer) (int, bool) {
+	posix := false
+	if len(words) > 0 && words[0] == "-p" {
+		posix = true
+		words = words[1:]
+	}
+
+	outer := s.timing
+	s.timing = &usageLog{}
+	start := time.Now()
+	status, negated := s.runWords(words, stdin, stdout, stderr)
+	real := time.Since(start)
+	log := s.timing
+	s.timing = outer
+	if outer != nil {
+		outer.stages = append(outer.stages, log.stages...)
+	}
+
+	format, custom := os.LookupEnv("TIMEFORMAT")
+	switch {
+	case posix:
+		format, custom = posixTimeFormat, true
+	case !custom:
+		format = defaultTimeFormat
+	}
+	if format != "" {
+		fmt.Fprintln(stderr, formatTime(format, real, log.stages))
+	}
+	if !custom && len(log.stages) > 1 {
+		printStages(stderr, log.stages)
+	}
+	return status, negated
+}
+
+// printStages lists the usage of each pipeline stage.
+func printStages(w io.Writer, stages []stageUsage) {
+	for i, u := range stages {
+		fmt.Fprintf(w, "  [%d] %s\tuser %s\tsys %s\tmaxrss %dk\n",
+			i+1, u.name, formatDuration(u.user, 3, true), formatDuration(u.sys, 3, true), u.maxRSS)
+	}
+}
+
+// formatTime expands a TIMEFORMAT string. As in bash, %R, %U and %S are the
+// real, user and system time, with an optional precision digit and an "l"
+// for the MMmSS.FFs form; %P is the CPU percentage. gosh adds %M, the
+// largest maximum resident set size of any stage in kilobytes.
+func formatTime(format string, real time.Duration, stages []stageUsage) string {
+	var user, sys time.Duration
+	var maxRSS int64
+	for _, u := range stages {
+		user += u.user
+		sys += u.sys
+		maxRSS = max(maxRSS, u.maxRSS)
+	}
+
+	var sb strings.Builder
+	for i := 0; i < len(format); i++ {
+		c := format[i]
+		if c == '\\' && i+1 < len(format) {
+			switch format[i+1] {
+			case 'n':
+				sb.WriteByte('\n')
+				i++
+				continue
+			case 't':
+				sb.WriteByte('\t')
+				i++
+				continue
+			}
+		}
+		if c != '%' || i+1 >= len(format) {
+			sb.WriteByte(c)
+			continue
+		}
+
+		j := i + 1
+		precision := 3
+		if isDigit(format[j]) {
+			precision = min(int(format[j]-'0'), 3)
+			j++
+		}
+		long := false
+		if j < len(format) && format[j] == 'l' {
+			long = true
+			j++
+		}
+		if j >= len(format) {
+			sb.WriteString(format[i:])
+			break
+		}
+		switch format[j] {
+		case 'R':
+			sb.WriteString(formatDuration(real, precision, long))
+		case 'U':
+			sb.WriteString(formatDuration(user, precision, long))
+		case 'S':
+			sb.WriteString(formatDuration(sys, precision, long))
+		case 'P':
+			pct := 0.0
+			if real > 0 {
+				pct = float64(user+sys) / float64(real) * 100
+			}
+			fmt.Fprintf(&sb, "%.2f", pct)
+		case 'M':
+			fmt.Fprintf(&sb, "%d", maxRSS)
+		case '%':
+			sb.WriteByte('%')
+		default:
+			sb.WriteString(format[i : j+1])
+		}
+		i = j
+	}
+	return sb.String()
+}
+
+// formatDuration formats d in seconds with the given number of decimals,
+// or in the long form "1m2.345s".
+func formatDuration(d time.Duration, precision int, long bool) string {
+	secs := d.Seconds()
+	if !long {
+		return fmt.Sprintf("%.*f", precision, secs)
+	}
+	mins := int(secs / 60)
+	return fmt.Sprintf("%dm%.*fs", mins, precision, secs-float64(mins*60))
+}
+
+// runTimes prints the accumulated user and system times of the shell and
+// of its finished children.
+func runTimes(stdout, stderr io.Writer) int {
+	for _, who := range []int{syscall.RUSAGE_SELF, syscall.RUSAGE_CHILDREN} {
+		var ru syscall.Rusage
+		if err := syscall.Getrusage(who, &ru); err != nil {
+			fmt.Fprintf(stderr, "times: %v\n", err)
+			return 1
+		}
+		fmt.Fprintf(stdout, "%s %s\n",
+			formatDuration(time.Duration(ru.Utime.Nano()), 3, true),
+			formatDuration(time.Duration(ru.Stime.Nano()), 3, true))
+	}
+	return 0
+}
@@ -0,0 +1,114 @@
+package shell
+
+import (
+	"bytes"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormatDuration(t *testing.T) {
+	tests := []struct {
+		d         time.Duration
+		precision int
+		long      bool
+		want      string
+	}{
+		{1500 * time.Millisecond, 3, false, "1.500"},
+		{1500 * time.Millisecond, 0, false, "2"},
+		{62*time.Second + 5*time.Millisecond, 3, true, "1m2.005s"},
+		{0, 2, true, "0m0.00s"},
+	}
+	for _, tt := range tests {
+		if got := formatDuration(tt.d, tt.precision, tt.long); got != tt.want {
+			t.Errorf("formatDuration(%v, %d, %v) = %q, want %q", tt.d, tt.precision, tt.long, got, tt.want)
+		}
+	}
+}
+
+func TestFormatTime(t *testing.T) {
+	stages := []stageUsage{
+		{name: "a", user: 200 * time.Millisecond, sys: 100 * time.Millisecond, maxRSS: 100},
+		{name: "b", user: 100 * time.Millisecond, sys: 100 * time.Millisecond, maxRSS: 300},
+	}
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{"%R %U %S", "1.000 0.300 0.200"},
+		{"%1R %lU", "1.0 0m0.300s"},
+		{"%P%% %Mk", "50.00% 300k"},
+		{`real\t%2R\n`, "real\t1.00\n"},
+		{"%X %", "%X %"},
+	}
+	for _, tt := range tests {
+		if got := formatTime(tt.format, time.Second, stages); got != tt.want {
+			t.Errorf("formatTime(%q) = %q, want %q", tt.format, got, tt.want)
+		}
+	}
+}
+
+func TestRunTimed(t *testing.T) {
+	f, err := os.CreateTemp(t.TempDir(), "stderr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	orig := os.Stderr
+	os.Stderr = f
+	defer func() { os.Stderr = orig }()
+
+	t.Run("default format lists stages", func(t *testing.T) {
+		f.Truncate(0)
+		f.Seek(0, 0)
+		t.Setenv("TIMEFORMAT", "") // restored after the test
+		os.Unsetenv("TIMEFORMAT")
+		s := &Shell{}
+		if status := s.execLine("time true | sh -c 'exit 2'"); status != 2 {
+			t.Errorf("status = %d, want 2", status)
+		}
+		data, _ := os.ReadFile(f.Name())
+		out := string(data)
+		for _, want := range []string{"\nreal\t0m", "\nuser\t", "\nsys\t", "\nmaxrss\t", "[1] true", "[2] sh -c exit 2"} {
+			if !strings.Contains(out, want) {
+				t.Errorf("output %q missing %q", out, want)
+			}
+		}
+		if s.timing != nil {
+			t.Error("usage log left active after time")
+		}
+	})
+
+	t.Run("posix format", func(t *testing.T) {
+		f.Truncate(0)
+		f.Seek(0, 0)
+		s := &Shell{}
+		s.execLine("time -p true")
+		data, _ := os.ReadFile(f.Name())
+		if !regexp.MustCompile(`^real \d+\.\d\d\nuser \d+\.\d\d\nsys \d+\.\d\d\n$`).Match(data) {
+			t.Errorf("output = %q", data)
+		}
+	})
+
+	t.Run("empty TIMEFORMAT is silent", func(t *testing.T) {
+		f.Truncate(0)
+		f.Seek(0, 0)
+		t.Setenv("TIMEFORMAT", "")
+		s := &Shell{}
+		s.execLine("time true")
+		if data, _ := os.ReadFile(f.Name()); len(data) != 0 {
+			t.Errorf("output = %q, want none", data)
+		}
+	})
+}
+
+func TestRunTimes(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	if status := runTimes(&stdout, &stderr); status != 0 {
+		t.Fatalf("status = %d, stderr %q", status, stderr.String())
+	}
+	if !regexp.MustCompile(`^\d+m\d+\.\d{3}s \d+m\d+\.\d{3}s\n\d+m\d+\.\d{3}s \d+m\d+\.\d{3}s\n$`).MatchString(stdout.String()) {
+		t.Errorf("output = %q", stdout.String())
+	}
+}
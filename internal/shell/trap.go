@@ -0,0 +1,261 @@
+package shell
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// signals maps signal names, without the SIG prefix, to their values.
+var signals = map[string]syscall.Signal{
+	"HUP":    syscall.SIGHUP,
+	"INT":    syscall.SIGINT,
+	"QUIT":   syscall.SIGQUIT,
+	"ILL":    syscall.SIGILL,
+	"TRAP":   syscall.SIGTRAP,
+	"ABRT":   syscall.SIGABRT,
+	"BUS":    syscall.SIGBUS,
+	"FPE":    syscall.SIGFPE,
+	"KILL":   syscall.SIGKILL,
+	"USR1":   syscall.SIGUSR1,
+	"SEGV":   syscall.SIGSEGV,
+	"USR2":   syscall.SIGUSR2,
+	"PIPE":   syscall.SIGPIPE,
+	"ALRM":   syscall.SIGALRM,
+	"TERM":   syscall.SIGTERM,
+	"CHLD":   syscall.SIGCHLD,
+	"CONT":   syscall.SIGCONT,
+	"STOP":   syscall.SIGSTOP,
+	"TSTP":   syscall.SIGTSTP,
+	"TTIN":   syscall.SIGTTIN,
+	"TTOU":   syscall.SIGTTOU,
+	"URG":    syscall.SIGURG,
+	"XCPU":   syscall.SIGXCPU,
+	"XFSZ":   syscall.SIGXFSZ,
+	"VTALRM": syscall.SIGVTALRM,
+	"PROF":   syscall.SIGPROF,
+	"WINCH":  syscall.SIGWINCH,
+	"IO":     syscall.SIGIO,
+	"SYS":    syscall.SIGSYS,
+}
+
+// pseudoSignals are trap conditions raised by the shell itself: EXIT when
+// the shell exits, ERR after a command fails and DEBUG before each command.
+var pseudoSignals = []string{"EXIT", "ERR", "DEBUG"}
+
+// parseSignal accepts a signal number or a name with or without the SIG
+// prefix, in any case.
+func parseSignal(spec string) (syscall.Signal, bool) {
+	if n, err := strconv.Atoi(spec); err == nil {
+		if n == 0 {
+			return 0, true
+		}
+		for _, sig := range signals {
+			if int(sig) == n {
+				return sig, true
+			}
+		}
+		return 0, false
+	}
+	sig, ok := signals[strings.TrimPrefix(strings.ToUpper(spec), "SIG")]
+	return sig, ok
+}
+
+// signalName returns the name of sig without the SIG prefix.
+func signalName(sig syscall.Signal) string {
+	for name, s := range signals {
+		if s == sig {
+			return name
+		}
+	}
+	return strconv.Itoa(int(sig))
+}
+
+// listSignals implements kill -l and trap -l. Without arguments it prints
+// the signal table; otherwise it translates numbers (or exit statuses above
+// 128) to names and names to numbers.
+func listSignals(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		sigs := make([]syscall.Signal, 0, len(signals))
+		for _, sig := range signals {
+			sigs = append(sigs, sig)
+		}
+		sort.Slice(sigs, func(i, j int) bool { return sigs[i] < sigs[j] })
+		for i, sig := range sigs {
+			sep := "\t"
+			if i%5 == 4 || i == len(sigs)-1 {
+				sep = "\n"
+			}
+			fmt.Fprintf(stdout, "%2d) SIG%-8s%s", int(sig), signalName(sig), sep)
+		}
+		return 0
+	}
+
+	status := 0
+	for _, arg := range args {
+		if n, err := strconv.Atoi(arg); err == nil {
+			if n > 128 {
+				n -= 128
+			}
+			if sig, ok := parseSignal(strconv.Itoa(n)); ok && sig != 0 {
+				fmt.Fprintln(stdout, signalName(sig))
+				continue
+			}
+		} else if sig, ok := parseSignal(arg); ok {
+			fmt.Fprintln(stdout, int(sig))
+			continue
+		}
+		fmt.Fprintf(stderr, "%s: invalid signal specification\n", arg)
+		status = 1
+	}
+	return status
+}
+
+// trapName normalizes a trap condition to its canonical name: a pseudo
+// signal or a signal name without the SIG prefix. "0" is EXIT.
+func trapName(spec string) (string, bool) {
+	upper := strings.ToUpper(spec)
+	if upper == "0" {
+		return "EXIT", true
+	}
+	for _, p := range pseudoSignals {
+		if upper == p {
+			return p, true
+		}
+	}
+	sig, ok := parseSignal(spec)
+	if !ok || sig == 0 {
+		return "", false
+	}
+	return signalName(sig), true
+}
+
+// runTrap implements the trap builtin.
+func (s *Shell) runTrap(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 || args[0] == "-p" {
+		s.printTraps(args, stdout)
+		return 0
+	}
+	if args[0] == "-l" {
+		return listSignals(nil, stdout, stderr)
+	}
+	if args[0] == "--" {
+		args = args[1:]
+	}
+
+	action, conds := args[0], args[1:]
+	if len(conds) == 0 {
+		// "trap SIG" resets SIG, like "trap - SIG".
+		action, conds = "-", args
+	}
+
+	status := 0
+	for _, cond := range conds {
+		name, ok := trapName(cond)
+		if !ok {
+			fmt.Fprintf(stderr, "trap: %s: invalid signal specification\n", cond)
+			status = 1
+			continue
+		}
+		if name == "KILL" || name == "STOP" {
+			fmt.Fprintf(stderr, "trap: %s: cannot be trapped\n", cond)
+			status = 1
+			continue
+		}
+		s.setTrap(name, action)
+	}
+	return status
+}
+
+// setTrap installs action for a trap condition. "-" restores the default
+// disposition and an empty action ignores the signal.
+func (s *Shell) setTrap(name, action string) {
+	if s.traps == nil {
+		s.traps = map[string]string{}
+	}
+	if action == "-" {
+		delete(s.traps, name)
+	} else {
+		s.traps[name] = action
+	}
+
+	// Signal dispositions belong to the process, so a subshell only
+	// records the trap.
+	sig, ok := signals[name]
+	if !ok || s.inSubshell {
+		return
+	}
+	switch action {
+	case "-":
+		signal.Reset(sig)
+	case "":
+		signal.Ignore(sig)
+	default:
+		if s.sigCh == nil {
+			s.sigCh = make(chan os.Signal, 16)
+		}
+		signal.Notify(s.sigCh, sig)
+	}
+}
+
+func (s *Shell) printTraps(args []string, stdout io.Writer) {
+	var names []string
+	if len(args) > 1 {
+		for _, a := range args[1:] {
+			if name, ok := trapName(a); ok {
+				names = append(names, name)
+			}
+		}
+	} else {
+		for name := range s.traps {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+	for _, name := range names {
+		if action, ok := s.traps[name]; ok {
+			fmt.Fprintf(stdout, "trap -- '%s' %s\n", strings.ReplaceAll(action, "'", `'\''`), name)
+		}
+	}
+}
+
+// fireTrap runs the handler registered for a trap condition, if any. The
+// exit status of the interrupted command is preserved, and handlers do not
+// trigger further traps while they run.
+func (s *Shell) fireTrap(name string) {
+	action := s.traps[name]
+	if action == "" || s.inTrap {
+		return
+	}
+	status := s.lastStatus
+	s.inTrap = true
+	s.execLine(action)
+	s.inTrap = false
+	if !s.exiting {
+		s.lastStatus = status
+	}
+}
+
+// runPendingTraps runs the handlers for signals that arrived since the last
+// check. Signals are only acted on between commands, and those that arrive
+// while a handler or hook runs wait until it is done.
+func (s *Shell) runPendingTraps() {
+	if s.inTrap {
+		return
+	}
+	for {
+		select {
+		case sig := <-s.sigCh:
+			if sig, ok := sig.(syscall.Signal); ok {
+				s.fireTrap(signalName(sig))
+			}
+		default:
+			return
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package shell
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestParseSignal(t *testing.T) {
+	tests := []struct {
+		spec string
+		want syscall.Signal
+		ok   bool
+	}{
+		{"TERM", syscall.SIGTERM, true},
+		{"sigint", syscall.SIGINT, true},
+		{"9", syscall.SIGKILL, true},
+		{"0", 0, true},
+		{"NOPE", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseSignal(tt.spec)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("parseSignal(%q) = %v, %v, want %v, %v", tt.spec, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestListSignals(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	listSignals([]string{"143", "KILL"}, &stdout, &stderr)
+	if stdout.String() != "TERM\n9\n" {
+		t.Errorf("got %q, want %q", stdout.String(), "TERM\n9\n")
+	}
+
+	stdout.Reset()
+	listSignals(nil, &stdout, &stderr)
+	if !strings.Contains(stdout.String(), "SIGHUP") || !strings.Contains(stdout.String(), "SIGTERM") {
+		t.Errorf("signal table missing entries: %q", stdout.String())
+	}
+}
+
+func TestTrapPrint(t *testing.T) {
+	s := &Shell{}
+	var stdout, stderr bytes.Buffer
+	s.runTrap([]string{"echo it's done", "EXIT", "0"}, &stdout, &stderr)
+	s.runTrap(nil, &stdout, &stderr)
+	want := `trap -- 'echo it'\''s done' EXIT` + "\n"
+	if stdout.String() != want {
+		t.Errorf("got %q, want %q", stdout.String(), want)
+	}
+
+	if status := s.runTrap([]string{"echo", "KILL"}, &stdout, &stderr); status != 1 {
+		t.Errorf("trapping KILL returned %d, want 1", status)
+	}
+
+	s.runTrap([]string{"-", "EXIT"}, &stdout, &stderr)
+	if _, ok := s.traps["EXIT"]; ok {
+		t.Error("EXIT trap not reset")
+	}
+}
+
+func TestTrapPseudoSignals(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out")
+	s := &Shell{}
+	s.execLine("trap 'echo err $? >> " + out + "' ERR")
+	s.execLine("trap 'echo debug >> " + out + "' DEBUG")
+	status := s.execLine("false || true; false")
+
+	data, _ := os.ReadFile(out)
+	if got, want := string(data), "debug\ndebug\ndebug\nerr 1\n"; got != want {
+		t.Errorf("trap output = %q, want %q", got, want)
+	}
+	if status != 1 {
+		t.Errorf("status = %d, want 1 (trap must not change $?)", status)
+	}
+}
+
+func TestTrapSignal(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out")
+	s := &Shell{}
+	s.execLine("trap 'echo caught > " + out + "' USR1")
+	defer s.setTrap("USR1", "-")
+
+	syscall.Kill(os.Getpid(), syscall.SIGUSR1)
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		s.runPendingTraps()
+		if data, _ := os.ReadFile(out); string(data) == "caught\n" {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Error("USR1 trap did not run")
+}
@@ -0,0 +1,178 @@
+package shell
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"syscall"
+)
+
+// ulimitResource describes a resource limit managed by ulimit. Limits are
+// shown and set in units of scale bytes (or in seconds or counts when scale
+// is 1).
+type ulimitResource struct {
+	flag     byte
+	resource int
+	desc     string
+	unit     string
+	scale    uint64
+}
+
+var ulimitResources = []ulimitResource{
+	{'c', syscall.RLIMIT_CORE, "core file size", "blocks", 512},
+	{'d', syscall.RLIMIT_DATA, "data seg size", "kbytes", 1024},
+	{'f', syscall.RLIMIT_FSIZE, "file size", "blocks", 512},
+	{'n', syscall.RLIMIT_NOFILE, "open files", "", 1},
+	{'s', syscall.RLIMIT_STACK, "stack size", "kbytes", 1024},
+	{'t', syscall.RLIMIT_CPU, "cpu time", "seconds", 1},
+	{'u', rlimitNproc, "max user processes", "", 1},
+	{'v', syscall.RLIMIT_AS, "virtual memory", "kbytes", 1024},
+}
+
+func lookupUlimitFlag(flag byte) (ulimitResource, bool) {
+	for _, r := range ulimitResources {
+		if r.flag == flag {
+			return r, true
+		}
+	}
+	return ulimitResource{}, false
+}
+
+// runUlimit implements ulimit [-SHa] [-cdfnstuv] [limit]. The limits are set
+// on the shell process itself, so every command it starts inherits them.
+// Without -S or -H a new limit sets both the soft and the hard limit, and the
+// soft limit is the one displayed.
+func runUlimit(args []string, stdout, stderr io.Writer) int {
+	var soft, hard, all bool
+	var selected []ulimitResource
+	for len(args) > 0 && len(args[0]) > 1 && args[0][0] == '-' {
+		if args[0] == "--" {
+			args = args[1:]
+			break
+		}
+		for _, c := range []byte(args[0][1:]) {
+			switch c {
+			case 'S':
+				soft = true
+			case 'H':
+				hard = true
+			case 'a':
+				all = true
+			default:
+				r, ok := lookupUlimitFlag(c)
+				if !ok {
+					fmt.Fprintf(stderr, "ulimit: -%c: invalid option\n", c)
+					fmt.Fprintln(stderr, "ulimit: usage: ulimit [-SHa] [-cdfnstuv] [limit]")
+					return 2
+				}
+				selected = append(selected, r)
+			}
+		}
+		args = args[1:]
+	}
+	if all {
+		selected = ulimitResources
+	}
+	if len(selected) == 0 {
+		r, _ := lookupUlimitFlag('f')
+		selected = []ulimitResource{r}
+	}
+
+	if len(args) == 0 {
+		status := 0
+		for _, r := range selected {
+			var lim syscall.Rlimit
+			if err := syscall.Getrlimit(r.resource, &lim); err != nil {
+				fmt.Fprintf(stderr, "ulimit: %s: cannot get limit: %v\n", r.desc, err)
+				status = 1
+				continue
+			}
+			value := lim.Cur
+			if hard {
+				value = lim.Max
+			}
+			if len(selected) == 1 {
+				fmt.Fprintln(stdout, formatLimit(value, r.scale))
+				continue
+			}
+			label := fmt.Sprintf("(-%c)", r.flag)
+			if r.unit != "" {
+				label = fmt.Sprintf("(%s, -%c)", r.unit, r.flag)
+			}
+			fmt.Fprintf(stdout, "%-20s %16s %s\n", r.desc, label, formatLimit(value, r.scale))
+		}
+		return status
+	}
+
+	if all || len(selected) > 1 || len(args) > 1 {
+		fmt.Fprintln(stderr, "ulimit: too many arguments")
+		return 2
+	}
+	if !soft && !hard {
+		soft, hard = true, true
+	}
+	if err := setLimit(selected[0], args[0], soft, hard); err != nil {
+		fmt.Fprintf(stderr, "ulimit: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// setLimit changes the soft and/or hard limit of r to value, which is a
+// number in r's units, "unlimited", "hard" or "soft".
+func setLimit(r ulimitResource, value string, soft, hard bool) error {
+	var lim syscall.Rlimit
+	if err := syscall.Getrlimit(r.resource, &lim); err != nil {
+		return fmt.Errorf("%s: cannot get limit: %v", r.desc, err)
+	}
+
+	var v uint64
+	switch value {
+	case "unlimited":
+		v = rlimitInfinity
+	case "hard":
+		v = lim.Max
+	case "soft":
+		v = lim.Cur
+	default:
+		n, err := strconv.ParseUint(value, 10, 64)
+		if err != nil || n > rlimitInfinity/r.scale {
+			return fmt.Errorf("%s: invalid number", value)
+		}
+		v = n * r.scale
+	}
+
+	next := lim
+	if soft {
+		next.Cur = v
+	}
+	if hard {
+		next.Max = v
+		// Lowering only the hard limit also lowers a soft limit above it.
+		if !soft && next.Cur > v {
+			next.Cur = v
+		}
+	}
+	if next.Cur > next.Max {
+		return fmt.Errorf("%s: limit %s exceeds hard limit %s", r.desc,
+			formatLimit(next.Cur, r.scale), formatLimit(next.Max, r.scale))
+	}
+
+	if err := syscall.Setrlimit(r.resource, &next); err != nil {
+		if errors.Is(err, syscall.EPERM) && next.Max > lim.Max {
+			return fmt.Errorf("%s: cannot raise hard limit above %s: %v", r.desc,
+				formatLimit(lim.Max, r.scale), err)
+		}
+		return fmt.Errorf("%s: cannot modify limit: %v", r.desc, err)
+	}
+	return nil
+}
+
+// formatLimit renders a limit in units of scale, or "unlimited".
+func formatLimit(v, scale uint64) string {
+	if v == rlimitInfinity {
+		return "unlimited"
+	}
+	return strconv.FormatUint(v/scale, 10)
+}
@@ -0,0 +1,147 @@
+package shell
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// saveLimit restores a resource limit when the test finishes.
+func saveLimit(t *testing.T, resource int) syscall.Rlimit {
+	t.Helper()
+	var lim syscall.Rlimit
+	if err := syscall.Getrlimit(resource, &lim); err != nil {
+		t.Fatalf("Getrlimit: %v", err)
+	}
+	t.Cleanup(func() { syscall.Setrlimit(resource, &lim) })
+	return lim
+}
+
+func TestFormatLimit(t *testing.T) {
+	tests := []struct {
+		v, scale uint64
+		want     string
+	}{
+		{rlimitInfinity, 1024, "unlimited"},
+		{1024 * 1024, 1024, "1024"},
+		{0, 512, "0"},
+		{256, 1, "256"},
+	}
+	for _, tt := range tests {
+		if got := formatLimit(tt.v, tt.scale); got != tt.want {
+			t.Errorf("formatLimit(%d, %d) = %q, want %q", tt.v, tt.scale, got, tt.want)
+		}
+	}
+}
+
+func TestUlimitAll(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	if status := runUlimit([]string{"-a"}, &stdout, &stderr); status != 0 {
+		t.Fatalf("ulimit -a = %d, stderr %q", status, stderr.String())
+	}
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	if len(lines) != len(ulimitResources) {
+		t.Fatalf("ulimit -a printed %d lines, want %d:\n%s", len(lines), len(ulimitResources), stdout.String())
+	}
+	if !strings.Contains(stdout.String(), "(-n)") || !strings.Contains(stdout.String(), "(kbytes, -v)") {
+		t.Errorf("ulimit -a output missing flags:\n%s", stdout.String())
+	}
+}
+
+func TestUlimitSetSoft(t *testing.T) {
+	orig := saveLimit(t, syscall.RLIMIT_NOFILE)
+	want := min(orig.Cur, 256) - 1
+
+	var stdout, stderr bytes.Buffer
+	if status := runUlimit([]string{"-S", "-n", strconv.FormatUint(want, 10)}, &stdout, &stderr); status != 0 {
+		t.Fatalf("ulimit -Sn = %d, stderr %q", status, stderr.String())
+	}
+	var lim syscall.Rlimit
+	syscall.Getrlimit(syscall.RLIMIT_NOFILE, &lim)
+	if lim.Cur != want || lim.Max != orig.Max {
+		t.Errorf("limit = %d/%d, want %d/%d", lim.Cur, lim.Max, want, orig.Max)
+	}
+
+	stdout.Reset()
+	runUlimit([]string{"-n"}, &stdout, &stderr)
+	if got := strings.TrimSpace(stdout.String()); got != strconv.FormatUint(want, 10) {
+		t.Errorf("ulimit -n = %q, want %d", got, want)
+	}
+}
+
+func TestUlimitSoftAboveHard(t *testing.T) {
+	// Only root can raise a hard limit again, so the limit is lowered in a
+	// child copy of the test binary rather than in this process.
+	if os.Getenv("GOSH_TEST_LOWER_CPU_LIMIT") == "" {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestUlimitSoftAboveHard$")
+		cmd.Env = append(os.Environ(), "GOSH_TEST_LOWER_CPU_LIMIT=1")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("child test: %v\n%s", err, out)
+		}
+		return
+	}
+
+	var orig syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_CPU, &orig); err != nil {
+		t.Fatalf("Getrlimit: %v", err)
+	}
+	if orig.Max == rlimitInfinity {
+		lowered := syscall.Rlimit{Cur: 1000, Max: 1000}
+		if err := syscall.Setrlimit(syscall.RLIMIT_CPU, &lowered); err != nil {
+			t.Fatalf("Setrlimit: %v", err)
+		}
+	}
+	var stdout, stderr bytes.Buffer
+	if status := runUlimit([]string{"-St", "unlimited"}, &stdout, &stderr); status != 1 {
+		t.Errorf("ulimit -St unlimited = %d, want 1", status)
+	}
+	if !strings.Contains(stderr.String(), "exceeds hard limit") {
+		t.Errorf("stderr = %q, want hard limit error", stderr.String())
+	}
+}
+
+func TestUlimitInvalid(t *testing.T) {
+	tests := []struct {
+		args   []string
+		status int
+	}{
+		{[]string{"-z"}, 2},
+		{[]string{"-n", "lots"}, 1},
+		{[]string{"-a", "10"}, 2},
+		{[]string{"-n", "1", "2"}, 2},
+	}
+	for _, tt := range tests {
+		var stdout, stderr bytes.Buffer
+		if got := runUlimit(tt.args, &stdout, &stderr); got != tt.status {
+			t.Errorf("ulimit %v = %d, want %d", tt.args, got, tt.status)
+		}
+	}
+}
+
+func TestUlimitInheritedByChildren(t *testing.T) {
+	orig := saveLimit(t, syscall.RLIMIT_NOFILE)
+	want := strconv.FormatUint(min(orig.Cur, 256)-1, 10)
+
+	s := &Shell{}
+	var stderr bytes.Buffer
+	if status := runUlimit([]string{"-Sn", want}, &bytes.Buffer{}, &stderr); status != 0 {
+		t.Fatalf("ulimit -Sn = %d, stderr %q", status, stderr.String())
+	}
+
+	var stdout bytes.Buffer
+	status, _ := runExternal([]string{"sh", "-c", "ulimit -n"}, nil, &stdout, &stderr)
+	if status != 0 || strings.TrimSpace(stdout.String()) != want {
+		t.Errorf("child ulimit -n = %q (status %d), want %s", stdout.String(), status, want)
+	}
+
+	stdout.Reset()
+	s.runPipeline([][]string{{"sh", "-c", "ulimit -n"}, {"cat"}}, os.Stdin, &stdout, io.Discard)
+	if got := strings.TrimSpace(stdout.String()); got != want {
+		t.Errorf("pipeline ulimit -n = %q, want %s", got, want)
+	}
+}
@@ -0,0 +1,207 @@
+package shell
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// lookupVar resolves a parameter for expansion. Special parameters come
+// from shell state; everything else is read from the environment, which
+// holds all shell variables.
+func (s *Shell) lookupVar(name string) (string, bool) {
+	switch name {
+	case "?":
+		return strconv.Itoa(s.lastStatus), true
+	case "$":
+		return strconv.Itoa(os.Getpid()), true
+	case "-":
+		return s.optionFlags(), true
+	case "!":
+		if s.lastBgPid == 0 {
+			return "", false
+		}
+		return strconv.Itoa(s.lastBgPid), true
+	case "#":
+		return strconv.Itoa(len(s.params)), true
+	case "@", "*":
+		return strings.Join(s.params, " "), true
+	}
+	if n, err := strconv.Atoi(name); err == nil && n > 0 {
+		if n > len(s.params) {
+			return "", false
+		}
+		return s.params[n-1], true
+	}
+	return os.LookupEnv(name)
+}
+
+// expandWords expands the raw words of one command into its arguments.
+// With set -u, expanding an unset variable is an error.
+func (s *Shell) expandWords(words []string) ([]string, error) {
+	var unbound string
+	lookup := func(name string) (string, bool) {
+		value, ok := s.lookupVar(name)
+		if !ok && unbound == "" && s.option("nounset") {
+			unbound = name
+		}
+		return value, ok
+	}
+	var parts []string
+	for _, w := range words {
+		parts = append(parts, expandWord(w, lookup)...)
+	}
+	if unbound != "" {
+		return nil, fmt.Errorf("%s: unbound variable", unbound)
+	}
+	return parts, nil
+}
+
+// isAssignment reports whether a raw word has the form NAME=value.
+func isAssignment(word string) bool {
+	eq := strings.IndexByte(word, '=')
+	return eq > 0 && isName(word[:eq])
+}
+
+func isName(s string) bool {
+	if s == "" || isDigit(s[0]) {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] != '_' && !isAlpha(s[i]) && !isDigit(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// splitAssignments separates leading NAME=value words from the rest of a
+// command.
+func splitAssignments(words []string) (assigns, rest []string) {
+	i := 0
+	for i < len(words) && isAssignment(words[i]) {
+		i++
+	}
+	return words[:i], words[i:]
+}
+
+// assignValue expands the value of a raw assignment word. Unlike command
+// arguments, the value is never split into several fields. With set -u,
+// expanding an unset variable is an error.
+func (s *Shell) assignValue(word string) (name, value string, err error) {
+	eq := strings.IndexByte(word, '=')
+	fields, err := s.expandWords([]string{word[eq+1:]})
+	return word[:eq], strings.Join(fields, " "), err
+}
+
+// assign sets each NAME=value word as a shell variable, stopping at the
+// first value that cannot be expanded.
+func (s *Shell) assign(assigns []string) error {
+	for _, a := range assigns {
+		name, value, err := s.assignValue(a)
+		if err != nil {
+			return err
+		}
+		os.Setenv(name, value)
+	}
+	return nil
+}
+
+// restoreEnv resets the environment to env, a copy taken with os.Environ,
+// removing the variables set since.
+func restoreEnv(env []string) {
+	saved := make(map[string]string, len(env))
+	for _, kv := range env {
+		name, value, _ := strings.Cut(kv, "=")
+		saved[name] = value
+	}
+	for _, kv := range os.Environ() {
+		name, value, _ := strings.Cut(kv, "=")
+		if old, ok := saved[name]; !ok {
+			os.Unsetenv(name)
+		} else if old != value {
+			os.Setenv(name, old)
+		}
+	}
+	for name, value := range saved {
+		if _, ok := os.LookupEnv(name); !ok {
+			os.Setenv(name, value)
+		}
+	}
+}
+
+// assignTemporary sets variables for the duration of one command and
+// returns a function that restores the previous values. If a value cannot
+// be expanded it stops there, and restore undoes what was set before.
+func (s *Shell) assignTemporary(assigns []string) (restore func(), err error) {
+	type saved struct {
+		name, value string
+		ok          bool
+	}
+	var prev []saved
+	for _, a := range assigns {
+		var name, value string
+		name, value, err = s.assignValue(a)
+		if err != nil {
+			break
+		}
+		old, ok := os.LookupEnv(name)
+		prev = append(prev, saved{name, old, ok})
+		os.Setenv(name, value)
+	}
+	return func() {
+		for i := len(prev) - 1; i >= 0; i-- {
+			if prev[i].ok {
+				os.Setenv(prev[i].name, prev[i].value)
+			} else {
+				os.Unsetenv(prev[i].name)
+			}
+		}
+	}, err
+}
+
+func runExport(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 || args[0] == "-p" {
+		env := os.Environ()
+		sort.Strings(env)
+		for _, kv := range env {
+			name, value, _ := strings.Cut(kv, "=")
+			fmt.Fprintf(stdout, "export %s=%s\n", name, strconv.Quote(value))
+		}
+		return 0
+	}
+	status := 0
+	for _, arg := range args {
+		name, value, hasValue := strings.Cut(arg, "=")
+		if !isName(name) {
+			fmt.Fprintf(stderr, "export: `%s': not a valid identifier\n", arg)
+			status = 1
+			continue
+		}
+		if hasValue {
+			os.Setenv(name, value)
+		} else if _, ok := os.LookupEnv(name); !ok {
+			os.Setenv(name, "")
+		}
+	}
+	return status
+}
+
+func runUnset(args []string, stderr io.Writer) int {
+	status := 0
+	for _, name := range args {
+		if name == "-v" {
+			continue
+		}
+		if !isName(name) {
+			fmt.Fprintf(stderr, "unset: `%s': not a valid identifier\n", name)
+			status = 1
+			continue
+		}
+		os.Unsetenv(name)
+	}
+	return status
+}
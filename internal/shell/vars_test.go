@@ -0,0 +1,105 @@
+package shell
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLookupVar(t *testing.T) {
+	t.Setenv("GOSH_TEST_VAR", "value")
+	s := &Shell{lastStatus: 3, lastBgPid: 42, params: []string{"a", "b c"}}
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"?", "3"},
+		{"!", "42"},
+		{"GOSH_TEST_VAR", "value"},
+		{"1", "a"},
+		{"2", "b c"},
+		{"#", "2"},
+		{"@", "a b c"},
+	}
+	for _, tt := range tests {
+		if got, _ := s.lookupVar(tt.name); got != tt.want {
+			t.Errorf("lookupVar(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+	if _, ok := (&Shell{}).lookupVar("!"); ok {
+		t.Error("$! should be unset before any background job")
+	}
+	if _, ok := s.lookupVar("3"); ok {
+		t.Error("$3 should be unset with two parameters")
+	}
+}
+
+func TestSplitAssignments(t *testing.T) {
+	assigns, rest := splitAssignments([]string{"A=1", "B_2=x", "cmd", "C=3"})
+	if !reflect.DeepEqual(assigns, []string{"A=1", "B_2=x"}) {
+		t.Errorf("assigns = %v", assigns)
+	}
+	if !reflect.DeepEqual(rest, []string{"cmd", "C=3"}) {
+		t.Errorf("rest = %v", rest)
+	}
+	for _, w := range []string{"=x", "1A=x", "A-B=x", "'A'=x"} {
+		if isAssignment(w) {
+			t.Errorf("isAssignment(%q) = true, want false", w)
+		}
+	}
+}
+
+func TestAssignments(t *testing.T) {
+	t.Run("bare assignment sets variable", func(t *testing.T) {
+		t.Setenv("GOSH_A", "")
+		s := &Shell{}
+		s.execLine(`GOSH_A="x  y"`)
+		if got := os.Getenv("GOSH_A"); got != "x  y" {
+			t.Errorf("GOSH_A = %q, want %q", got, "x  y")
+		}
+	})
+
+	t.Run("prefix assignment is temporary", func(t *testing.T) {
+		t.Setenv("GOSH_B", "old")
+		var out bytes.Buffer
+		s := &Shell{}
+		restore, _ := s.assignTemporary([]string{"GOSH_B=new"})
+		s.dispatch([]string{"sh", "-c", "echo $GOSH_B"}, nil, &out, &out)
+		restore()
+		if strings.TrimSpace(out.String()) != "new" {
+			t.Errorf("child saw %q, want %q", out.String(), "new")
+		}
+		if got := os.Getenv("GOSH_B"); got != "old" {
+			t.Errorf("GOSH_B = %q after command, want %q", got, "old")
+		}
+	})
+}
+
+func TestRunExportUnset(t *testing.T) {
+	t.Setenv("GOSH_EXPORTED", "")
+	var stdout, stderr bytes.Buffer
+
+	if status := runExport([]string{"GOSH_EXPORTED=1"}, &stdout, &stderr); status != 0 {
+		t.Fatalf("export status = %d, stderr %q", status, stderr.String())
+	}
+	if os.Getenv("GOSH_EXPORTED") != "1" {
+		t.Errorf("GOSH_EXPORTED = %q, want 1", os.Getenv("GOSH_EXPORTED"))
+	}
+
+	runExport(nil, &stdout, &stderr)
+	if !strings.Contains(stdout.String(), `export GOSH_EXPORTED="1"`) {
+		t.Errorf("export listing missing variable: %q", stdout.String())
+	}
+
+	if status := runExport([]string{"1BAD=x"}, &stdout, &stderr); status != 1 {
+		t.Errorf("export of invalid name returned %d, want 1", status)
+	}
+
+	runUnset([]string{"GOSH_EXPORTED"}, &stderr)
+	if _, ok := os.LookupEnv("GOSH_EXPORTED"); ok {
+		t.Error("GOSH_EXPORTED still set after unset")
+	}
+}